@@ -0,0 +1,245 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package capture
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"strconv"
+	"time"
+)
+
+// APDU an APDU reassembled from the tcp stream
+type APDU struct {
+	Time       time.Time // time of the packet completes the APDU
+	Src        Endpoint
+	Dst        Endpoint
+	FromMaster bool // true: 控制站 -> 被控站
+	Raw        []byte
+}
+
+// maxPendingSegments the limit of the out of order segments of a flow,
+// beyond it the missing data is treated as lost.
+const maxPendingSegments = 64
+
+// flow one direction of a tcp connection
+type flow struct {
+	started bool
+	next    uint32            // next expected sequence number
+	pending map[uint32][]byte // out of order segments
+	buf     []byte            // bytes not yet parsed as APDU
+}
+
+// Assembler reassemble the tcp streams of the outstation port and split them into APDUs
+type Assembler struct {
+	port  uint16
+	flows map[string]*flow
+}
+
+// NewAssembler new an assembler of the streams with the outstation port, 0 use 2404
+func NewAssembler(port uint16) *Assembler {
+	if port == 0 {
+		port = 2404
+	}
+	return &Assembler{port: port, flows: make(map[string]*flow)}
+}
+
+// Feed feed a packet, return the APDUs completed by it
+func (sf *Assembler) Feed(pkt Packet) []APDU {
+	src, dst, tcp, ok := decodeTCP(pkt.LinkType, pkt.Data)
+	if !ok || len(tcp) < tcpHeaderSize {
+		return nil
+	}
+	src.Port, dst.Port = binary.BigEndian.Uint16(tcp[0:]), binary.BigEndian.Uint16(tcp[2:])
+	if src.Port != sf.port && dst.Port != sf.port {
+		return nil
+	}
+	off := int(tcp[12]>>4) * 4
+	if off < tcpHeaderSize || off > len(tcp) {
+		return nil
+	}
+	seq, flags, payload := binary.BigEndian.Uint32(tcp[4:]), tcp[13], tcp[off:]
+
+	key := endpointString(src) + ">" + endpointString(dst)
+	f, ok := sf.flows[key]
+	if !ok {
+		f = &flow{pending: make(map[uint32][]byte)}
+		sf.flows[key] = f
+	}
+	if flags&0x02 != 0 { // SYN, 新的连接
+		*f = flow{started: true, next: seq + 1, pending: make(map[uint32][]byte)}
+		return nil
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+	if !f.started {
+		f.started, f.next = true, seq
+	}
+	f.add(seq, payload)
+
+	var apdus []APDU
+	for {
+		raw := f.nextAPDU()
+		if raw == nil {
+			break
+		}
+		apdus = append(apdus, APDU{pkt.Time, src, dst, dst.Port == sf.port, raw})
+	}
+	return apdus
+}
+
+// add add the segment to the flow
+func (sf *flow) add(seq uint32, payload []byte) {
+	if diff := int32(seq - sf.next); diff > 0 {
+		sf.pending[seq] = append([]byte(nil), payload...)
+		if len(sf.pending) > maxPendingSegments {
+			sf.skipGap()
+		}
+		return
+	} else if -int(diff) >= len(payload) {
+		return // retransmission
+	} else {
+		payload = payload[-diff:]
+	}
+	sf.buf = append(sf.buf, payload...)
+	sf.next += uint32(len(payload))
+	for {
+		found := false
+		for s, p := range sf.pending {
+			diff := int32(s - sf.next)
+			if diff > 0 {
+				continue
+			}
+			delete(sf.pending, s)
+			if -int(diff) < len(p) {
+				sf.buf = append(sf.buf, p[-diff:]...)
+				sf.next += uint32(len(p) + int(diff))
+				found = true
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+// skipGap the missing data is lost, resync from the earliest pending segment
+func (sf *flow) skipGap() {
+	first, min := sf.next, int32(math.MaxInt32)
+	for s := range sf.pending {
+		if d := int32(s - sf.next); d < min {
+			first, min = s, d
+		}
+	}
+	p := sf.pending[first]
+	delete(sf.pending, first)
+	sf.buf, sf.next = nil, first
+	sf.add(first, p)
+}
+
+// nextAPDU split an APDU from the buffer, the bytes before the start byte are dropped
+func (sf *flow) nextAPDU() []byte {
+	for len(sf.buf) >= 2 {
+		if sf.buf[0] != 0x68 || sf.buf[1] < 4 {
+			sf.buf = sf.buf[1:]
+			continue
+		}
+		n := 2 + int(sf.buf[1])
+		if len(sf.buf) < n {
+			return nil
+		}
+		raw := append([]byte(nil), sf.buf[:n]...)
+		sf.buf = sf.buf[n:]
+		return raw
+	}
+	return nil
+}
+
+// decodeTCP decode the link and ip layer, return the tcp segment
+func decodeTCP(linkType int, data []byte) (src, dst Endpoint, tcp []byte, ok bool) {
+	var ip []byte
+	switch linkType {
+	case LinkTypeEthernet:
+		if len(data) < ethernetHeaderSize {
+			return
+		}
+		etherType, off := binary.BigEndian.Uint16(data[12:]), ethernetHeaderSize
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= off+4 { // vlan
+			etherType, off = binary.BigEndian.Uint16(data[off+2:]), off+4
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return
+		}
+		ip = data[off:]
+	case LinkTypeNull:
+		if len(data) < 4 {
+			return
+		}
+		ip = data[4:]
+	case LinkTypeRaw, LinkTypeIPv4, LinkTypeIPv6, 12, 14:
+		ip = data
+	case LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return
+		}
+		ip = data[16:]
+	case LinkTypeSLL2:
+		if len(data) < 20 {
+			return
+		}
+		ip = data[20:]
+	default:
+		return
+	}
+	if len(ip) < 1 {
+		return
+	}
+	switch ip[0] >> 4 {
+	case 4:
+		if len(ip) < ipv4HeaderSize {
+			return
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		total := int(binary.BigEndian.Uint16(ip[2:]))
+		if ip[9] != 6 || ihl < ipv4HeaderSize || total < ihl || total > len(ip) {
+			return
+		}
+		if binary.BigEndian.Uint16(ip[6:])&0x3fff != 0 { // fragment
+			return
+		}
+		src.IP, dst.IP = net.IP(ip[12:16]), net.IP(ip[16:20])
+		return src, dst, ip[ihl:total], true
+	case 6:
+		if len(ip) < ipv6HeaderSize {
+			return
+		}
+		total := ipv6HeaderSize + int(binary.BigEndian.Uint16(ip[4:]))
+		if total > len(ip) {
+			return
+		}
+		next, off := ip[6], ipv6HeaderSize
+		for next == 0 || next == 43 || next == 60 { // hop-by-hop, routing, destination options
+			if len(ip) < off+8 {
+				return
+			}
+			next, off = ip[off], off+8+int(ip[off+1])*8
+		}
+		if next != 6 || off > total {
+			return
+		}
+		src.IP, dst.IP = net.IP(ip[8:24]), net.IP(ip[24:40])
+		return src, dst, ip[off:total], true
+	}
+	return
+}
+
+func endpointString(e Endpoint) string {
+	return net.JoinHostPort(e.IP.String(), strconv.Itoa(int(e.Port)))
+}
+
+// String the address of the endpoint, ip:port
+func (sf Endpoint) String() string { return endpointString(sf) }
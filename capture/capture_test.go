@@ -0,0 +1,238 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+type nopHandler struct{}
+
+func (*nopHandler) InterrogationHandler(asdu.Connect, *asdu.ASDU, asdu.QualifierOfInterrogation) error {
+	return nil
+}
+func (*nopHandler) CounterInterrogationHandler(asdu.Connect, *asdu.ASDU, asdu.QualifierCountCall) error {
+	return nil
+}
+func (*nopHandler) ReadHandler(asdu.Connect, *asdu.ASDU, asdu.InfoObjAddr) error { return nil }
+func (*nopHandler) ClockSyncHandler(asdu.Connect, *asdu.ASDU, time.Time) error   { return nil }
+func (*nopHandler) ResetProcessHandler(asdu.Connect, *asdu.ASDU, asdu.QualifierOfResetProcessCmd) error {
+	return nil
+}
+func (*nopHandler) DelayAcquisitionHandler(asdu.Connect, *asdu.ASDU, uint16) error { return nil }
+func (*nopHandler) ASDUHandler(asdu.Connect, *asdu.ASDU) error                     { return nil }
+
+func TestStream_Packet(t *testing.T) {
+	s := NewStream(Endpoint{net.ParseIP("10.0.0.1"), 50000}, Endpoint{net.ParseIP("10.0.0.2"), 2404})
+	payload := []byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00}
+
+	pkt := s.Packet(true, payload)
+	require.Len(t, pkt, 14+20+20+len(payload))
+	assert.Equal(t, uint16(0x0800), binary.BigEndian.Uint16(pkt[12:]))
+	ip := pkt[14:34]
+	assert.Equal(t, uint16(0), checksum(ip, 0), "ip checksum")
+	assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), net.IP(ip[12:16]))
+	tcp := pkt[34:]
+	assert.Equal(t, uint16(50000), binary.BigEndian.Uint16(tcp[0:]))
+	assert.Equal(t, uint16(2404), binary.BigEndian.Uint16(tcp[2:]))
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(tcp[4:]))
+	assert.Equal(t, payload, tcp[20:])
+	pseudo := append(append(append([]byte{}, ip[12:20]...), 0, 6), 0, byte(len(tcp)))
+	assert.Equal(t, uint16(0), checksum(tcp, checksum(pseudo, 0)^0xffff), "tcp checksum")
+
+	pkt = s.Packet(false, payload)
+	tcp = pkt[34:]
+	assert.Equal(t, uint16(2404), binary.BigEndian.Uint16(tcp[0:]))
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(tcp[4:]), "seq")
+	assert.Equal(t, uint32(1+len(payload)), binary.BigEndian.Uint32(tcp[8:]), "ack")
+
+	s = NewStream(Endpoint{net.ParseIP("::1"), 50000}, Endpoint{net.ParseIP("10.0.0.2"), 2404})
+	pkt = s.Packet(true, payload)
+	require.Len(t, pkt, 14+40+20+len(payload))
+	assert.Equal(t, uint16(0x86dd), binary.BigEndian.Uint16(pkt[12:]))
+}
+
+func TestWriter(t *testing.T) {
+	ts := time.Unix(1600000000, 123456000)
+	pkt := []byte{1, 2, 3, 4, 5}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatPcap)
+	require.NoError(t, err)
+	require.NoError(t, w.WritePacket(ts, pkt))
+	b := buf.Bytes()
+	require.Len(t, b, 24+16+len(pkt))
+	assert.Equal(t, uint32(pcapMagic), binary.LittleEndian.Uint32(b))
+	assert.Equal(t, uint32(linkTypeEthernet), binary.LittleEndian.Uint32(b[20:]))
+	assert.Equal(t, uint32(1600000000), binary.LittleEndian.Uint32(b[24:]))
+	assert.Equal(t, uint32(123456), binary.LittleEndian.Uint32(b[28:]))
+	assert.Equal(t, pkt, b[40:])
+
+	buf.Reset()
+	w, err = NewWriter(&buf, FormatPcapng)
+	require.NoError(t, err)
+	require.NoError(t, w.WritePacket(ts, pkt))
+	b = buf.Bytes()
+	require.Len(t, b, 28+20+32+8)
+	assert.Equal(t, uint32(pcapngBlockSHB), binary.LittleEndian.Uint32(b))
+	assert.Equal(t, uint32(pcapngBlockIDB), binary.LittleEndian.Uint32(b[28:]))
+	epb := b[48:]
+	assert.Equal(t, uint32(pcapngBlockEPB), binary.LittleEndian.Uint32(epb))
+	assert.Equal(t, uint32(40), binary.LittleEndian.Uint32(epb[4:]))
+	assert.Equal(t, uint32(40), binary.LittleEndian.Uint32(epb[36:]))
+	us := uint64(binary.LittleEndian.Uint32(epb[12:]))<<32 | uint64(binary.LittleEndian.Uint32(epb[16:]))
+	assert.Equal(t, uint64(ts.UnixNano()/1000), us)
+	assert.Equal(t, pkt, epb[28:33])
+}
+
+// readPcap return the packets of the pcap file
+func readPcap(t *testing.T, path string) [][]byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(b), 24)
+	var pkts [][]byte
+	for b = b[24:]; len(b) >= 16; {
+		n := int(binary.LittleEndian.Uint32(b[8:]))
+		pkts = append(pkts, b[16:16+n])
+		b = b[16+n:]
+	}
+	return pkts
+}
+
+func TestFileObserver(t *testing.T) {
+	dir := t.TempDir()
+	local := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 12345}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.20"), Port: 2404}
+	frame := func(dir cs104.FrameDirection, raw []byte) *cs104.Frame {
+		return &cs104.Frame{Direction: dir, Time: time.Now(), Raw: raw, LocalAddr: local, RemoteAddr: remote}
+	}
+	startDt := []byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00}
+
+	o, err := NewFileObserver(filepath.Join(dir, "client"), Options{PerSession: true, MaxSize: 24 + 2*(16+60), MaxBackups: 2})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		o.ObserveFrame(frame(cs104.FrameSent, startDt))
+	}
+	o.LinkClosed(local, remote, nil)
+	o.ObserveFrame(frame(cs104.FrameReceived, startDt)) // new link
+	require.NoError(t, o.Close())
+
+	path := filepath.Join(dir, "client-192.168.1.20_2404-192.168.1.10_12345.pcap")
+	pkts := readPcap(t, path)
+	require.Len(t, pkts, 1)
+	tcp := pkts[0][34:]
+	assert.Equal(t, uint16(2404), binary.BigEndian.Uint16(tcp[0:]), "from the outstation")
+	assert.Equal(t, uint16(12345), binary.BigEndian.Uint16(tcp[2:]))
+	// the file of the previous link is kept as backup
+	assert.Len(t, readPcap(t, backupPath(path, 1)), 1)
+	assert.Len(t, readPcap(t, backupPath(path, 2)), 2)
+	_, err = os.Stat(backupPath(path, 3))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileObserver_Failed(t *testing.T) {
+	dir := t.TempDir()
+	local := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 12345}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.20"), Port: 2404}
+	frame := &cs104.Frame{Direction: cs104.FrameSent, Time: time.Now(), Raw: []byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00}, LocalAddr: local, RemoteAddr: remote}
+	logger := &errLogger{}
+
+	t.Run("rotate", func(t *testing.T) {
+		path := filepath.Join(dir, "shared.pcap")
+		o, err := NewFileObserver(path, Options{MaxSize: 24 + 16 + 60})
+		require.NoError(t, err)
+		defer o.Close()
+		o.SetLogProvider(logger)
+		o.LogMode(true)
+		// the backup can't be replaced by the rotated file
+		require.NoError(t, os.MkdirAll(filepath.Join(backupPath(path, 1), "keep"), 0750))
+
+		for i := 0; i < 3; i++ {
+			o.ObserveFrame(frame)
+		}
+		assert.Len(t, logger.take(), 1, "the failure is reported once")
+		assert.Len(t, readPcap(t, path), 1)
+	})
+
+	t.Run("open", func(t *testing.T) {
+		o, err := NewFileObserver(filepath.Join(dir, "none", "client"), Options{PerSession: true})
+		require.NoError(t, err)
+		defer o.Close()
+		o.SetLogProvider(logger)
+		o.LogMode(true)
+
+		o.ObserveFrame(frame)
+		o.ObserveFrame(frame)
+		assert.Len(t, logger.take(), 1, "the failure is reported once")
+		o.LinkClosed(local, remote, nil)
+	})
+}
+
+// errLogger record the error messages
+type errLogger struct {
+	errs []string
+}
+
+func (sf *errLogger) Critical(string, ...interface{}) {}
+func (sf *errLogger) Error(format string, v ...interface{}) {
+	sf.errs = append(sf.errs, fmt.Sprintf(format, v...))
+}
+func (sf *errLogger) Warn(string, ...interface{})  {}
+func (sf *errLogger) Debug(string, ...interface{}) {}
+
+func (sf *errLogger) take() []string {
+	errs := sf.errs
+	sf.errs = nil
+	return errs
+}
+
+func TestFileObserver_Server(t *testing.T) {
+	dir := t.TempDir()
+	o, err := NewFileObserver(filepath.Join(dir, "server.pcapng"), Options{Format: FormatPcapng})
+	require.NoError(t, err)
+	defer o.Close()
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := cs104.NewServer(&nopHandler{}).SetFrameObserver(o)
+	go srv.Serve(listen)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00})
+	require.NoError(t, err)
+	rsp := make([]byte, 6)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(rsp)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(filepath.Join(dir, "server.pcapng"))
+		return err == nil && info.Size() == 48+2*(32+60)
+	}, time.Second, 10*time.Millisecond)
+}
+
+type nopClientHandler struct{}
+
+func (nopClientHandler) InterrogationHandler(asdu.Connect, *asdu.ASDU) error        { return nil }
+func (nopClientHandler) CounterInterrogationHandler(asdu.Connect, *asdu.ASDU) error { return nil }
+func (nopClientHandler) ReadHandler(asdu.Connect, *asdu.ASDU) error                 { return nil }
+func (nopClientHandler) TestCommandHandler(asdu.Connect, *asdu.ASDU) error          { return nil }
+func (nopClientHandler) ClockSyncHandler(asdu.Connect, *asdu.ASDU) error            { return nil }
+func (nopClientHandler) ResetProcessHandler(asdu.Connect, *asdu.ASDU) error         { return nil }
+func (nopClientHandler) DelayAcquisitionHandler(asdu.Connect, *asdu.ASDU) error     { return nil }
+func (nopClientHandler) ASDUHandler(asdu.Connect, *asdu.ASDU) error                 { return nil }
@@ -0,0 +1,314 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package capture
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/clog"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+// Options the options of the FileObserver
+type Options struct {
+	Format     Format
+	PerSession bool   // 每个会话(连接)写入单独的文件,文件名为 path 加上会话标识
+	MaxSize    int64  // 单个文件的最大字节数,超过后轮转, <=0 不轮转
+	MaxBackups int    // 保留的轮转文件数, <=0 保留1个
+	Port       uint16 // 被控站端口, 0 使用 cs104.Port,以便 wireshark 识别为 iec60870_104
+}
+
+// FileObserver a cs104.FrameObserver writes every APDU to capture files,
+// the APDUs are wrapped in synthetic ethernet/ip/tcp headers carrying the
+// real endpoint addresses. The rotated files are named path.1.pcap ... path.N.pcap,
+// an existing file is also rotated instead of being overwritten.
+// A file failed to create, write or rotate is logged and no longer written.
+type FileObserver struct {
+	path string
+	opt  Options
+
+	mu     sync.Mutex
+	shared *rotateFile // 所有会话共用的文件, PerSession 时为 nil
+	links  map[string]*link
+	clog.Clog
+}
+
+type link struct {
+	stream *Stream
+	file   *rotateFile
+}
+
+// NewFileObserver new a FileObserver write to path, the extension of the format is added if path has none.
+func NewFileObserver(path string, opt Options) (*FileObserver, error) {
+	if opt.MaxBackups <= 0 {
+		opt.MaxBackups = 1
+	}
+	if opt.Port == 0 {
+		opt.Port = cs104.Port
+	}
+	if filepath.Ext(path) == "" {
+		path += opt.Format.Ext()
+	}
+	sf := &FileObserver{
+		path:  path,
+		opt:   opt,
+		links: make(map[string]*link),
+		Clog:  clog.NewLogger("capture => "),
+	}
+	if !opt.PerSession {
+		f, err := openRotateFile(path, opt)
+		if err != nil {
+			return nil, err
+		}
+		sf.shared = f
+	}
+	return sf, nil
+}
+
+// ObserveFrame imp cs104.FrameObserver
+func (sf *FileObserver) ObserveFrame(f *cs104.Frame) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.links == nil { // closed
+		return
+	}
+	key := linkKey(f.LocalAddr, f.RemoteAddr)
+	l, ok := sf.links[key]
+	if !ok {
+		l = &link{stream: sf.newStream(f), file: sf.shared}
+		if l.file == nil {
+			path := sf.sessionPath(f)
+			file, err := openRotateFile(path, sf.opt)
+			if err != nil {
+				sf.Error("capture %s failed, %v", path, err)
+				l.file = &rotateFile{path: path} // 已关闭,该链路不再写入
+			} else {
+				l.file = file
+			}
+		}
+		sf.links[key] = l
+	}
+	if l.file.file == nil { // 失败已报告,停止写入
+		return
+	}
+	// 服务端会话发送的帧来自被控站,客户端发送的帧来自控制站
+	fromMaster := (f.Session == nil) == (f.Direction == cs104.FrameSent)
+	if err := l.file.writePacket(f.Time, l.stream.Packet(fromMaster, f.Raw)); err != nil {
+		sf.Error("capture %s failed, stop writing, %v", l.file.path, err)
+		_ = l.file.close()
+	}
+}
+
+// LinkClosed imp cs104.LinkCloseObserver, close the file of the session
+func (sf *FileObserver) LinkClosed(localAddr, remoteAddr net.Addr, _ *cs104.Session) {
+	key := linkKey(localAddr, remoteAddr)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if l, ok := sf.links[key]; ok {
+		delete(sf.links, key)
+		if l.file != sf.shared {
+			_ = l.file.close()
+		}
+	}
+}
+
+// Close close all the files
+func (sf *FileObserver) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var err error
+	for _, l := range sf.links {
+		if l.file != sf.shared {
+			if e := l.file.close(); e != nil {
+				err = e
+			}
+		}
+	}
+	sf.links = nil
+	if sf.shared != nil {
+		if e := sf.shared.close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// newStream build the synthetic tcp stream of the link,
+// the server session is the outstation, the client is the master.
+func (sf *FileObserver) newStream(f *cs104.Frame) *Stream {
+	master, outstation := endpoint(f.RemoteAddr), endpoint(f.LocalAddr)
+	if f.Session == nil {
+		master, outstation = outstation, master
+	}
+	if master.IP == nil {
+		master.IP = net.IPv4(127, 0, 0, 1)
+	}
+	if outstation.IP == nil {
+		outstation.IP = net.IPv4(127, 0, 0, 2)
+	}
+	if master.Port == 0 {
+		master.Port = 49152 // 非tcp连接,使用动态端口
+		if f.Session != nil {
+			master.Port += uint16(f.Session.ID() % 16384)
+		}
+	}
+	outstation.Port = sf.opt.Port
+	return NewStream(master, outstation)
+}
+
+// sessionPath the file path of the session, the session id for the server,
+// the remote and local address for the client.
+func (sf *FileObserver) sessionPath(f *cs104.Frame) string {
+	var id string
+	if f.Session != nil {
+		id = "session" + strconv.FormatUint(f.Session.ID(), 10)
+	} else {
+		id = sanitize(addrString(f.RemoteAddr)) + "-" + sanitize(addrString(f.LocalAddr))
+	}
+	ext := filepath.Ext(sf.path)
+	return strings.TrimSuffix(sf.path, ext) + "-" + id + ext
+}
+
+func linkKey(localAddr, remoteAddr net.Addr) string {
+	return addrString(localAddr) + "|" + addrString(remoteAddr)
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '.' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// endpoint the ip and port of the address, zero value if it's not an ip address
+func endpoint(addr net.Addr) Endpoint {
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		return Endpoint{v.IP, uint16(v.Port)}
+	case nil:
+		return Endpoint{}
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return Endpoint{}
+	}
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return Endpoint{net.ParseIP(host), uint16(p)}
+}
+
+// rotateFile a capture file rotates when exceeds the max size
+type rotateFile struct {
+	path    string
+	opt     Options
+	file    *os.File
+	cw      *countWriter
+	w       *Writer
+	packets int // 当前文件的报文数
+}
+
+// openRotateFile create the capture file, the existing one is rotated as backup
+func openRotateFile(path string, opt Options) (*rotateFile, error) {
+	sf := &rotateFile{path: path, opt: opt}
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		_ = sf.shiftBackups()
+	}
+	if err := sf.open(); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// open create the file and write the header
+func (sf *rotateFile) open() error {
+	file, err := os.OpenFile(sf.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	cw := &countWriter{w: file}
+	w, err := NewWriter(cw, sf.opt.Format)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sf.file, sf.cw, sf.w, sf.packets = file, cw, w, 0
+	return nil
+}
+
+func (sf *rotateFile) writePacket(ts time.Time, pkt []byte) error {
+	if sf.file == nil {
+		return os.ErrClosed
+	}
+	if sf.opt.MaxSize > 0 && sf.packets > 0 && sf.cw.n+int64(len(pkt)) > sf.opt.MaxSize {
+		if err := sf.rotate(); err != nil {
+			return err
+		}
+	}
+	sf.packets++
+	return sf.w.WritePacket(ts, pkt)
+}
+
+// rotate shift the backups and create a new file
+func (sf *rotateFile) rotate() error {
+	if err := sf.close(); err != nil {
+		return err
+	}
+	if err := sf.shiftBackups(); err != nil {
+		return err
+	}
+	return sf.open()
+}
+
+// shiftBackups rename the file to path.1.ext and the older backups to path.2.ext ...
+func (sf *rotateFile) shiftBackups() error {
+	_ = os.Remove(backupPath(sf.path, sf.opt.MaxBackups))
+	for i := sf.opt.MaxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(backupPath(sf.path, i), backupPath(sf.path, i+1))
+	}
+	return os.Rename(sf.path, backupPath(sf.path, 1))
+}
+
+func (sf *rotateFile) close() error {
+	if sf.file == nil {
+		return nil
+	}
+	err := sf.file.Close()
+	sf.file = nil
+	return err
+}
+
+// backupPath the path of the n-th backup, path.n.ext
+func backupPath(path string, n int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// countWriter count the bytes written
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (sf *countWriter) Write(b []byte) (int, error) {
+	n, err := sf.w.Write(b)
+	sf.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package capture
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// synthetic mac address of the master and the outstation
+var (
+	masterMAC     = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	outstationMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// header size defined
+const (
+	ethernetHeaderSize = 14
+	ipv4HeaderSize     = 20
+	ipv6HeaderSize     = 40
+	tcpHeaderSize      = 20
+)
+
+// Endpoint the ip address and port of one side of the connection
+type Endpoint struct {
+	IP   net.IP
+	Port uint16
+}
+
+// Stream the synthetic tcp connection between the master and the outstation,
+// it keeps the sequence numbers of both directions.
+type Stream struct {
+	Master     Endpoint
+	Outstation Endpoint
+	seq        [2]uint32 // 0: master -> outstation, 1: outstation -> master
+}
+
+// NewStream new a stream, if any of the ip is not ipv4, ipv6 headers are used
+func NewStream(master, outstation Endpoint) *Stream {
+	return &Stream{Master: master, Outstation: outstation, seq: [2]uint32{1, 1}}
+}
+
+// Packet wrap the payload in ethernet, ip and tcp headers,
+// fromMaster is the direction of the payload.
+func (sf *Stream) Packet(fromMaster bool, payload []byte) []byte {
+	src, dst := sf.Master, sf.Outstation
+	srcMAC, dstMAC := masterMAC, outstationMAC
+	dir := 0
+	if !fromMaster {
+		src, dst = dst, src
+		srcMAC, dstMAC = dstMAC, srcMAC
+		dir = 1
+	}
+	seq, ack := sf.seq[dir], sf.seq[1-dir]
+	sf.seq[dir] += uint32(len(payload))
+
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	ipv6 := src4 == nil || dst4 == nil
+	ipSize := ipv4HeaderSize
+	if ipv6 {
+		ipSize = ipv6HeaderSize
+	}
+	pkt := make([]byte, ethernetHeaderSize+ipSize+tcpHeaderSize+len(payload))
+
+	// ethernet
+	copy(pkt[0:6], dstMAC)
+	copy(pkt[6:12], srcMAC)
+	ip := pkt[ethernetHeaderSize : ethernetHeaderSize+ipSize]
+	tcp := pkt[ethernetHeaderSize+ipSize:]
+	var pseudo []byte // tcp伪首部
+	if ipv6 {
+		binary.BigEndian.PutUint16(pkt[12:], 0x86dd)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(tcpHeaderSize+len(payload)))
+		ip[6] = 6 // tcp
+		ip[7] = 64
+		copy(ip[8:24], src.IP.To16())
+		copy(ip[24:40], dst.IP.To16())
+		pseudo = make([]byte, 40)
+		copy(pseudo, ip[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(tcpHeaderSize+len(payload)))
+		pseudo[39] = 6
+	} else {
+		binary.BigEndian.PutUint16(pkt[12:], 0x0800)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderSize+tcpHeaderSize+len(payload)))
+		ip[6] = 0x40 // don't fragment
+		ip[8] = 64
+		ip[9] = 6 // tcp
+		copy(ip[12:16], src4)
+		copy(ip[16:20], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+		pseudo = make([]byte, 12)
+		copy(pseudo, ip[12:20])
+		pseudo[9] = 6
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(tcpHeaderSize+len(payload)))
+	}
+
+	// tcp
+	binary.BigEndian.PutUint16(tcp[0:], src.Port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = (tcpHeaderSize / 4) << 4
+	tcp[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(tcp[14:], 0xffff)
+	copy(tcp[tcpHeaderSize:], payload)
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, checksum(pseudo, 0)^0xffff))
+	return pkt
+}
+
+// checksum the internet checksum of b, initial is the complemented sum of the previous part
+func checksum(b []byte, initial uint16) uint16 {
+	sum := uint32(initial)
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,240 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// link type defined
+const (
+	LinkTypeNull     = 0
+	LinkTypeEthernet = linkTypeEthernet
+	LinkTypeRaw      = 101
+	LinkTypeLinuxSLL = 113
+	LinkTypeIPv4     = 228
+	LinkTypeIPv6     = 229
+	LinkTypeSLL2     = 276
+)
+
+// pcap defined
+const (
+	pcapMagicNano  = 0xa1b23c4d
+	pcapngBlockPB  = 0x00000002 // obsolete packet block
+	pcapngBlockSPB = 0x00000003
+	maxBlockSize   = 16 << 20
+)
+
+// ErrFormat the file is neither pcap nor pcapng
+var ErrFormat = errors.New("capture: unknown file format")
+
+// Packet a packet read from the capture file
+type Packet struct {
+	Time     time.Time
+	LinkType int
+	Data     []byte
+}
+
+// pcapngIface the interface description of pcapng
+type pcapngIface struct {
+	linkType int
+	tsUnit   float64 // seconds per timestamp unit
+}
+
+// Reader read the packets from a pcap or pcapng stream
+type Reader struct {
+	r      *bufio.Reader
+	format Format
+	order  binary.ByteOrder
+
+	// pcap
+	linkType int
+	nano     bool
+
+	// pcapng
+	ifaces []pcapngIface
+}
+
+// NewReader new a reader, the format is detected from the file header
+func NewReader(r io.Reader) (*Reader, error) {
+	sf := &Reader{r: bufio.NewReader(r)}
+	magic, err := sf.r.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	switch binary.LittleEndian.Uint32(magic) {
+	case pcapngBlockSHB:
+		sf.format = FormatPcapng
+		return sf, nil
+	case pcapMagic, pcapMagicNano:
+		sf.order = binary.LittleEndian
+	default:
+		switch binary.BigEndian.Uint32(magic) {
+		case pcapMagic, pcapMagicNano:
+			sf.order = binary.BigEndian
+		default:
+			return nil, ErrFormat
+		}
+	}
+	hdr := make([]byte, 24)
+	if _, err = io.ReadFull(sf.r, hdr); err != nil {
+		return nil, err
+	}
+	sf.format = FormatPcap
+	sf.nano = sf.order.Uint32(hdr) == pcapMagicNano
+	sf.linkType = int(sf.order.Uint32(hdr[20:]) & 0xffff)
+	return sf, nil
+}
+
+// Format the format of the file
+func (sf *Reader) Format() Format { return sf.format }
+
+// ReadPacket read the next packet, io.EOF at the end
+func (sf *Reader) ReadPacket() (Packet, error) {
+	if sf.format == FormatPcapng {
+		return sf.readBlock()
+	}
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(sf.r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Packet{}, io.EOF
+		}
+		return Packet{}, err
+	}
+	n := sf.order.Uint32(hdr[8:])
+	if n > maxBlockSize {
+		return Packet{}, fmt.Errorf("capture: packet too large %d", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(sf.r, data); err != nil {
+		return Packet{}, io.EOF // truncated file
+	}
+	frac := time.Duration(sf.order.Uint32(hdr[4:]))
+	if !sf.nano {
+		frac *= time.Microsecond
+	}
+	ts := time.Unix(int64(sf.order.Uint32(hdr)), int64(frac))
+	return Packet{ts, sf.linkType, data}, nil
+}
+
+// readBlock read the pcapng blocks until a packet
+func (sf *Reader) readBlock() (Packet, error) {
+	for {
+		head := make([]byte, 8)
+		if _, err := io.ReadFull(sf.r, head); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return Packet{}, io.EOF
+			}
+			return Packet{}, err
+		}
+		typ := binary.LittleEndian.Uint32(head)
+		if typ == pcapngBlockSHB {
+			// 字节序由SHB决定
+			bom, err := sf.r.Peek(4)
+			if err != nil {
+				return Packet{}, io.EOF
+			}
+			if binary.LittleEndian.Uint32(bom) == pcapngMagic {
+				sf.order = binary.LittleEndian
+			} else {
+				sf.order = binary.BigEndian
+			}
+			sf.ifaces = sf.ifaces[:0]
+		}
+		if sf.order == nil {
+			return Packet{}, ErrFormat
+		}
+		size := sf.order.Uint32(head[4:])
+		if size < 12 || size > maxBlockSize {
+			return Packet{}, fmt.Errorf("capture: invalid block size %d", size)
+		}
+		body := make([]byte, size-8)
+		if _, err := io.ReadFull(sf.r, body); err != nil {
+			return Packet{}, io.EOF
+		}
+		body = body[:len(body)-4]
+
+		switch sf.order.Uint32(head) {
+		case pcapngBlockIDB:
+			if len(body) < 8 {
+				continue
+			}
+			iface := pcapngIface{linkType: int(sf.order.Uint16(body)), tsUnit: 1e-6}
+			sf.parseIfaceOptions(&iface, body[8:])
+			sf.ifaces = append(sf.ifaces, iface)
+		case pcapngBlockEPB:
+			if len(body) < 20 {
+				continue
+			}
+			id := sf.order.Uint32(body)
+			n := sf.order.Uint32(body[12:])
+			if int(id) >= len(sf.ifaces) || 20+int(n) > len(body) {
+				continue
+			}
+			ts := uint64(sf.order.Uint32(body[4:]))<<32 | uint64(sf.order.Uint32(body[8:]))
+			iface := sf.ifaces[id]
+			return Packet{iface.time(ts), iface.linkType, body[20 : 20+n]}, nil
+		case pcapngBlockPB:
+			if len(body) < 20 {
+				continue
+			}
+			id := sf.order.Uint16(body)
+			n := sf.order.Uint32(body[12:])
+			if int(id) >= len(sf.ifaces) || 20+int(n) > len(body) {
+				continue
+			}
+			ts := uint64(sf.order.Uint32(body[4:]))<<32 | uint64(sf.order.Uint32(body[8:]))
+			iface := sf.ifaces[id]
+			return Packet{iface.time(ts), iface.linkType, body[20 : 20+n]}, nil
+		case pcapngBlockSPB:
+			if len(body) < 4 || len(sf.ifaces) == 0 {
+				continue
+			}
+			n := sf.order.Uint32(body)
+			if 4+int(n) > len(body) {
+				n = uint32(len(body) - 4)
+			}
+			return Packet{LinkType: sf.ifaces[0].linkType, Data: body[4 : 4+n]}, nil
+		}
+	}
+}
+
+// parseIfaceOptions parse the if_tsresol option
+func (sf *Reader) parseIfaceOptions(iface *pcapngIface, opts []byte) {
+	for len(opts) >= 4 {
+		code, n := sf.order.Uint16(opts), int(sf.order.Uint16(opts[2:]))
+		if code == 0 || 4+n > len(opts) {
+			return
+		}
+		if code == 9 && n >= 1 { // if_tsresol
+			v := opts[4]
+			if v&0x80 == 0 {
+				iface.tsUnit = math.Pow10(-int(v))
+			} else {
+				iface.tsUnit = math.Pow(2, -float64(v&0x7f))
+			}
+		}
+		opts = opts[4+(n+3)&^3:]
+	}
+}
+
+// time convert the timestamp in units to time
+func (sf pcapngIface) time(ts uint64) time.Time {
+	if sf.tsUnit == 1e-6 {
+		return time.UnixMicro(int64(ts))
+	}
+	if sf.tsUnit == 1e-9 {
+		return time.Unix(0, int64(ts))
+	}
+	sec := float64(ts) * sf.tsUnit
+	whole := math.Floor(sec)
+	return time.Unix(int64(whole), int64((sec-whole)*1e9))
+}
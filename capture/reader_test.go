@@ -0,0 +1,121 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+var (
+	testMaster     = Endpoint{net.ParseIP("10.0.0.1").To4(), 50000}
+	testOutstation = Endpoint{net.ParseIP("10.0.0.2").To4(), 2404}
+)
+
+func TestReader(t *testing.T) {
+	for _, format := range []Format{FormatPcap, FormatPcapng} {
+		t.Run(format.Ext(), func(t *testing.T) {
+			s := NewStream(testMaster, testOutstation)
+			ts := time.Unix(1600000000, 123456000)
+			pkts := [][]byte{s.Packet(true, []byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00}), s.Packet(false, []byte{0x68, 0x04, 0x0b, 0x00, 0x00, 0x00, 0x01})}
+
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			require.NoError(t, err)
+			for i, p := range pkts {
+				require.NoError(t, w.WritePacket(ts.Add(time.Duration(i)*time.Millisecond), p))
+			}
+
+			r, err := NewReader(&buf)
+			require.NoError(t, err)
+			assert.Equal(t, format, r.Format())
+			for i, p := range pkts {
+				got, err := r.ReadPacket()
+				require.NoError(t, err)
+				assert.Equal(t, LinkTypeEthernet, got.LinkType)
+				assert.Equal(t, p, got.Data)
+				assert.True(t, ts.Add(time.Duration(i)*time.Millisecond).Equal(got.Time))
+			}
+			_, err = r.ReadPacket()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+
+	_, err := NewReader(bytes.NewReader([]byte{0, 1, 2, 3, 4, 5}))
+	assert.Equal(t, ErrFormat, err)
+}
+
+func TestAssembler(t *testing.T) {
+	s := NewStream(testMaster, testOutstation)
+	startDt := []byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00}
+	confirm := []byte{0x68, 0x04, 0x0b, 0x00, 0x00, 0x00}
+	iframe := []byte{0x68, 0x0e, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x03, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01}
+
+	p1 := s.Packet(true, startDt)
+	p2 := s.Packet(false, confirm)
+	p3 := s.Packet(false, iframe[:5])
+	p4 := s.Packet(false, iframe[5:])
+	p5 := s.Packet(false, append(append([]byte{}, confirm...), confirm...))
+
+	a := NewAssembler(0)
+	var got []APDU
+	for _, p := range [][]byte{p1, p2, p4, p3, p3, p5} { // out of order and retransmission
+		got = append(got, a.Feed(Packet{LinkType: LinkTypeEthernet, Data: p})...)
+	}
+	require.Len(t, got, 5)
+	assert.True(t, got[0].FromMaster)
+	assert.Equal(t, startDt, got[0].Raw)
+	assert.Equal(t, "10.0.0.1:50000", got[0].Src.String())
+	assert.False(t, got[1].FromMaster)
+	assert.Equal(t, confirm, got[1].Raw)
+	assert.Equal(t, iframe, got[2].Raw)
+	assert.Equal(t, confirm, got[3].Raw)
+	assert.Equal(t, confirm, got[4].Raw)
+
+	// other port
+	assert.Empty(t, NewAssembler(2405).Feed(Packet{LinkType: LinkTypeEthernet, Data: p1}))
+}
+
+type replayHandler struct {
+	nopClientHandler
+	got []*asdu.ASDU
+}
+
+func (sf *replayHandler) ASDUHandler(c asdu.Connect, a *asdu.ASDU) error {
+	sf.got = append(sf.got, a)
+	return c.Send(a)
+}
+
+func TestReplay(t *testing.T) {
+	s := NewStream(testMaster, testOutstation)
+	sp := asdu.NewASDU(asdu.ParamsWide, asdu.Identifier{Type: asdu.M_SP_NA_1, Coa: asdu.CauseOfTransmission{Cause: asdu.Spontaneous}, CommonAddr: 1})
+	sp.Variable.Number = 1
+	require.NoError(t, sp.AppendInfoObjAddr(100))
+	sp.AppendBytes(0x01)
+	raw, err := sp.MarshalBinary()
+	require.NoError(t, err)
+	iframe := append([]byte{0x68, byte(4 + len(raw)), 0x00, 0x00, 0x00, 0x00}, raw...)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatPcapng)
+	require.NoError(t, err)
+	require.NoError(t, w.WritePacket(time.Now(), s.Packet(true, iframe))) // from master, skipped
+	require.NoError(t, w.WritePacket(time.Now(), s.Packet(false, []byte{0x68, 0x04, 0x01, 0x00, 0x00, 0x00})))
+	require.NoError(t, w.WritePacket(time.Now(), s.Packet(false, iframe)))
+
+	h := &replayHandler{}
+	replies := 0
+	n, err := Replay(&buf, h, ReplayOptions{OnReply: func(*asdu.ASDU) { replies++ }})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, h.got, 1)
+	assert.Equal(t, asdu.M_SP_NA_1, h.got[0].Type)
+	assert.Equal(t, []asdu.SinglePointInfo{{Ioa: 100, Value: true}}, h.got[0].GetSinglePoint())
+	assert.Equal(t, 1, replies)
+}
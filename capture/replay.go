@@ -0,0 +1,93 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+// ReplayOptions the options of Replay
+type ReplayOptions struct {
+	Port    uint16             // 被控站端口, 0 使用 2404
+	Params  *asdu.Params       // asdu参数, nil 使用 asdu.ParamsWide
+	OnReply func(a *asdu.ASDU) // 处理器发送的asdu, nil 丢弃
+}
+
+// replayConn the asdu.Connect passed to the handler during the replay
+type replayConn struct {
+	params  *asdu.Params
+	onReply func(a *asdu.ASDU)
+}
+
+func (sf *replayConn) Params() *asdu.Params { return sf.params }
+
+func (sf *replayConn) Send(a *asdu.ASDU) error {
+	if sf.onReply != nil {
+		sf.onReply(a)
+	}
+	return nil
+}
+
+func (sf *replayConn) UnderlyingConn() net.Conn { return nil }
+
+// Replay read the capture and feed the asdus sent by the outstations to the handler in order,
+// as if they were received by a cs104.Client, return the number of the asdus replayed
+// and the errors of decoding and the handler joined.
+func Replay(r io.Reader, h cs104.ClientHandlerInterface, opt ReplayOptions) (int, error) {
+	if opt.Params == nil {
+		opt.Params = asdu.ParamsWide
+	}
+	rd, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	conn := &replayConn{opt.Params, opt.OnReply}
+	asm := NewAssembler(opt.Port)
+	n, errs := 0, []error(nil)
+	for {
+		pkt, err := rd.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		for _, apdu := range asm.Feed(pkt) {
+			// 仅I帧
+			if apdu.FromMaster || apdu.Raw[2]&0x01 != 0 || len(apdu.Raw) <= 6 {
+				continue
+			}
+			a := asdu.NewEmptyASDU(opt.Params)
+			if err = a.UnmarshalBinary(apdu.Raw[6:]); err != nil {
+				errs = append(errs, fmt.Errorf("%s %v: %w", apdu.Time.Format(timeFormat), apdu.Src, err))
+				continue
+			}
+			n++
+			if err = handle(h, conn, a); err != nil {
+				errs = append(errs, fmt.Errorf("%s %v %v: %w", apdu.Time.Format(timeFormat), apdu.Src, a.Identifier, err))
+			}
+		}
+	}
+	return n, errors.Join(errs...)
+}
+
+// handle call the handler, the panic is returned as error
+func handle(h cs104.ClientHandlerInterface, conn asdu.Connect, a *asdu.ASDU) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic, %v", r)
+		}
+	}()
+	return cs104.HandleClientASDU(h, conn, a)
+}
+
+// timeFormat the time format of the errors
+const timeFormat = "2006-01-02T15:04:05.000000Z07:00"
@@ -0,0 +1,110 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// Format the capture file format
+type Format int
+
+// capture file format defined
+const (
+	FormatPcap   Format = iota // libpcap
+	FormatPcapng               // pcap next generation
+)
+
+// Ext the file extension of the format
+func (sf Format) Ext() string {
+	if sf == FormatPcapng {
+		return ".pcapng"
+	}
+	return ".pcap"
+}
+
+// pcap defined
+const (
+	pcapMagic        = 0xa1b2c3d4
+	linkTypeEthernet = 1
+	snapLen          = 65535
+
+	pcapngBlockSHB = 0x0a0d0d0a
+	pcapngBlockIDB = 0x00000001
+	pcapngBlockEPB = 0x00000006
+	pcapngMagic    = 0x1a2b3c4d
+)
+
+// Writer write the ethernet packets to a pcap or pcapng stream, little endian
+type Writer struct {
+	w      io.Writer
+	format Format
+}
+
+// NewWriter new a writer and write the file header
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	sf := &Writer{w, format}
+	var hdr []byte
+	if format == FormatPcapng {
+		shb := make([]byte, 28)
+		binary.LittleEndian.PutUint32(shb[0:], pcapngBlockSHB)
+		binary.LittleEndian.PutUint32(shb[4:], 28)
+		binary.LittleEndian.PutUint32(shb[8:], pcapngMagic)
+		binary.LittleEndian.PutUint16(shb[12:], 1) // major
+		binary.LittleEndian.PutUint16(shb[14:], 0) // minor
+		binary.LittleEndian.PutUint64(shb[16:], ^uint64(0))
+		binary.LittleEndian.PutUint32(shb[24:], 28)
+
+		idb := make([]byte, 20)
+		binary.LittleEndian.PutUint32(idb[0:], pcapngBlockIDB)
+		binary.LittleEndian.PutUint32(idb[4:], 20)
+		binary.LittleEndian.PutUint16(idb[8:], linkTypeEthernet)
+		binary.LittleEndian.PutUint32(idb[12:], snapLen)
+		binary.LittleEndian.PutUint32(idb[16:], 20)
+		hdr = append(shb, idb...)
+	} else {
+		hdr = make([]byte, 24)
+		binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+		binary.LittleEndian.PutUint16(hdr[4:], 2)
+		binary.LittleEndian.PutUint16(hdr[6:], 4)
+		binary.LittleEndian.PutUint32(hdr[16:], snapLen)
+		binary.LittleEndian.PutUint32(hdr[20:], linkTypeEthernet)
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// WritePacket write an ethernet packet captured at ts
+func (sf *Writer) WritePacket(ts time.Time, pkt []byte) error {
+	var rec []byte
+	if sf.format == FormatPcapng {
+		padded := (len(pkt) + 3) &^ 3
+		size := 32 + padded
+		rec = make([]byte, size)
+		us := uint64(ts.UnixNano() / 1000) // 默认精度为微秒
+		binary.LittleEndian.PutUint32(rec[0:], pcapngBlockEPB)
+		binary.LittleEndian.PutUint32(rec[4:], uint32(size))
+		binary.LittleEndian.PutUint32(rec[8:], 0) // interface id
+		binary.LittleEndian.PutUint32(rec[12:], uint32(us>>32))
+		binary.LittleEndian.PutUint32(rec[16:], uint32(us))
+		binary.LittleEndian.PutUint32(rec[20:], uint32(len(pkt)))
+		binary.LittleEndian.PutUint32(rec[24:], uint32(len(pkt)))
+		copy(rec[28:], pkt)
+		binary.LittleEndian.PutUint32(rec[size-4:], uint32(size))
+	} else {
+		rec = make([]byte, 16+len(pkt))
+		binary.LittleEndian.PutUint32(rec[0:], uint32(ts.Unix()))
+		binary.LittleEndian.PutUint32(rec[4:], uint32(ts.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rec[8:], uint32(len(pkt)))
+		binary.LittleEndian.PutUint32(rec[12:], uint32(len(pkt)))
+		copy(rec[16:], pkt)
+	}
+	_, err := sf.w.Write(rec)
+	return err
+}
@@ -1,10 +1,12 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"strconv"
 	"time"
@@ -30,11 +32,19 @@ type Client struct {
 type Settings struct {
 	Host              string
 	Port              int
-	AutoConnect       bool          //自动重连
-	ReconnectInterval time.Duration //重连间隔
-	Cfg104            *cs104.Config //104协议规范配置
-	TLS               *tls.Config   // tls配置
-	Params            *asdu.Params  //ASDU相关特定参数
+	Backups           []string               //备用端点 host:port,主端点失败后按顺序切换
+	Failover          cs104.FailoverStrategy //多端点切换策略
+	Standby           bool                   //是否保持到备用端点的STOPDT连接
+	AutoConnect       bool                   //自动重连
+	ReconnectInterval time.Duration          //重连间隔
+	Backoff           cs104.Backoff          //重连退避策略,设置后优先于重连间隔
+	Dialer            cs104.DialContextFunc  //自定义拨号,如绑定源地址,设置socket选项,经过代理
+	ConnFactory       cs104.ConnFactory      //自定义传输,设置后由它建立连接(串口隧道,内存管道等)
+	Audit             cs104.AuditSink        //控制命令审计
+	FrameObserver     cs104.FrameObserver    //帧观察者,跟踪收发的每个APDU
+	Cfg104            *cs104.Config          //104协议规范配置
+	TLS               *tls.Config            // tls配置
+	Params            *asdu.Params           //ASDU相关特定参数
 	LogCfg            *LogCfg
 }
 
@@ -81,11 +91,6 @@ func New(settings *Settings, handler cs104.ClientHandlerInterface) *Client {
 }
 
 func (c *Client) Connect(isBlock bool) error {
-	if err := c.client104.Start(); err != nil {
-		return err
-	}
-
-	var err error
 	// 连接状态事件
 	c.client104.SetOnConnectHandler(func(cs *cs104.Client) {
 		cs.SendStartDt()
@@ -94,24 +99,30 @@ func (c *Client) Connect(isBlock bool) error {
 		}
 	})
 
-	if isBlock {
-		now := time.Now()
-		for {
-			if c.client104.GetActiveStatus() {
-				break
-			}
+	if !isBlock {
+		return c.client104.Start()
+	}
+
+	events, cancel := c.client104.Subscribe(16)
+	defer cancel()
+	if err := c.client104.Start(); err != nil {
+		return err
+	}
 
-			tmpNow := time.Now()
-			if tmpNow.Sub(now) > c.settings.Cfg104.ConnectTimeout0 {
-				err = fmt.Errorf("connection timeout of %f seconds", c.settings.Cfg104.ConnectTimeout0.Seconds())
-				c.Close()
-				break
+	timeout := time.NewTimer(c.settings.Cfg104.ConnectTimeout0)
+	defer timeout.Stop()
+	for !c.client104.GetActiveStatus() {
+		select {
+		case ev := <-events:
+			if ev.State == cs104.StateClosed {
+				return fmt.Errorf("connection closed, %s", ev.Reason)
 			}
-			time.Sleep(time.Millisecond * 100)
+		case <-timeout.C:
+			c.Close()
+			return fmt.Errorf("connection timeout of %f seconds", c.settings.Cfg104.ConnectTimeout0.Seconds())
 		}
 	}
-
-	return err
+	return nil
 }
 
 func (c *Client) Close() error {
@@ -119,6 +130,21 @@ func (c *Client) Close() error {
 	return c.client104.Close()
 }
 
+// Stats the statistics snapshot of the link
+func (c *Client) Stats() cs104.LinkStats {
+	return c.client104.Stats()
+}
+
+// MetricsHandler return a http.Handler exposing the statistics in the prometheus text format
+func (c *Client) MetricsHandler() http.Handler {
+	return c.client104.MetricsHandler()
+}
+
+// Shutdown send STOPDT after the in-flight I-frames acknowledged, then close the connection
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.client104.Shutdown(ctx)
+}
+
 func (c *Client) SetLogCfg(cfg LogCfg) {
 	c.client104.LogMode(cfg.Enable)
 	c.client104.SetLogProvider(cfg.LogProvider)
@@ -136,12 +162,50 @@ func (c *Client) SetConnectionLostHandler(f func(c *Client)) {
 	})
 }
 
+// SetStateHandler 连接状态变化后回调,不能阻塞
+func (c *Client) SetStateHandler(f func(c *Client, ev cs104.ConnStateEvent)) {
+	c.client104.SetStateHandler(func(_ *cs104.Client, ev cs104.ConnStateEvent) {
+		f(c, ev)
+	})
+}
+
+// Subscribe 订阅连接状态事件,通道满时丢弃事件,调用cancel取消订阅
+func (c *Client) Subscribe(size int) (<-chan cs104.ConnStateEvent, func()) {
+	return c.client104.Subscribe(size)
+}
+
+// State 当前连接状态
+func (c *Client) State() cs104.ConnState {
+	return c.client104.State()
+}
+
+// SetEndpointChangeHandler 连接到端点后回调,故障切换后端点可能与上次不同
+func (c *Client) SetEndpointChangeHandler(f func(c *Client, endpoint *url.URL)) {
+	c.client104.SetEndpointChangeHandler(func(_ *cs104.Client, endpoint *url.URL) {
+		f(c, endpoint)
+	})
+}
+
+// ActiveEndpoint 当前连接的端点,未连接返回nil
+func (c *Client) ActiveEndpoint() *url.URL {
+	return c.client104.ActiveEndpoint()
+}
+
 func (c *Client) IsConnected() bool {
 	return c.client104.IsConnected()
 }
 
 // SendCmd 双点遥控
 func (c *Client) SendCmd(addr uint16, typeId asdu.TypeID, ioa asdu.InfoObjAddr, value any) error {
+	return c.sendCmd(addr, typeId, ioa, value, false)
+}
+
+// SendSelectCmd 选择命令,用于选择-执行(select before operate),收到激活确认后再调用SendCmd执行
+func (c *Client) SendSelectCmd(addr uint16, typeId asdu.TypeID, ioa asdu.InfoObjAddr, value any) error {
+	return c.sendCmd(addr, typeId, ioa, value, true)
+}
+
+func (c *Client) sendCmd(addr uint16, typeId asdu.TypeID, ioa asdu.InfoObjAddr, value any, inSelect bool) error {
 	cmd := &command{
 		typeId: typeId,
 		ioa:    ioa,
@@ -149,11 +213,11 @@ func (c *Client) SendCmd(addr uint16, typeId asdu.TypeID, ioa asdu.InfoObjAddr,
 		value:  value,
 		qoc: asdu.QualifierOfCommand{
 			Qual:     asdu.QOCNoAdditionalDefinition,
-			InSelect: false,
+			InSelect: inSelect,
 		},
 		qos: asdu.QualifierOfSetpointCmd{
 			Qual:     0,
-			InSelect: false,
+			InSelect: inSelect,
 		},
 		t: time.Now(),
 	}
@@ -373,19 +437,31 @@ func newClientOption(settings *Settings) *cs104.ClientOption {
 	}
 	opts.SetAutoReconnect(settings.AutoConnect)
 	opts.SetReconnectInterval(settings.ReconnectInterval)
+	opts.SetBackoff(settings.Backoff)
+	opts.SetDialer(settings.Dialer)
+	opts.SetConnFactory(settings.ConnFactory)
+	opts.SetAuditSink(settings.Audit)
+	opts.SetFrameObserver(settings.FrameObserver)
 	opts.SetTLSConfig(settings.TLS)
 
+	opts.SetFailoverStrategy(settings.Failover)
+	opts.SetStandby(settings.Standby)
+
 	server := formatServerUrl(settings)
 	_ = opts.AddRemoteServer(server)
+	for _, v := range settings.Backups {
+		_ = opts.AddRemoteServer(formatEndpointUrl(settings, v))
+	}
 	return opts
 }
 
 func formatServerUrl(settings *Settings) string {
-	var server string
+	return formatEndpointUrl(settings, settings.Host+":"+strconv.Itoa(settings.Port))
+}
+
+func formatEndpointUrl(settings *Settings, hostPort string) string {
 	if settings.TLS != nil {
-		server = "tcps://" + settings.Host + ":" + strconv.Itoa(settings.Port)
-	} else {
-		server = "tcp://" + settings.Host + ":" + strconv.Itoa(settings.Port)
+		return "tcps://" + hostPort
 	}
-	return server
+	return "tcp://" + hostPort
 }
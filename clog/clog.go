@@ -18,6 +18,12 @@ type LogProvider interface {
 	Debug(format string, v ...interface{})
 }
 
+// InfoProvider optional INFO level of the LogProvider,
+// the Info message goes to Warn if the provider does not implement it.
+type InfoProvider interface {
+	Info(format string, v ...interface{})
+}
+
 // Clog 日志内部调试实现
 type Clog struct {
 	provider LogProvider
@@ -72,6 +78,17 @@ func (sf Clog) Warn(format string, v ...interface{}) {
 	}
 }
 
+// Info Log INFO level message.
+func (sf Clog) Info(format string, v ...interface{}) {
+	if atomic.LoadUint32(&sf.has) == 1 {
+		if p, ok := sf.provider.(InfoProvider); ok {
+			p.Info(format, v...)
+		} else {
+			sf.provider.Warn(format, v...)
+		}
+	}
+}
+
 // Debug Log DEBUG level message.
 func (sf Clog) Debug(format string, v ...interface{}) {
 	if atomic.LoadUint32(&sf.has) == 1 {
@@ -85,6 +102,7 @@ type defaultLogger struct {
 }
 
 var _ LogProvider = (*defaultLogger)(nil)
+var _ InfoProvider = (*defaultLogger)(nil)
 
 // Critical Log CRITICAL level message.
 func (sf defaultLogger) Critical(format string, v ...interface{}) {
@@ -101,6 +119,11 @@ func (sf defaultLogger) Warn(format string, v ...interface{}) {
 	sf.Printf("[W]: "+format, v...)
 }
 
+// Info Log INFO level message.
+func (sf defaultLogger) Info(format string, v ...interface{}) {
+	sf.Printf("[I]: "+format, v...)
+}
+
 // Debug Log DEBUG level message.
 func (sf defaultLogger) Debug(format string, v ...interface{}) {
 	sf.Printf("[D]: "+format, v...)
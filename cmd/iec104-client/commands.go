@@ -0,0 +1,322 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/client"
+)
+
+// errQuit the quit command
+var errQuit = errors.New("quit")
+
+// usage of the commands
+const commandsUsage = `commands:
+  gi [ca]                       general interrogation, wait the termination
+  ci [ca]                       counter interrogation, wait the termination
+  read <ioa> [ca]               read command
+  clock [ca]                    clock synchronization with the local time
+  test [ca]                     test command
+  reset [ca]                    reset process command
+  sc <ioa> <on|off> [opts]      single command
+  dc <ioa> <on|off> [opts]      double command
+  rc <ioa> <up|down> [opts]     regulating step command
+  sen <ioa> <value> [opts]      set-point normalized, -1.0~1.0 or raw int16
+  ses <ioa> <value> [opts]      set-point scaled, int16
+  sef <ioa> <value> [opts]      set-point short float
+  bo <ioa> <value> [opts]       bitstring of 32 bit, such as 0x0f
+  ca [ca]                       show or set the default common address
+  table                         print the latest value of the objects
+  stats                         print the link statistics
+  status                        print the connection state
+  help                          print this help
+  quit                          close the connection and exit
+opts: sbo (select before operate), time (with CP56Time2a time tag), ca=<ca>`
+
+// session execute the commands on the client
+type session struct {
+	client  *client.Client
+	handler *handler
+	out     *output
+	w       io.Writer // 命令结果的输出
+	ca      uint16
+	timeout time.Duration // 等待确认的超时
+}
+
+// exec execute a command line
+func (sf *session) exec(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+	name, args := strings.ToLower(args[0]), args[1:]
+	switch name {
+	case "help", "?":
+		fmt.Fprintln(sf.w, commandsUsage)
+		return nil
+	case "quit", "exit":
+		return errQuit
+	case "table":
+		sf.out.printTable(sf.w)
+		return nil
+	case "stats":
+		fmt.Fprintf(sf.w, "%+v\n", sf.client.Stats())
+		return nil
+	case "status":
+		fmt.Fprintf(sf.w, "state: %v, endpoint: %v\n", sf.client.State(), sf.client.ActiveEndpoint())
+		return nil
+	case "ca":
+		if len(args) == 0 {
+			fmt.Fprintf(sf.w, "ca: %d\n", sf.ca)
+			return nil
+		}
+		ca, err := parseCommonAddr(args[0])
+		if err != nil {
+			return err
+		}
+		sf.ca = ca
+		return nil
+	case "gi", "ci", "clock", "test", "reset":
+		ca, err := sf.optionalCommonAddr(args)
+		if err != nil {
+			return err
+		}
+		return sf.system(name, ca)
+	case "read":
+		if len(args) < 1 {
+			return errors.New("usage: read <ioa> [ca]")
+		}
+		ioa, err := parseIoa(args[0])
+		if err != nil {
+			return err
+		}
+		ca, err := sf.optionalCommonAddr(args[1:])
+		if err != nil {
+			return err
+		}
+		return sf.client.SendReadCmd(ca, uint(ioa))
+	}
+
+	cmd, err := sf.parseCommand(name, args)
+	if err != nil {
+		return err
+	}
+	return sf.command(cmd)
+}
+
+// system send the system command and wait the confirmation,
+// and the termination of the interrogation.
+func (sf *session) system(name string, ca uint16) error {
+	var typeID asdu.TypeID
+	var send func() error
+	switch name {
+	case "gi":
+		typeID, send = asdu.C_IC_NA_1, func() error { return sf.client.SendInterrogationCmd(ca) }
+	case "ci":
+		typeID, send = asdu.C_CI_NA_1, func() error { return sf.client.SendCounterInterrogationCmd(ca) }
+	case "clock":
+		typeID, send = asdu.C_CS_NA_1, func() error { return sf.client.SendClockSynchronizationCmd(ca, time.Now()) }
+	case "test":
+		typeID, send = asdu.C_TS_NA_1, func() error { return sf.client.SendTestCmd(ca) }
+	default:
+		typeID, send = asdu.C_RP_NA_1, func() error { return sf.client.SendResetProcessCmd(ca) }
+	}
+	ch, cancel := sf.handler.wait(typeID, asdu.CommonAddr(ca), 0)
+	defer cancel()
+	if err := send(); err != nil {
+		return err
+	}
+	term := typeID == asdu.C_IC_NA_1 || typeID == asdu.C_CI_NA_1
+	return sf.confirm(ch, typeID, term)
+}
+
+// command a control command
+type command struct {
+	typeID asdu.TypeID
+	ca     uint16
+	ioa    asdu.InfoObjAddr
+	value  interface{}
+	sbo    bool
+}
+
+// parseCommand parse the control command, <ioa> <value> [sbo] [time] [ca=<ca>]
+func (sf *session) parseCommand(name string, args []string) (*command, error) {
+	types, ok := map[string][2]asdu.TypeID{
+		"sc":  {asdu.C_SC_NA_1, asdu.C_SC_TA_1},
+		"dc":  {asdu.C_DC_NA_1, asdu.C_DC_TA_1},
+		"rc":  {asdu.C_RC_NA_1, asdu.C_RC_TA_1},
+		"sen": {asdu.C_SE_NA_1, asdu.C_SE_TA_1},
+		"ses": {asdu.C_SE_NB_1, asdu.C_SE_TB_1},
+		"sef": {asdu.C_SE_NC_1, asdu.C_SE_TC_1},
+		"bo":  {asdu.C_BO_NA_1, asdu.C_BO_TA_1},
+	}[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q, try help", name)
+	}
+	if len(args) < 2 {
+		return nil, fmt.Errorf("usage: %s <ioa> <value> [sbo] [time] [ca=<ca>]", name)
+	}
+	ioa, err := parseIoa(args[0])
+	if err != nil {
+		return nil, err
+	}
+	cmd := &command{typeID: types[0], ca: sf.ca, ioa: ioa}
+	if cmd.value, err = parseValue(name, args[1]); err != nil {
+		return nil, err
+	}
+	for _, opt := range args[2:] {
+		switch opt = strings.ToLower(opt); {
+		case opt == "sbo":
+			cmd.sbo = true
+		case opt == "time":
+			cmd.typeID = types[1]
+		case strings.HasPrefix(opt, "ca="):
+			if cmd.ca, err = parseCommonAddr(opt[3:]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %q", opt)
+		}
+	}
+	if cmd.sbo && name == "bo" {
+		return nil, errors.New("bitstring command has no select")
+	}
+	return cmd, nil
+}
+
+// command send the control command, select first if sbo, and wait the confirmations
+// and the termination of the execution
+func (sf *session) command(cmd *command) error {
+	ch, cancel := sf.handler.wait(cmd.typeID, asdu.CommonAddr(cmd.ca), cmd.ioa)
+	defer cancel()
+	if cmd.sbo {
+		if err := sf.client.SendSelectCmd(cmd.ca, cmd.typeID, cmd.ioa, cmd.value); err != nil {
+			return err
+		}
+		if err := sf.confirm(ch, cmd.typeID, false); err != nil {
+			return fmt.Errorf("select: %w", err)
+		}
+		sf.out.printf("select confirmed, execute")
+	}
+	if err := sf.client.SendCmd(cmd.ca, cmd.typeID, cmd.ioa, cmd.value); err != nil {
+		return err
+	}
+	if err := sf.confirm(ch, cmd.typeID, true); err != nil {
+		return fmt.Errorf("execute: %w", err)
+	}
+	sf.out.printf("execute terminated")
+	return nil
+}
+
+// confirm wait the positive activation confirmation, and the termination if term
+func (sf *session) confirm(ch <-chan confirmation, typeID asdu.TypeID, term bool) error {
+	timeout := time.NewTimer(sf.timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case c := <-ch:
+			switch {
+			case c.Identifier.Coa.IsNegative:
+				return fmt.Errorf("%s negative %s", typeID, c.Identifier.Coa.Cause)
+			case c.Identifier.Coa.Cause == asdu.ActivationCon && !term:
+				return nil
+			case c.Identifier.Coa.Cause == asdu.ActivationCon:
+				sf.out.printf("%s activation confirmed, wait termination", typeID)
+			case c.Identifier.Coa.Cause == asdu.ActivationTerm:
+				return nil
+			case c.Identifier.Coa.Cause != asdu.ActivationCon:
+				return fmt.Errorf("%s %s", typeID, c.Identifier.Coa.Cause)
+			}
+		case <-timeout.C:
+			return fmt.Errorf("%s confirmation timeout %v", typeID, sf.timeout)
+		}
+	}
+}
+
+func (sf *session) optionalCommonAddr(args []string) (uint16, error) {
+	if len(args) == 0 {
+		return sf.ca, nil
+	}
+	return parseCommonAddr(args[0])
+}
+
+func parseCommonAddr(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	if err != nil || v == 0 {
+		return 0, fmt.Errorf("invalid common address %q", s)
+	}
+	return uint16(v), nil
+}
+
+func parseIoa(s string) (asdu.InfoObjAddr, error) {
+	v, err := strconv.ParseUint(s, 0, 24)
+	if err != nil {
+		return 0, fmt.Errorf("invalid information object address %q", s)
+	}
+	return asdu.InfoObjAddr(v), nil
+}
+
+// parseValue parse the value of the control command
+func parseValue(name, s string) (interface{}, error) {
+	switch name {
+	case "sc":
+		switch strings.ToLower(s) {
+		case "on", "1", "true":
+			return true, nil
+		case "off", "0", "false":
+			return false, nil
+		}
+	case "dc":
+		switch strings.ToLower(s) {
+		case "on":
+			return uint8(asdu.DCOOn), nil
+		case "off":
+			return uint8(asdu.DCOOff), nil
+		}
+		if v, err := strconv.ParseUint(s, 0, 2); err == nil {
+			return uint8(v), nil
+		}
+	case "rc":
+		switch strings.ToLower(s) {
+		case "down", "lower":
+			return uint8(asdu.SCOStepDown), nil
+		case "up", "higher":
+			return uint8(asdu.SCOStepUP), nil
+		}
+		if v, err := strconv.ParseUint(s, 0, 2); err == nil {
+			return uint8(v), nil
+		}
+	case "sen":
+		if strings.ContainsAny(s, ".eE") {
+			f, err := strconv.ParseFloat(s, 64)
+			if err == nil && f >= -1 && f < 1 {
+				return int16(math.Min(math.Round(f*32768), math.MaxInt16)), nil
+			}
+		} else if v, err := strconv.ParseInt(s, 0, 16); err == nil {
+			return int16(v), nil
+		}
+	case "ses":
+		if v, err := strconv.ParseInt(s, 0, 16); err == nil {
+			return int16(v), nil
+		}
+	case "sef":
+		if v, err := strconv.ParseFloat(s, 32); err == nil {
+			return float32(v), nil
+		}
+	case "bo":
+		if v, err := strconv.ParseUint(s, 0, 32); err == nil {
+			return uint32(v), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid value %q of %s", s, name)
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+// point an information object received
+type point struct {
+	Received   time.Time        `json:"received"`
+	Type       string           `json:"type"`
+	Cause      string           `json:"cause"`
+	Negative   bool             `json:"negative,omitempty"`
+	Test       bool             `json:"test,omitempty"`
+	CommonAddr asdu.CommonAddr  `json:"ca"`
+	Ioa        asdu.InfoObjAddr `json:"ioa"`
+	Value      interface{}      `json:"value,omitempty"`
+	Quality    string           `json:"quality,omitempty"` // the other fields of the object, such as Qds
+	Time       *time.Time       `json:"time,omitempty"`    // time tag of the object
+}
+
+// newPoints split the decoded asdu into points
+func newPoints(received time.Time, d *cs104.DecodedASDU) []point {
+	head := point{
+		Received:   received,
+		Type:       typeName(d.Type),
+		Cause:      d.Cause,
+		Negative:   d.Negative,
+		Test:       d.Test,
+		CommonAddr: d.CommonAddr,
+	}
+	var objs []interface{}
+	if v := reflect.ValueOf(d.Objects); v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			objs = append(objs, v.Index(i).Interface())
+		}
+	} else if d.Objects != nil {
+		objs = append(objs, d.Objects)
+	}
+	if len(objs) == 0 {
+		head.Quality = d.Error
+		return []point{head}
+	}
+	points := make([]point, 0, len(objs))
+	for _, obj := range objs {
+		p := head
+		v := reflect.ValueOf(obj)
+		var quality []string
+		for i := 0; i < v.NumField(); i++ {
+			name, field := v.Type().Field(i).Name, v.Field(i)
+			switch {
+			case name == "Ioa":
+				p.Ioa = asdu.InfoObjAddr(field.Uint())
+			case name == "Value":
+				p.Value = field.Interface()
+			case name == "Time":
+				if t, ok := field.Interface().(time.Time); ok && !t.IsZero() {
+					p.Time = &t
+				} else if t, ok := field.Interface().(*time.Time); ok && t != nil {
+					p.Time = t
+				}
+			default:
+				quality = append(quality, fmt.Sprintf("%s=%v", name, field.Interface()))
+			}
+		}
+		p.Quality = strings.Join(quality, " ")
+		points = append(points, p)
+	}
+	return points
+}
+
+// typeName the name of the type without "TID<>", such as M_SP_NA_1
+func typeName(t asdu.TypeID) string {
+	return strings.TrimSuffix(strings.TrimPrefix(t.String(), "TID<"), ">")
+}
+
+// confirmation the activation confirmation or termination of a command
+type confirmation struct {
+	Identifier asdu.Identifier
+	Ioa        asdu.InfoObjAddr
+}
+
+// waiter wait the confirmation of a command
+type waiter struct {
+	typeID asdu.TypeID
+	ca     asdu.CommonAddr
+	ioa    asdu.InfoObjAddr
+	ch     chan confirmation
+}
+
+// handler a cs104.ClientHandlerInterface print the data received and
+// notify the waiters of the command confirmations.
+type handler struct {
+	params *asdu.Params
+	out    *output
+
+	mu      sync.Mutex
+	waiters []*waiter
+}
+
+// wait register a waiter of the confirmation, call the cancel after done
+func (sf *handler) wait(typeID asdu.TypeID, ca asdu.CommonAddr, ioa asdu.InfoObjAddr) (<-chan confirmation, func()) {
+	w := &waiter{typeID, ca, ioa, make(chan confirmation, 4)}
+	sf.mu.Lock()
+	sf.waiters = append(sf.waiters, w)
+	sf.mu.Unlock()
+	return w.ch, func() {
+		sf.mu.Lock()
+		defer sf.mu.Unlock()
+		for i, v := range sf.waiters {
+			if v == w {
+				sf.waiters = append(sf.waiters[:i], sf.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (sf *handler) handle(a *asdu.ASDU) error {
+	received := time.Now()
+	raw, err := a.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	d, err := cs104.DecodeASDU(raw, sf.params)
+	if err != nil {
+		return err
+	}
+	points := newPoints(received, d)
+	if a.Type >= asdu.C_SC_NA_1 && (a.Coa.Cause == asdu.ActivationCon ||
+		a.Coa.Cause == asdu.DeactivationCon || a.Coa.Cause == asdu.ActivationTerm ||
+		a.Coa.Cause >= asdu.UnknownTypeID) {
+		sf.notify(confirmation{a.Identifier, points[0].Ioa})
+	}
+	sf.out.points(points)
+	return nil
+}
+
+func (sf *handler) notify(c confirmation) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, w := range sf.waiters {
+		if w.typeID == c.Identifier.Type && w.ca == c.Identifier.CommonAddr && w.ioa == c.Ioa {
+			select {
+			case w.ch <- c:
+			default:
+			}
+		}
+	}
+}
+
+func (sf *handler) InterrogationHandler(_ asdu.Connect, a *asdu.ASDU) error { return sf.handle(a) }
+func (sf *handler) CounterInterrogationHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.handle(a)
+}
+func (sf *handler) ReadHandler(_ asdu.Connect, a *asdu.ASDU) error             { return sf.handle(a) }
+func (sf *handler) TestCommandHandler(_ asdu.Connect, a *asdu.ASDU) error      { return sf.handle(a) }
+func (sf *handler) ClockSyncHandler(_ asdu.Connect, a *asdu.ASDU) error        { return sf.handle(a) }
+func (sf *handler) ResetProcessHandler(_ asdu.Connect, a *asdu.ASDU) error     { return sf.handle(a) }
+func (sf *handler) DelayAcquisitionHandler(_ asdu.Connect, a *asdu.ASDU) error { return sf.handle(a) }
+func (sf *handler) ASDUHandler(_ asdu.Connect, a *asdu.ASDU) error             { return sf.handle(a) }
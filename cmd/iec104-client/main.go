@@ -0,0 +1,243 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+// Command iec104-client an IEC 60870-5-104 master for commissioning and tests.
+//
+//	iec104-client [flags] host[:port] [command [args...]]
+//
+// With a command, it connects, executes the command, waits the confirmations and exits,
+// such as "gi", "sc 1001 on sbo". With -watch, it keeps printing the data received.
+// Without both, it runs an interactive REPL, type "help" for the commands.
+// The data received are printed as text, JSON lines, or a live table with -format table.
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/client"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+type options struct {
+	format    string
+	ca        uint
+	timeout   time.Duration
+	watch     bool
+	reconnect bool
+	verbose   bool
+
+	params  asdu.Params
+	origin  uint
+	cfg     cs104.Config
+	k, w    uint
+	tls     bool
+	tlsCA   string
+	tlsCert string
+	tlsKey  string
+	tlsName string
+	tlsSkip bool
+}
+
+func main() {
+	opt := options{cfg: cs104.DefaultConfig(), params: *asdu.ParamsWide}
+	flag.StringVar(&opt.format, "format", formatText, "output format of the data received, text, json or table")
+	flag.UintVar(&opt.ca, "ca", 1, "default common address")
+	flag.DurationVar(&opt.timeout, "timeout", 10*time.Second, "timeout waiting the confirmations")
+	flag.BoolVar(&opt.watch, "watch", false, "keep printing the data received until interrupted")
+	flag.BoolVar(&opt.reconnect, "reconnect", false, "reconnect after the connection lost")
+	flag.BoolVar(&opt.verbose, "v", false, "print the protocol log")
+
+	flag.IntVar(&opt.params.CauseSize, "cot-size", opt.params.CauseSize, "size of the cause of transmission, 1 or 2")
+	flag.IntVar(&opt.params.CommonAddrSize, "ca-size", opt.params.CommonAddrSize, "size of the common address, 1 or 2")
+	flag.IntVar(&opt.params.InfoObjAddrSize, "ioa-size", opt.params.InfoObjAddrSize, "size of the information object address, 1, 2 or 3")
+	flag.UintVar(&opt.origin, "orig", 0, "originator address, cot-size 2 only")
+	flag.DurationVar(&opt.cfg.ConnectTimeout0, "t0", opt.cfg.ConnectTimeout0, "connect timeout t0")
+	flag.DurationVar(&opt.cfg.SendUnAckTimeout1, "t1", opt.cfg.SendUnAckTimeout1, "send or test APDU timeout t1")
+	flag.DurationVar(&opt.cfg.RecvUnAckTimeout2, "t2", opt.cfg.RecvUnAckTimeout2, "acknowledge timeout t2")
+	flag.DurationVar(&opt.cfg.IdleTimeout3, "t3", opt.cfg.IdleTimeout3, "idle timeout t3 to send the test frame")
+	flag.UintVar(&opt.k, "k", uint(opt.cfg.SendUnAckLimitK), "max number of the unacknowledged I-frames sent")
+	flag.UintVar(&opt.w, "w", uint(opt.cfg.RecvUnAckLimitW), "acknowledge after w I-frames received")
+
+	flag.BoolVar(&opt.tls, "tls", false, "connect with tls, the default port is 19998")
+	flag.StringVar(&opt.tlsCA, "tls-ca", "", "CA certificate file verifying the server, the system pool if empty")
+	flag.StringVar(&opt.tlsCert, "tls-cert", "", "client certificate file")
+	flag.StringVar(&opt.tlsKey, "tls-key", "", "client private key file")
+	flag.StringVar(&opt.tlsName, "tls-server-name", "", "server name verifying the certificate, the host if empty")
+	flag.BoolVar(&opt.tlsSkip, "tls-insecure", false, "skip verifying the server certificate")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] host[:port] [command [args...]]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), commandsUsage)
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || (opt.format != formatText && opt.format != formatJSON && opt.format != formatTable) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	settings, err := newSettings(flag.Arg(0), &opt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err = run(settings, strings.Join(flag.Args()[1:], " "), &opt); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// newSettings build the client settings from the address and the flags
+func newSettings(addr string, opt *options) (*client.Settings, error) {
+	if opt.ca == 0 || opt.ca > 65535 || opt.k == 0 || opt.k > 65535 || opt.w == 0 || opt.w > 65535 || opt.origin > 255 {
+		return nil, errors.New("invalid ca, k, w or orig")
+	}
+	settings := client.NewSettings()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, strconv.Itoa(cs104.Port)
+		if opt.tls {
+			port = strconv.Itoa(cs104.PortSecure)
+		}
+	}
+	settings.Host = host
+	if settings.Port, err = strconv.Atoi(port); err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	settings.AutoConnect = opt.reconnect
+	settings.ReconnectInterval = opt.cfg.ConnectTimeout0
+
+	opt.params.OrigAddress = asdu.OriginAddr(opt.origin)
+	if err = opt.params.Valid(); err != nil {
+		return nil, err
+	}
+	settings.Params = &opt.params
+	opt.cfg.SendUnAckLimitK, opt.cfg.RecvUnAckLimitW = uint16(opt.k), uint16(opt.w)
+	if err = opt.cfg.Valid(); err != nil {
+		return nil, err
+	}
+	settings.Cfg104 = &opt.cfg
+
+	if opt.tls {
+		if settings.TLS, err = newTLSConfig(opt); err != nil {
+			return nil, err
+		}
+	}
+	if opt.verbose {
+		settings.LogCfg = &client.LogCfg{Enable: true}
+	}
+	return settings, nil
+}
+
+// newTLSConfig the tls config of the flags
+func newTLSConfig(opt *options) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opt.tlsName,
+		InsecureSkipVerify: opt.tlsSkip, // nolint: gosec
+	}
+	if opt.tlsCA != "" {
+		pem, err := os.ReadFile(opt.tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", opt.tlsCA)
+		}
+	}
+	if opt.tlsCert != "" || opt.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(opt.tlsCert, opt.tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// run connect and execute the command, or watch, or run the REPL
+func run(settings *client.Settings, command string, opt *options) error {
+	out := newOutput(os.Stdout, opt.format)
+	h := &handler{params: settings.Params, out: out}
+	c := client.New(settings, h)
+	c.SetStateHandler(func(_ *client.Client, ev cs104.ConnStateEvent) {
+		if ev.Reason != cs104.ReasonNone {
+			out.printf("%v: %v", ev.State, ev.Reason)
+		} else {
+			out.printf("%v", ev.State)
+		}
+	})
+	if err := c.Connect(true); err != nil {
+		return err
+	}
+	defer c.Close()
+
+	sess := &session{client: c, handler: h, out: out, w: os.Stdout, ca: uint16(opt.ca), timeout: opt.timeout}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	if command != "" {
+		if err := sess.exec(command); err != nil && err != errQuit {
+			return err
+		}
+		if !opt.watch {
+			if opt.format == formatTable {
+				out.printTable(os.Stdout)
+			}
+			return nil
+		}
+	}
+	if opt.watch {
+		if opt.format == formatTable {
+			stop := out.refresh(500 * time.Millisecond)
+			defer stop()
+		}
+		<-interrupt
+		return nil
+	}
+	return repl(sess, os.Stdin, interrupt)
+}
+
+// repl read and execute the commands until quit or interrupted
+func repl(sess *session, in io.Reader, interrupt <-chan os.Signal) error {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	fmt.Fprintln(sess.w, `type "help" for the commands`)
+	for {
+		fmt.Fprint(sess.w, "iec104> ")
+		select {
+		case <-interrupt:
+			fmt.Fprintln(sess.w)
+			return nil
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+			if err := sess.exec(line); err == errQuit {
+				return nil
+			} else if err != nil {
+				fmt.Fprintf(sess.w, "error: %v\n", err)
+			}
+		}
+	}
+}
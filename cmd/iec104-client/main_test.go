@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/client"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+func Test_parseValue(t *testing.T) {
+	tests := []struct {
+		name, s string
+		want    interface{}
+	}{
+		{"sc", "on", true},
+		{"sc", "0", false},
+		{"dc", "off", uint8(asdu.DCOOff)},
+		{"rc", "up", uint8(asdu.SCOStepUP)},
+		{"sen", "0.5", int16(16384)},
+		{"sen", "-100", int16(-100)},
+		{"ses", "-300", int16(-300)},
+		{"sef", "1.25", float32(1.25)},
+		{"bo", "0x0f", uint32(15)},
+	}
+	for _, tt := range tests {
+		got, err := parseValue(tt.name, tt.s)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.want, got, tt.name)
+	}
+	for _, v := range [][2]string{{"sc", "2"}, {"dc", "4"}, {"sen", "1.0"}, {"ses", "40000"}, {"bo", "x"}} {
+		_, err := parseValue(v[0], v[1])
+		assert.Error(t, err, v)
+	}
+}
+
+func Test_parseCommand(t *testing.T) {
+	sess := &session{ca: 3}
+	cmd, err := sess.parseCommand("sc", []string{"1001", "on", "sbo", "time", "ca=5"})
+	require.NoError(t, err)
+	assert.Equal(t, &command{typeID: asdu.C_SC_TA_1, ca: 5, ioa: 1001, value: true, sbo: true}, cmd)
+
+	cmd, err = sess.parseCommand("sef", []string{"7", "2.5"})
+	require.NoError(t, err)
+	assert.Equal(t, &command{typeID: asdu.C_SE_NC_1, ca: 3, ioa: 7, value: float32(2.5)}, cmd)
+
+	_, err = sess.parseCommand("bo", []string{"1", "1", "sbo"})
+	assert.Error(t, err)
+	_, err = sess.parseCommand("xx", []string{"1", "1"})
+	assert.Error(t, err)
+	_, err = sess.parseCommand("sc", []string{"1", "on", "now"})
+	assert.Error(t, err)
+}
+
+// outstation answer the interrogation and the single commands, ioa 2 is rejected
+type outstation struct {
+	mu      sync.Mutex
+	selects []bool
+}
+
+func (sf *outstation) InterrogationHandler(c asdu.Connect, a *asdu.ASDU, qoi asdu.QualifierOfInterrogation) error {
+	// the information object of a is decoded, build the replies
+	reply := func(cause asdu.Cause) error {
+		r := asdu.NewASDU(c.Params(), a.Identifier)
+		r.Coa.Cause = cause
+		_ = r.AppendInfoObjAddr(asdu.InfoObjAddrIrrelevant)
+		r.AppendBytes(byte(qoi))
+		return c.Send(r)
+	}
+	_ = reply(asdu.ActivationCon)
+	_ = asdu.Single(c, false, asdu.CauseOfTransmission{Cause: asdu.InterrogatedByStation}, a.CommonAddr,
+		asdu.SinglePointInfo{Ioa: 100, Value: true})
+	return reply(asdu.ActivationTerm)
+}
+func (sf *outstation) CounterInterrogationHandler(asdu.Connect, *asdu.ASDU, asdu.QualifierCountCall) error {
+	return nil
+}
+func (sf *outstation) ReadHandler(asdu.Connect, *asdu.ASDU, asdu.InfoObjAddr) error { return nil }
+func (sf *outstation) ClockSyncHandler(asdu.Connect, *asdu.ASDU, time.Time) error   { return nil }
+func (sf *outstation) ResetProcessHandler(asdu.Connect, *asdu.ASDU, asdu.QualifierOfResetProcessCmd) error {
+	return nil
+}
+func (sf *outstation) DelayAcquisitionHandler(asdu.Connect, *asdu.ASDU, uint16) error { return nil }
+func (sf *outstation) ASDUHandler(c asdu.Connect, a *asdu.ASDU) error {
+	if a.Type != asdu.C_SC_NA_1 {
+		return nil
+	}
+	cmd := a.Clone().GetSingleCmd()
+	sf.mu.Lock()
+	sf.selects = append(sf.selects, cmd.Qoc.InSelect)
+	sf.mu.Unlock()
+	a.Coa.IsNegative = cmd.Ioa == 2
+	if cmd.Qoc.InSelect || a.Coa.IsNegative {
+		return a.SendReplyMirror(c, asdu.ActivationCon)
+	}
+	if err := a.Clone().SendReplyMirror(c, asdu.ActivationCon); err != nil {
+		return err
+	}
+	return a.SendReplyMirror(c, asdu.ActivationTerm)
+}
+
+func TestSession(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	station := &outstation{}
+	srv := cs104.NewServer(station)
+	go srv.Serve(listen)
+	defer srv.Close()
+
+	settings := client.NewSettings()
+	settings.Host = "127.0.0.1"
+	settings.Port = listen.Addr().(*net.TCPAddr).Port
+	settings.AutoConnect = false
+	var buf syncBuffer
+	out := newOutput(&buf, formatText)
+	h := &handler{params: settings.Params, out: out}
+	c := client.New(settings, h)
+	require.NoError(t, c.Connect(true))
+	defer c.Close()
+
+	sess := &session{client: c, handler: h, out: out, w: &buf, ca: 1, timeout: 3 * time.Second}
+	require.NoError(t, sess.exec("gi"))
+	assert.Contains(t, buf.String(), "M_SP_NA_1 InterrogatedByStation ca=1 ioa=100 value=true")
+
+	// answered by the server itself with the mirrored C_TS_NA_1
+	require.NoError(t, sess.exec("test"))
+	assert.Contains(t, buf.String(), "C_TS_NA_1 ActivationCon")
+
+	require.NoError(t, sess.exec("sc 1 on sbo"))
+	assert.Contains(t, buf.String(), "select confirmed, execute")
+	assert.Contains(t, buf.String(), "execute terminated")
+	station.mu.Lock()
+	assert.Equal(t, []bool{true, false}, station.selects)
+	station.mu.Unlock()
+
+	assert.EqualError(t, sess.exec("sc 2 off"), "execute: TID<C_SC_NA_1> negative ActivationCon")
+
+	var table bytes.Buffer
+	out.printTable(&table)
+	assert.Contains(t, table.String(), "M_SP_NA_1")
+	assert.Contains(t, table.String(), strconv.Itoa(100))
+
+	assert.Equal(t, errQuit, sess.exec("quit"))
+}
+
+// syncBuffer a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sf *syncBuffer) Write(p []byte) (int, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.buf.Write(p)
+}
+
+func (sf *syncBuffer) String() string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.buf.String()
+}
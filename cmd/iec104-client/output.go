@@ -0,0 +1,172 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+// output format defined
+const (
+	formatText  = "text"  // 每个信息对象一行
+	formatJSON  = "json"  // 每个信息对象一行json
+	formatTable = "table" // 实时表格,刷新显示每个信息对象的最新值
+)
+
+const clearScreen = "\033[H\033[2J"
+
+// pointKey the key of the point in the table
+type pointKey struct {
+	ca   asdu.CommonAddr
+	ioa  asdu.InfoObjAddr
+	data string // monitor or command, the same ioa may be used by both
+}
+
+// output print the points and messages, keep the latest value of the points
+type output struct {
+	w      io.Writer
+	format string
+
+	mu      sync.Mutex
+	table   map[pointKey]point
+	dirty   bool
+	stopped bool
+}
+
+func newOutput(w io.Writer, format string) *output {
+	return &output{w: w, format: format, table: make(map[pointKey]point)}
+}
+
+// points print the points and update the table
+func (sf *output) points(points []point) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, p := range points {
+		data := "monitor"
+		if strings.HasPrefix(p.Type, "C_") || strings.HasPrefix(p.Type, "P_") {
+			data = "command"
+		}
+		sf.table[pointKey{p.CommonAddr, p.Ioa, data}] = p
+		switch sf.format {
+		case formatJSON:
+			b, _ := json.Marshal(p)
+			fmt.Fprintf(sf.w, "%s\n", b)
+		case formatText:
+			fmt.Fprintln(sf.w, p.text())
+		}
+	}
+	sf.dirty = true
+}
+
+// printf print a message, it's ignored by the table and json format
+func (sf *output) printf(format string, args ...interface{}) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.format == formatText {
+		fmt.Fprintf(sf.w, format+"\n", args...)
+	}
+}
+
+// printTable print the table of the latest values
+func (sf *output) printTable(w io.Writer) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.writeTable(w)
+}
+
+func (sf *output) writeTable(w io.Writer) {
+	keys := make([]pointKey, 0, len(sf.table))
+	for k := range sf.table {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ca != keys[j].ca {
+			return keys[i].ca < keys[j].ca
+		}
+		if keys[i].data != keys[j].data {
+			return keys[i].data > keys[j].data // monitor first
+		}
+		return keys[i].ioa < keys[j].ioa
+	})
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CA\tIOA\tTYPE\tVALUE\tQUALITY\tTIME\tCAUSE\tRECEIVED")
+	for _, k := range keys {
+		p := sf.table[k]
+		tm := ""
+		if p.Time != nil {
+			tm = p.Time.Format(timeFormat)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%v\t%s\t%s\t%s\t%s\n",
+			p.CommonAddr, p.Ioa, p.Type, p.value(), p.Quality, tm, p.Cause, p.Received.Format(timeFormat))
+	}
+	tw.Flush()
+}
+
+// refresh redraw the table every interval if there is any update, until stop called
+func (sf *output) refresh(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				sf.mu.Lock()
+				if sf.dirty && !sf.stopped {
+					sf.dirty = false
+					fmt.Fprint(sf.w, clearScreen)
+					sf.writeTable(sf.w)
+				}
+				sf.mu.Unlock()
+			}
+		}
+	}()
+	return func() {
+		sf.mu.Lock()
+		sf.stopped = true
+		sf.mu.Unlock()
+		close(done)
+	}
+}
+
+// timeFormat the time format of the output
+const timeFormat = "2006-01-02 15:04:05.000"
+
+func (sf point) value() interface{} {
+	if sf.Value == nil {
+		return "-"
+	}
+	return sf.Value
+}
+
+func (sf point) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s", sf.Received.Format(timeFormat), sf.Type, sf.Cause)
+	if sf.Negative {
+		b.WriteString(" negative")
+	}
+	if sf.Test {
+		b.WriteString(" test")
+	}
+	fmt.Fprintf(&b, " ca=%d ioa=%d value=%v", sf.CommonAddr, sf.Ioa, sf.value())
+	if sf.Quality != "" {
+		b.WriteString(" " + sf.Quality)
+	}
+	if sf.Time != nil {
+		b.WriteString(" time=" + sf.Time.Format(timeFormat))
+	}
+	return b.String()
+}
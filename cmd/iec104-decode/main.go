@@ -0,0 +1,178 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+// Command iec104-decode decode the IEC 60870-5-104 APDUs in hex.
+//
+//	iec104-decode [flags] [hex...]
+//
+// The hex is taken from the args, or from each line of stdin without args.
+// Spaces, ':', '-', ',' and the "0x" prefix are ignored, several APDUs may be
+// concatenated. The asdu sizes not set by the flags are guessed.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+type options struct {
+	format string
+	params asdu.Params
+}
+
+func main() {
+	var opt options
+	flag.StringVar(&opt.format, "format", "text", "output format, text or json")
+	flag.IntVar(&opt.params.CauseSize, "cot-size", 0, "size of the cause of transmission, 1 or 2, 0 guess")
+	flag.IntVar(&opt.params.CommonAddrSize, "ca-size", 0, "size of the common address, 1 or 2, 0 guess")
+	flag.IntVar(&opt.params.InfoObjAddrSize, "ioa-size", 0, "size of the information object address, 1, 2 or 3, 0 guess")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [hex...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	p := opt.params
+	if (opt.format != "text" && opt.format != "json") ||
+		p.CauseSize < 0 || p.CauseSize > 2 || p.CommonAddrSize < 0 || p.CommonAddrSize > 2 ||
+		p.InfoObjAddrSize < 0 || p.InfoObjAddrSize > 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opt.params.InfoObjTimeZone = asdu.ParamsWide.InfoObjTimeZone
+
+	if flag.NArg() > 0 {
+		if err := decode(os.Stdout, strings.Join(flag.Args(), " "), &opt); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	failed := false
+	scanner := bufio.NewScanner(os.Stdin)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if err := decode(os.Stdout, s, &opt); err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", line, err)
+			failed = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// decode decode and print the APDUs of the hex string
+func decode(w io.Writer, s string, opt *options) error {
+	b, err := parseHex(s)
+	if err != nil {
+		return err
+	}
+	apdus, err := splitAPDUs(b)
+	for _, apdu := range apdus {
+		d, e := cs104.DecodeAPDUWithParams(apdu, &opt.params)
+		if opt.format == "json" {
+			rec := struct {
+				Raw   string             `json:"raw"`
+				APDU  *cs104.DecodedAPDU `json:"apdu,omitempty"`
+				Error string             `json:"error,omitempty"`
+			}{Raw: hex.EncodeToString(apdu), APDU: d}
+			if e != nil {
+				rec.Error = e.Error()
+			}
+			if e = json.NewEncoder(w).Encode(rec); e != nil {
+				return e
+			}
+			continue
+		}
+		if e = printText(w, apdu, d, e); e != nil {
+			return e
+		}
+	}
+	return err
+}
+
+// printText print the APDU, the information objects one per line
+func printText(w io.Writer, apdu []byte, d *cs104.DecodedAPDU, err error) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "% x\n", apdu)
+	if d != nil {
+		head := *d
+		head.ASDU = nil
+		fmt.Fprintf(&b, "  %v\n", &head)
+	}
+	if d != nil && d.ASDU != nil {
+		a := d.ASDU
+		fmt.Fprintf(&b, "  %s %s", a.TypeName, a.Cause)
+		if a.Negative {
+			b.WriteString(" negative")
+		}
+		if a.Test {
+			b.WriteString(" test")
+		}
+		fmt.Fprintf(&b, " %d@%d sq:%t n:%d", a.OrigAddr, a.CommonAddr, a.Sequence, a.Number)
+		fmt.Fprintf(&b, " [cot:%d ca:%d ioa:%d", a.CauseSize, a.CommonAddrSize, a.InfoObjAddrSize)
+		if a.Guessed {
+			b.WriteString(" guessed")
+		}
+		b.WriteString("]\n")
+		if a.Error != "" {
+			fmt.Fprintf(&b, "    error: %s\n", a.Error)
+		} else if v := reflect.ValueOf(a.Objects); v.Kind() == reflect.Slice {
+			for i := 0; i < v.Len(); i++ {
+				fmt.Fprintf(&b, "    %+v\n", v.Index(i).Interface())
+			}
+		} else if a.Objects != nil {
+			fmt.Fprintf(&b, "    %+v\n", a.Objects)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "  error: %v\n", err)
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// parseHex parse the hex string, the separators and the 0x prefix are ignored
+func parseHex(s string) ([]byte, error) {
+	s = strings.NewReplacer("0x", "", "0X", "", " ", "", "\t", "", ":", "", "-", "", ",", "").Replace(s)
+	if s == "" {
+		return nil, errors.New("empty hex")
+	}
+	return hex.DecodeString(s)
+}
+
+// splitAPDUs split the concatenated APDUs by the length field
+func splitAPDUs(b []byte) ([][]byte, error) {
+	var apdus [][]byte
+	r := cs104.NewAPDUReader(bytes.NewReader(b))
+	for {
+		apdu, err := r.ReadAPDU()
+		if err == io.EOF {
+			return apdus, nil
+		}
+		if err != nil {
+			return apdus, err
+		}
+		apdus = append(apdus, apdu)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+func Test_parseHex(t *testing.T) {
+	for _, s := range []string{"68 04 07 00 00 00", "0x68,0x04,0x07,0x00,0x00,0x00", "68:04:07-00-00-00", "680407000000"} {
+		b, err := parseHex(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, []byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00}, b)
+	}
+	_, err := parseHex("68 0g")
+	assert.Error(t, err)
+}
+
+func Test_splitAPDUs(t *testing.T) {
+	apdus, err := splitAPDUs([]byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00, 0x68, 0x04, 0x01, 0x00, 0x02, 0x00})
+	require.NoError(t, err)
+	assert.Len(t, apdus, 2)
+
+	apdus, err = splitAPDUs([]byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00, 0x68, 0x04, 0x01})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Len(t, apdus, 1)
+
+	_, err = splitAPDUs([]byte{0x67, 0x04, 0x07, 0x00, 0x00, 0x00})
+	assert.ErrorIs(t, err, cs104.ErrInvalidAPDU)
+}
+
+func Test_decode(t *testing.T) {
+	const in = "68 0e 00 00 00 00 01 01 03 00 01 00 64 00 00 01 68 04 07 00 00 00"
+
+	var out bytes.Buffer
+	require.NoError(t, decode(&out, in, &options{format: "text"}))
+	assert.Contains(t, out.String(), "I[sendNO: 0, recvNO: 0]")
+	assert.Contains(t, out.String(), "TID<M_SP_NA_1> Spontaneous 0@1 sq:false n:1 [cot:2 ca:2 ioa:3 guessed]")
+	assert.Contains(t, out.String(), "{Ioa:100 Value:true")
+	assert.Contains(t, out.String(), "U[function: StartDtActive]")
+
+	out.Reset()
+	opt := &options{format: "json", params: asdu.Params{CauseSize: 2, CommonAddrSize: 2, InfoObjAddrSize: 3}}
+	require.NoError(t, decode(&out, in, opt))
+	var rec struct {
+		APDU cs104.DecodedAPDU `json:"apdu"`
+	}
+	require.NoError(t, json.NewDecoder(&out).Decode(&rec))
+	require.NotNil(t, rec.APDU.ASDU)
+	assert.False(t, rec.APDU.ASDU.Guessed)
+	assert.Equal(t, asdu.M_SP_NA_1, rec.APDU.ASDU.Type)
+}
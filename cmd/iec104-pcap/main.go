@@ -0,0 +1,154 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+// Command iec104-pcap decode the IEC 60870-5-104 traffic of pcap/pcapng files.
+//
+//	iec104-pcap [flags] file...
+//
+// The tcp streams of the outstation port are reassembled, the APDUs are printed
+// as text or JSON lines. With -replay, the asdus sent by the outstations are fed
+// to a ClientHandlerInterface which prints the handler called.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/capture"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+const timeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+type options struct {
+	port   uint
+	format string
+	replay bool
+	params asdu.Params
+}
+
+func main() {
+	var opt options
+	var cotSize, caSize, ioaSize int
+	flag.UintVar(&opt.port, "port", 2404, "tcp port of the outstation")
+	flag.StringVar(&opt.format, "format", "text", "output format, text or json")
+	flag.BoolVar(&opt.replay, "replay", false, "replay the asdus of the outstations through a client handler")
+	flag.IntVar(&cotSize, "cot-size", asdu.ParamsWide.CauseSize, "size of the cause of transmission, 1 or 2, 0 guess")
+	flag.IntVar(&caSize, "ca-size", asdu.ParamsWide.CommonAddrSize, "size of the common address, 1 or 2, 0 guess")
+	flag.IntVar(&ioaSize, "ioa-size", asdu.ParamsWide.InfoObjAddrSize, "size of the information object address, 1, 2 or 3, 0 guess")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || (opt.format != "text" && opt.format != "json") || opt.port == 0 || opt.port > 65535 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opt.params = asdu.Params{CauseSize: cotSize, CommonAddrSize: caSize, InfoObjAddrSize: ioaSize, InfoObjTimeZone: asdu.ParamsWide.InfoObjTimeZone}
+	if cotSize < 0 || cotSize > 2 || caSize < 0 || caSize > 2 || ioaSize < 0 || ioaSize > 3 ||
+		(opt.replay && opt.params.Valid() != nil) { // replay can't guess the sizes
+		fmt.Fprintln(os.Stderr, asdu.ErrParam)
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, name := range flag.Args() {
+		if err := run(os.Stdout, name, &opt); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func run(w io.Writer, name string, opt *options) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if opt.replay {
+		return replay(w, f, opt)
+	}
+	return dump(w, f, opt)
+}
+
+// record the output of an APDU
+type record struct {
+	Time      string             `json:"time"`
+	Src       string             `json:"src"`
+	Dst       string             `json:"dst"`
+	Direction string             `json:"direction"` // "monitor": 被控站 -> 控制站, "control": 控制站 -> 被控站
+	APDU      *cs104.DecodedAPDU `json:"apdu,omitempty"`
+	Raw       string             `json:"raw,omitempty"` // hex of the apdu failed to decode
+	Error     string             `json:"error,omitempty"`
+}
+
+// dump print every APDU of the capture
+func dump(w io.Writer, r io.Reader, opt *options) error {
+	rd, err := capture.NewReader(r)
+	if err != nil {
+		return err
+	}
+	asm := capture.NewAssembler(uint16(opt.port))
+	enc := json.NewEncoder(w)
+	for {
+		pkt, err := rd.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, apdu := range asm.Feed(pkt) {
+			rec := newRecord(apdu, &opt.params)
+			if opt.format == "json" {
+				if err = enc.Encode(rec); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err = fmt.Fprintln(w, rec.text()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func newRecord(apdu capture.APDU, params *asdu.Params) record {
+	rec := record{
+		Time:      apdu.Time.Format(timeFormat),
+		Src:       apdu.Src.String(),
+		Dst:       apdu.Dst.String(),
+		Direction: "monitor",
+	}
+	if apdu.FromMaster {
+		rec.Direction = "control"
+	}
+	d, err := cs104.DecodeAPDUWithParams(apdu.Raw, params)
+	rec.APDU = d
+	if err != nil {
+		rec.Raw, rec.Error = fmt.Sprintf("% x", apdu.Raw), err.Error()
+	}
+	return rec
+}
+
+func (sf record) text() string {
+	s := fmt.Sprintf("%s %s -> %s", sf.Time, sf.Src, sf.Dst)
+	if sf.APDU != nil {
+		s += " " + sf.APDU.String()
+	}
+	if sf.Error != "" {
+		s += fmt.Sprintf(" [%s] error: %s", sf.Raw, sf.Error)
+	}
+	return s
+}
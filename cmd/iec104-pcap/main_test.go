@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/capture"
+)
+
+func testCapture(t *testing.T) []byte {
+	s := capture.NewStream(capture.Endpoint{IP: net.IPv4(10, 0, 0, 1), Port: 50000}, capture.Endpoint{IP: net.IPv4(10, 0, 0, 2), Port: 2404})
+	var buf bytes.Buffer
+	w, err := capture.NewWriter(&buf, capture.FormatPcap)
+	require.NoError(t, err)
+	ts := time.Unix(1600000000, 0)
+	require.NoError(t, w.WritePacket(ts, s.Packet(true, []byte{0x68, 0x04, 0x07, 0x00, 0x00, 0x00})))
+	// M_SP_NA_1 spontaneous, ioa 100 on
+	require.NoError(t, w.WritePacket(ts, s.Packet(false, []byte{0x68, 0x0e, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x01, 0x03, 0x00, 0x01, 0x00, 0x64, 0x00, 0x00, 0x01})))
+	return buf.Bytes()
+}
+
+func TestDump(t *testing.T) {
+	opt := &options{port: 2404, format: "text", params: *asdu.ParamsWide}
+	var out bytes.Buffer
+	require.NoError(t, dump(&out, bytes.NewReader(testCapture(t)), opt))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "10.0.0.1:50000 -> 10.0.0.2:2404 U[function: StartDtActive]")
+	assert.Contains(t, lines[1], "I[sendNO: 0, recvNO: 0] TID<M_SP_NA_1> Spontaneous 0@1")
+
+	out.Reset()
+	opt.format = "json"
+	require.NoError(t, dump(&out, bytes.NewReader(testCapture(t)), opt))
+	dec := json.NewDecoder(&out)
+	var rec record
+	require.NoError(t, dec.Decode(&rec))
+	assert.Equal(t, "control", rec.Direction)
+	require.NoError(t, dec.Decode(&rec))
+	assert.Equal(t, "monitor", rec.Direction)
+	require.NotNil(t, rec.APDU)
+	require.NotNil(t, rec.APDU.ASDU)
+	assert.Equal(t, asdu.M_SP_NA_1, rec.APDU.ASDU.Type)
+}
+
+func TestReplay(t *testing.T) {
+	opt := &options{port: 2404, params: *asdu.ParamsWide}
+	var out bytes.Buffer
+	require.NoError(t, replay(&out, bytes.NewReader(testCapture(t)), opt))
+	assert.Contains(t, out.String(), "ASDUHandler: ")
+	assert.Contains(t, out.String(), "1 asdus replayed")
+}
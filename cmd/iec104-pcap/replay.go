@@ -0,0 +1,70 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/capture"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+// printHandler a cs104.ClientHandlerInterface print the handler called and the asdu
+type printHandler struct {
+	w      io.Writer
+	params *asdu.Params
+}
+
+func (sf *printHandler) print(name string, a *asdu.ASDU) error {
+	raw, err := a.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	d, err := cs104.DecodeASDU(raw, sf.params)
+	if err != nil {
+		return err
+	}
+	objs := interface{}(d.Error)
+	if d.Error == "" {
+		objs = d.Objects
+	}
+	_, err = fmt.Fprintf(sf.w, "%s: %v %+v\n", name, a.Identifier, objs)
+	return err
+}
+
+func (sf *printHandler) InterrogationHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.print("InterrogationHandler", a)
+}
+func (sf *printHandler) CounterInterrogationHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.print("CounterInterrogationHandler", a)
+}
+func (sf *printHandler) ReadHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.print("ReadHandler", a)
+}
+func (sf *printHandler) TestCommandHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.print("TestCommandHandler", a)
+}
+func (sf *printHandler) ClockSyncHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.print("ClockSyncHandler", a)
+}
+func (sf *printHandler) ResetProcessHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.print("ResetProcessHandler", a)
+}
+func (sf *printHandler) DelayAcquisitionHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.print("DelayAcquisitionHandler", a)
+}
+func (sf *printHandler) ASDUHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.print("ASDUHandler", a)
+}
+
+// replay feed the asdus of the outstations to the print handler
+func replay(w io.Writer, r io.Reader, opt *options) error {
+	h := &printHandler{w, &opt.params}
+	n, err := capture.Replay(r, h, capture.ReplayOptions{Port: uint16(opt.port), Params: &opt.params})
+	fmt.Fprintf(w, "%d asdus replayed\n", n)
+	return err
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// generator kinds defined
+const (
+	genRandom = "random" // 随机游走, 每次变化 [-step, step], 限制在 [min, max]
+	genSine   = "sine"   // 正弦, offset + amplitude*sin(2π*t/period)
+	genToggle = "toggle" // 在 min 和 max 之间切换
+	genRamp   = "ramp"   // 每次增加 step, 超过 max 回到 min
+)
+
+// generator generate the value of a point every interval
+type generator struct {
+	kind      string
+	min, max  float64
+	step      float64
+	amplitude float64
+	offset    float64
+	period    time.Duration
+	interval  time.Duration
+	start     time.Time
+	next      time.Time // 下次产生值的时间
+}
+
+// parseGenerator parse the generator spec, such as "sine amplitude=10 offset=50 period=60s interval=1s",
+// the defaults of min and max are def.
+func parseGenerator(spec string, def [2]float64) (*generator, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	g := &generator{
+		kind:      strings.ToLower(fields[0]),
+		min:       def[0],
+		max:       def[1],
+		step:      1,
+		amplitude: 1,
+		period:    time.Minute,
+		interval:  time.Second,
+	}
+	switch g.kind {
+	case genRandom, genSine, genToggle, genRamp:
+	default:
+		return nil, fmt.Errorf("unknown generator %q", g.kind)
+	}
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid generator option %q", f)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "min":
+			g.min, err = strconv.ParseFloat(value, 64)
+		case "max":
+			g.max, err = strconv.ParseFloat(value, 64)
+		case "step":
+			g.step, err = strconv.ParseFloat(value, 64)
+		case "amplitude":
+			g.amplitude, err = strconv.ParseFloat(value, 64)
+		case "offset":
+			g.offset, err = strconv.ParseFloat(value, 64)
+		case "period":
+			g.period, err = time.ParseDuration(value)
+		case "interval":
+			g.interval, err = time.ParseDuration(value)
+		default:
+			return nil, fmt.Errorf("unknown generator option %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid generator option %q", f)
+		}
+	}
+	if g.min > g.max || g.interval <= 0 || g.period <= 0 {
+		return nil, fmt.Errorf("invalid generator %q, min > max or non-positive interval, period", spec)
+	}
+	return g, nil
+}
+
+// due whether it's time to generate, and schedule the next
+func (sf *generator) due(now time.Time) bool {
+	if sf.start.IsZero() {
+		sf.start, sf.next = now, now.Add(sf.interval)
+		return false
+	}
+	if now.Before(sf.next) {
+		return false
+	}
+	for !now.Before(sf.next) {
+		sf.next = sf.next.Add(sf.interval)
+	}
+	return true
+}
+
+// value generate the next value from the current one
+func (sf *generator) value(v float64, now time.Time) float64 {
+	switch sf.kind {
+	case genRandom:
+		return math.Max(sf.min, math.Min(sf.max, v+(rand.Float64()*2-1)*sf.step)) // nolint: gosec
+	case genSine:
+		return sf.offset + sf.amplitude*math.Sin(2*math.Pi*float64(now.Sub(sf.start))/float64(sf.period))
+	case genToggle:
+		if v == sf.min {
+			return sf.max
+		}
+		return sf.min
+	default: // genRamp
+		if v += sf.step; v > sf.max {
+			v = sf.min
+		}
+		return v
+	}
+}
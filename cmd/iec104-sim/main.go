@@ -0,0 +1,202 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+// Command iec104-sim an IEC 60870-5-104 outstation simulator driven by a point list file.
+//
+//	iec104-sim [flags] points.csv|points.yaml
+//
+// It answers the interrogations and the read commands with the values of the point list,
+// reports the spontaneous changes of the points with a generator, and executes the commands
+// of the command points, the value of the command is written back to the linked point and reported
+// with the cause return information caused by a remote command.
+//
+// The csv file has a header row of the columns ca, ioa, type, value, group, generator, link and name,
+// the yaml file is a list, or a map with the key "points", of the objects with the same keys:
+//
+//	ca,ioa,type,value,group,generator,link,name
+//	1,1001,M_SP_NA_1,on,1,toggle interval=10s,,breaker
+//	1,2001,M_ME_NC_1,50,2,sine amplitude=10 offset=50 period=60s,,voltage
+//	1,3001,M_IT_NA_1,0,1,ramp step=5 max=1000000 interval=5s,,energy
+//	1,6001,C_SC_NA_1,,,,1001,breaker control
+//
+// The generators are random (min, max, step), sine (amplitude, offset, period), toggle (min, max)
+// and ramp (min, max, step), all with the option interval, 1s by default.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/cs104"
+	"github.com/thinkgos/go-iecp5/server"
+)
+
+type options struct {
+	listen        string
+	tick          time.Duration
+	sbo           bool
+	selectTimeout time.Duration
+	verbose       bool
+
+	params  asdu.Params
+	cfg     cs104.Config
+	k, w    uint
+	tlsCA   string
+	tlsCert string
+	tlsKey  string
+}
+
+func main() {
+	opt := options{cfg: cs104.DefaultConfig(), params: *asdu.ParamsWide}
+	flag.StringVar(&opt.listen, "listen", ":2404", "listen address, host:port")
+	flag.DurationVar(&opt.tick, "tick", 100*time.Millisecond, "resolution of the generators")
+	flag.BoolVar(&opt.sbo, "sbo", false, "the commands require select before operate")
+	flag.DurationVar(&opt.selectTimeout, "select-timeout", 10*time.Second, "the select expires after")
+	flag.BoolVar(&opt.verbose, "v", false, "print the protocol log")
+
+	flag.IntVar(&opt.params.CauseSize, "cot-size", opt.params.CauseSize, "size of the cause of transmission, 1 or 2")
+	flag.IntVar(&opt.params.CommonAddrSize, "ca-size", opt.params.CommonAddrSize, "size of the common address, 1 or 2")
+	flag.IntVar(&opt.params.InfoObjAddrSize, "ioa-size", opt.params.InfoObjAddrSize, "size of the information object address, 1, 2 or 3")
+	flag.DurationVar(&opt.cfg.SendUnAckTimeout1, "t1", opt.cfg.SendUnAckTimeout1, "send or test APDU timeout t1")
+	flag.DurationVar(&opt.cfg.RecvUnAckTimeout2, "t2", opt.cfg.RecvUnAckTimeout2, "acknowledge timeout t2")
+	flag.DurationVar(&opt.cfg.IdleTimeout3, "t3", opt.cfg.IdleTimeout3, "idle timeout t3 to send the test frame")
+	flag.UintVar(&opt.k, "k", uint(opt.cfg.SendUnAckLimitK), "max number of the unacknowledged I-frames sent")
+	flag.UintVar(&opt.w, "w", uint(opt.cfg.RecvUnAckLimitW), "acknowledge after w I-frames received")
+
+	flag.StringVar(&opt.tlsCert, "tls-cert", "", "server certificate file, listen with tls if set")
+	flag.StringVar(&opt.tlsKey, "tls-key", "", "server private key file")
+	flag.StringVar(&opt.tlsCA, "tls-ca", "", "CA certificate file verifying the clients")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] points.csv|points.yaml\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	settings, err := newSettings(&opt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	specs, err := loadPoints(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	m, err := newModel(specs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err = run(settings, m, &opt); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// newSettings build the server settings from the flags
+func newSettings(opt *options) (*server.Settings, error) {
+	if opt.k == 0 || opt.k > 65535 || opt.w == 0 || opt.w > 65535 || opt.tick <= 0 {
+		return nil, errors.New("invalid k, w or tick")
+	}
+	settings := server.NewSettings()
+	host, port, err := net.SplitHostPort(opt.listen)
+	if err != nil {
+		return nil, err
+	}
+	settings.Host = host
+	if settings.Port, err = strconv.Atoi(port); err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	if err = opt.params.Valid(); err != nil {
+		return nil, err
+	}
+	settings.Params = &opt.params
+	opt.cfg.SendUnAckLimitK, opt.cfg.RecvUnAckLimitW = uint16(opt.k), uint16(opt.w)
+	if err = opt.cfg.Valid(); err != nil {
+		return nil, err
+	}
+	settings.Cfg104 = &opt.cfg
+
+	if opt.tlsCert != "" || opt.tlsKey != "" || opt.tlsCA != "" {
+		if settings.TLS, err = newTLSConfig(opt); err != nil {
+			return nil, err
+		}
+	}
+	if opt.verbose {
+		settings.LogCfg = &server.LogCfg{Enable: true}
+	}
+	return settings, nil
+}
+
+// newTLSConfig the tls config of the flags, the clients must present a certificate signed by the CA
+func newTLSConfig(opt *options) (*tls.Config, error) {
+	if opt.tlsCert == "" || opt.tlsKey == "" || opt.tlsCA == "" {
+		return nil, errors.New("tls requires -tls-cert, -tls-key and -tls-ca")
+	}
+	cert, err := tls.LoadX509KeyPair(opt.tlsCert, opt.tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	pem, err := os.ReadFile(opt.tlsCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate in %s", opt.tlsCA)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// run serve until interrupted
+func run(settings *server.Settings, m *model, opt *options) error {
+	logger := log.New(os.Stdout, "", log.LstdFlags|log.Lmicroseconds)
+	var srv *server.Server
+	sim := &simulator{
+		model:         m,
+		out:           broadcast{settings.Params, func(a *asdu.ASDU) error { return srv.Send(a) }},
+		sbo:           opt.sbo,
+		selectTimeout: opt.selectTimeout,
+		log:           logger,
+	}
+	srv = server.New(settings, sim)
+	srv.SetOnConnectionHandler(func(c asdu.Connect) {
+		logger.Printf("connected %v", c.UnderlyingConn().RemoteAddr())
+	})
+	srv.SetConnectionLostHandler(func(c asdu.Connect) {
+		logger.Printf("disconnected %v", c.UnderlyingConn().RemoteAddr())
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go sim.run(opt.tick, done)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	if err := srv.Start(); err != nil {
+		return err
+	}
+	logger.Printf("listen %s, %d monitor points, %d command points", opt.listen, len(m.monitor), len(m.command))
+	<-interrupt
+	return srv.Stop()
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+	"github.com/thinkgos/go-iecp5/client"
+	"github.com/thinkgos/go-iecp5/cs104"
+)
+
+const testCSV = `# test points
+ca,ioa,type,value,group,generator,link,name
+1,100,M_SP_NA_1,on,1,,,breaker
+1,101,M_ME_NC_1,12.5,2,"sine amplitude=10 offset=50 period=60s",,voltage
+1,102,M_IT_NA_1,7,1,,,energy
+1,200,C_SC_NA_1,,,,100,breaker control
+`
+
+func Test_readPoints(t *testing.T) {
+	specs, err := readCSV(strings.NewReader(testCSV))
+	require.NoError(t, err)
+	require.Len(t, specs, 4)
+	assert.Equal(t, pointSpec{CA: 1, IOA: 101, Type: "M_ME_NC_1", Value: "12.5", Group: 2,
+		Generator: "sine amplitude=10 offset=50 period=60s", Name: "voltage"}, specs[1])
+	assert.Equal(t, uint32(100), specs[3].Link)
+
+	yamlSpecs, err := readYAML(strings.NewReader(`
+points:
+  - {ca: 1, ioa: 100, type: M_SP_NA_1, value: on, group: 1, name: breaker}
+  - {ca: 1, ioa: 101, type: M_ME_NC_1, value: 12.5, group: 2, generator: "sine amplitude=10 offset=50 period=60s", name: voltage}
+  - {ca: 1, ioa: 102, type: M_IT_NA_1, value: 7, group: 1, name: energy}
+  - {ca: 1, ioa: 200, type: C_SC_NA_1, link: 100, name: breaker control}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, specs, yamlSpecs)
+
+	list, err := readYAML(strings.NewReader("- {ca: 2, ioa: 1, type: 30}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []pointSpec{{CA: 2, IOA: 1, Type: "30"}}, list)
+
+	_, err = readCSV(strings.NewReader("ca,type\n1,M_SP_NA_1\n"))
+	assert.Error(t, err)
+	_, err = readCSV(strings.NewReader("ca,ioa,type\n1,x,M_SP_NA_1\n"))
+	assert.Error(t, err)
+}
+
+func Test_newModel(t *testing.T) {
+	specs, err := readCSV(strings.NewReader(testCSV))
+	require.NoError(t, err)
+	m, err := newModel(specs)
+	require.NoError(t, err)
+	assert.Len(t, m.monitor, 3)
+	assert.Len(t, m.command, 1)
+	assert.Equal(t, []asdu.CommonAddr{1}, m.stations)
+	assert.Equal(t, m.monitor[pointKey{1, 100}], m.command[pointKey{1, 200}].link)
+
+	typeID, err := parseTypeID("30")
+	require.NoError(t, err)
+	assert.Equal(t, asdu.M_SP_TB_1, typeID)
+
+	for _, spec := range []pointSpec{
+		{CA: 1, IOA: 1, Type: "M_XX_NA_1"},
+		{CA: 0, IOA: 1, Type: "M_SP_NA_1"},
+		{CA: 1, IOA: 1, Type: "M_SP_NA_1", Group: 17},
+		{CA: 1, IOA: 1, Type: "M_IT_NA_1", Group: 5},
+		{CA: 1, IOA: 1, Type: "M_SP_NA_1", Generator: "square"},
+		{CA: 1, IOA: 1, Type: "M_SP_NA_1", Value: "x"},
+		{CA: 1, IOA: 1, Type: "C_SC_NA_1", Link: 2},
+	} {
+		_, err = newModel([]pointSpec{spec})
+		assert.Error(t, err, spec)
+	}
+	_, err = newModel([]pointSpec{{CA: 1, IOA: 1, Type: "M_SP_NA_1"}, {CA: 1, IOA: 1, Type: "M_DP_NA_1"}})
+	assert.Error(t, err)
+}
+
+func Test_generator(t *testing.T) {
+	start := time.Now()
+	g, err := parseGenerator("ramp min=0 max=10 step=4 interval=1s", [2]float64{0, 1})
+	require.NoError(t, err)
+	assert.False(t, g.due(start))
+	assert.False(t, g.due(start.Add(500*time.Millisecond)))
+	assert.True(t, g.due(start.Add(time.Second)))
+	assert.False(t, g.due(start.Add(1500*time.Millisecond)))
+	assert.Equal(t, 8.0, g.value(4, start))
+	assert.Equal(t, 0.0, g.value(8, start))
+
+	g, err = parseGenerator("toggle", valueRange(asdu.M_DP_NA_1))
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, g.value(1, start))
+	assert.Equal(t, 1.0, g.value(2, start))
+
+	g, err = parseGenerator("random min=-1 max=1 step=5", [2]float64{0, 1})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		v := g.value(0, start)
+		assert.True(t, v >= -1 && v <= 1, v)
+	}
+
+	g, err = parseGenerator("sine amplitude=2 offset=1 period=4s", [2]float64{0, 1})
+	require.NoError(t, err)
+	g.due(start)
+	assert.InDelta(t, 3.0, g.value(0, start.Add(time.Second)), 1e-9)
+
+	for _, spec := range []string{"square", "ramp step", "ramp step=x", "ramp min=2 max=1", "sine period=0s", "ramp foo=1"} {
+		_, err = parseGenerator(spec, [2]float64{0, 1})
+		assert.Error(t, err, spec)
+	}
+	g, err = parseGenerator("", [2]float64{0, 1})
+	assert.NoError(t, err)
+	assert.Nil(t, g)
+}
+
+func Test_linkValue(t *testing.T) {
+	assert.Equal(t, 1.0, linkValue(asdu.C_SC_NA_1, asdu.M_SP_NA_1, 0, 1))
+	assert.Equal(t, 2.0, linkValue(asdu.C_SC_NA_1, asdu.M_DP_NA_1, 1, 1))
+	assert.Equal(t, 0.0, linkValue(asdu.C_DC_NA_1, asdu.M_SP_NA_1, 1, 1))
+	assert.Equal(t, 2.0, linkValue(asdu.C_DC_NA_1, asdu.M_DP_TB_1, 1, 2))
+	assert.Equal(t, 4.0, linkValue(asdu.C_RC_NA_1, asdu.M_ST_NA_1, 5, float64(asdu.SCOStepDown)))
+	assert.Equal(t, 6.0, linkValue(asdu.C_RC_NA_1, asdu.M_ST_NA_1, 5, float64(asdu.SCOStepUP)))
+	assert.Equal(t, 2.5, linkValue(asdu.C_SE_NC_1, asdu.M_ME_NC_1, 0, 2.5))
+}
+
+// recorder a cs104.ClientHandlerInterface record the summary of the ASDUs received
+type recorder chan string
+
+func (sf recorder) record(a *asdu.ASDU) error {
+	raw, err := a.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	d, err := cs104.DecodeASDU(raw, a.Params)
+	if err != nil {
+		return err
+	}
+	s := fmt.Sprintf("%s %s", typeName(d.Type), d.Cause)
+	if d.Negative {
+		s += " negative"
+	}
+	objs := reflect.ValueOf(d.Objects)
+	if objs.Kind() != reflect.Slice {
+		objs = reflect.Append(reflect.MakeSlice(reflect.SliceOf(objs.Type()), 0, 1), objs)
+	}
+	for i := 0; i < objs.Len(); i++ {
+		obj := objs.Index(i)
+		s += fmt.Sprintf(" %d=%v", obj.FieldByName("Ioa").Uint(), obj.FieldByName("Value").Interface())
+	}
+	sf <- s
+	return nil
+}
+
+func (sf recorder) InterrogationHandler(_ asdu.Connect, a *asdu.ASDU) error { return sf.record(a) }
+func (sf recorder) CounterInterrogationHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	return sf.record(a)
+}
+func (sf recorder) ReadHandler(_ asdu.Connect, a *asdu.ASDU) error             { return sf.record(a) }
+func (sf recorder) TestCommandHandler(_ asdu.Connect, a *asdu.ASDU) error      { return sf.record(a) }
+func (sf recorder) ClockSyncHandler(_ asdu.Connect, a *asdu.ASDU) error        { return sf.record(a) }
+func (sf recorder) ResetProcessHandler(_ asdu.Connect, a *asdu.ASDU) error     { return sf.record(a) }
+func (sf recorder) DelayAcquisitionHandler(_ asdu.Connect, a *asdu.ASDU) error { return sf.record(a) }
+func (sf recorder) ASDUHandler(_ asdu.Connect, a *asdu.ASDU) error             { return sf.record(a) }
+
+func (sf recorder) expect(t *testing.T, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-sf:
+			assert.Equal(t, w, got)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting %q", w)
+		}
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	specs, err := readCSV(strings.NewReader(testCSV))
+	require.NoError(t, err)
+	m, err := newModel(specs)
+	require.NoError(t, err)
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	sim := &simulator{model: m, sbo: true, selectTimeout: time.Second, log: log.New(io.Discard, "", 0)}
+	srv := cs104.NewServer(sim)
+	sim.out = broadcast{asdu.ParamsWide, srv.Send}
+	go srv.Serve(listen)
+	defer srv.Close()
+
+	settings := client.NewSettings()
+	settings.Host = "127.0.0.1"
+	settings.Port = listen.Addr().(*net.TCPAddr).Port
+	settings.AutoConnect = false
+	rec := make(recorder, 32)
+	c := client.New(settings, rec)
+	require.NoError(t, c.Connect(true))
+	defer c.Close()
+
+	require.NoError(t, c.SendInterrogationCmd(1))
+	rec.expect(t,
+		"C_IC_NA_1 ActivationCon 0=20",
+		"M_SP_NA_1 InterrogatedByStation 100=true",
+		"M_ME_NC_1 InterrogatedByStation 101=12.5",
+		"C_IC_NA_1 ActivationTerm 0=20")
+
+	require.NoError(t, c.SendCounterInterrogationCmd(1))
+	rec.expect(t,
+		"C_CI_NA_1 ActivationCon 0={5 0}",
+		"M_IT_NA_1 RequestByGeneralCounter 102={7 0 false false false}",
+		"C_CI_NA_1 ActivationTerm 0={5 0}")
+
+	require.NoError(t, c.SendReadCmd(1, 101))
+	rec.expect(t, "M_ME_NC_1 Request 101=12.5")
+	require.NoError(t, c.SendReadCmd(1, 999))
+	rec.expect(t, "C_RD_NA_1 UnknownIOA negative 999=<nil>")
+
+	// execute without select is rejected
+	require.NoError(t, c.SendCmd(1, asdu.C_SC_NA_1, 200, false))
+	rec.expect(t, "C_SC_NA_1 ActivationCon negative 200=false")
+
+	require.NoError(t, c.SendSelectCmd(1, asdu.C_SC_NA_1, 200, false))
+	rec.expect(t, "C_SC_NA_1 ActivationCon 200=false")
+	require.NoError(t, c.SendCmd(1, asdu.C_SC_NA_1, 200, false))
+	rec.expect(t,
+		"C_SC_NA_1 ActivationCon 200=false",
+		"M_SP_NA_1 ReturnInfoRemote 100=false",
+		"C_SC_NA_1 ActivationTerm 200=false")
+
+	require.NoError(t, c.SendCmd(1, asdu.C_SC_NA_1, 201, true))
+	rec.expect(t, "C_SC_NA_1 UnknownIOA 201=true")
+	require.NoError(t, c.SendCmd(2, asdu.C_SC_NA_1, 200, true))
+	rec.expect(t, "C_SC_NA_1 UnknownCA 200=true")
+
+	sim.spontaneous(m.generate(time.Now()), asdu.Spontaneous, time.Now())
+	changed := m.generate(time.Now().Add(2 * time.Second))
+	require.Len(t, changed, 1)
+	sim.spontaneous(changed, asdu.Spontaneous, time.Now())
+	select {
+	case got := <-rec:
+		assert.True(t, strings.HasPrefix(got, "M_ME_NC_1 Spontaneous 101="), got)
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting the spontaneous change")
+	}
+}
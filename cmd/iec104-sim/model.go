@@ -0,0 +1,375 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+// pointKey the address of a point
+type pointKey struct {
+	ca  asdu.CommonAddr
+	ioa asdu.InfoObjAddr
+}
+
+// point a point of the simulator
+type point struct {
+	pointKey
+	typeID asdu.TypeID
+	group  int
+	link   *point // 命令点回写的状态点
+	name   string
+	gen    *generator
+
+	value    float64
+	selected time.Time // 命令点选择的时间, 零值未选择
+}
+
+// sample the value of a point at a time
+type sample struct {
+	*point
+	value float64
+}
+
+// model the points of the simulator
+type model struct {
+	mu       sync.Mutex
+	points   []*point // monitor points in the order of the file
+	monitor  map[pointKey]*point
+	command  map[pointKey]*point
+	stations []asdu.CommonAddr
+}
+
+// newModel build the model from the point list
+func newModel(specs []pointSpec) (*model, error) {
+	m := &model{
+		monitor: make(map[pointKey]*point),
+		command: make(map[pointKey]*point),
+	}
+	links := make(map[*point]uint32)
+	cas := make(map[asdu.CommonAddr]bool)
+	for i, spec := range specs {
+		p, err := newPoint(spec)
+		if err != nil {
+			return nil, fmt.Errorf("point %d (ca %d, ioa %d): %w", i+1, spec.CA, spec.IOA, err)
+		}
+		table := m.monitor
+		if isCommand(p.typeID) {
+			table = m.command
+			if spec.Link != 0 {
+				links[p] = spec.Link
+			}
+		} else {
+			m.points = append(m.points, p)
+		}
+		if _, ok := table[p.pointKey]; ok {
+			return nil, fmt.Errorf("point %d: duplicate ca %d, ioa %d", i+1, spec.CA, spec.IOA)
+		}
+		table[p.pointKey] = p
+		if !cas[p.ca] {
+			cas[p.ca] = true
+			m.stations = append(m.stations, p.ca)
+		}
+	}
+	for p, ioa := range links {
+		if p.link = m.monitor[pointKey{p.ca, asdu.InfoObjAddr(ioa)}]; p.link == nil {
+			return nil, fmt.Errorf("command ca %d, ioa %d: linked point %d not found", p.ca, p.ioa, ioa)
+		}
+	}
+	if len(m.monitor)+len(m.command) == 0 {
+		return nil, fmt.Errorf("empty point list")
+	}
+	sort.Slice(m.stations, func(i, j int) bool { return m.stations[i] < m.stations[j] })
+	return m, nil
+}
+
+func newPoint(spec pointSpec) (*point, error) {
+	if spec.CA == 0 || spec.CA == uint16(asdu.GlobalCommonAddr) {
+		return nil, fmt.Errorf("invalid ca %d", spec.CA)
+	}
+	if spec.IOA == 0 || spec.IOA > 0xffffff {
+		return nil, fmt.Errorf("invalid ioa %d", spec.IOA)
+	}
+	typeID, err := parseTypeID(spec.Type)
+	if err != nil {
+		return nil, err
+	}
+	p := &point{
+		pointKey: pointKey{asdu.CommonAddr(spec.CA), asdu.InfoObjAddr(spec.IOA)},
+		typeID:   typeID,
+		group:    spec.Group,
+		name:     spec.Name,
+	}
+	maxGroup := 16
+	if isCounter(typeID) {
+		maxGroup = 4
+	}
+	if p.group < 0 || p.group > maxGroup {
+		return nil, fmt.Errorf("invalid group %d", p.group)
+	}
+	if p.value, err = parseNumber(spec.Value); err != nil {
+		return nil, fmt.Errorf("invalid value %q", spec.Value)
+	}
+	if isCommand(typeID) {
+		if spec.Generator != "" {
+			return nil, fmt.Errorf("command has no generator")
+		}
+		return p, nil
+	}
+	if p.gen, err = parseGenerator(spec.Generator, valueRange(typeID)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// valueRange the default range of the value of the type
+func valueRange(t asdu.TypeID) [2]float64 {
+	switch t {
+	case asdu.M_DP_NA_1, asdu.M_DP_TB_1:
+		return [2]float64{float64(asdu.DPIDeterminedOff), float64(asdu.DPIDeterminedOn)}
+	case asdu.M_ST_NA_1, asdu.M_ST_TB_1:
+		return [2]float64{-64, 63}
+	case asdu.M_ME_NA_1, asdu.M_ME_TD_1, asdu.M_ME_ND_1:
+		return [2]float64{-1, 1}
+	case asdu.M_ME_NB_1, asdu.M_ME_TE_1:
+		return [2]float64{math.MinInt16, math.MaxInt16}
+	case asdu.M_ME_NC_1, asdu.M_ME_TF_1:
+		return [2]float64{0, 100}
+	case asdu.M_BO_NA_1, asdu.M_BO_TB_1:
+		return [2]float64{0, math.MaxUint32}
+	case asdu.M_IT_NA_1, asdu.M_IT_TB_1:
+		return [2]float64{0, math.MaxInt32}
+	}
+	return [2]float64{0, 1}
+}
+
+// isCounter whether the type is the integrated totals
+func isCounter(t asdu.TypeID) bool {
+	return t == asdu.M_IT_NA_1 || t == asdu.M_IT_TB_1
+}
+
+// hasStation whether there are points of the common address
+func (sf *model) hasStation(ca asdu.CommonAddr) bool {
+	for _, v := range sf.stations {
+		if v == ca {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot the values of the monitor points of the station matched
+func (sf *model) snapshot(ca asdu.CommonAddr, match func(*point) bool) []sample {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var samples []sample
+	for _, p := range sf.points {
+		if p.ca == ca && match(p) {
+			samples = append(samples, sample{p, p.value})
+		}
+	}
+	return samples
+}
+
+// read the value of a monitor point
+func (sf *model) read(ca asdu.CommonAddr, ioa asdu.InfoObjAddr) (sample, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	p, ok := sf.monitor[pointKey{ca, ioa}]
+	if !ok {
+		return sample{}, false
+	}
+	return sample{p, p.value}, true
+}
+
+// generate the values of the generators due, returns the points changed
+func (sf *model) generate(now time.Time) []sample {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	var changed []sample
+	for _, p := range sf.points {
+		if p.gen == nil || !p.gen.due(now) {
+			continue
+		}
+		if v := p.gen.value(p.value, now); v != p.value {
+			p.value = v
+			changed = append(changed, sample{p, v})
+		}
+	}
+	return changed
+}
+
+// untimed the type without time tag, the interrogation replies with it
+func untimed(t asdu.TypeID) asdu.TypeID {
+	switch t {
+	case asdu.M_SP_TB_1:
+		return asdu.M_SP_NA_1
+	case asdu.M_DP_TB_1:
+		return asdu.M_DP_NA_1
+	case asdu.M_ST_TB_1:
+		return asdu.M_ST_NA_1
+	case asdu.M_BO_TB_1:
+		return asdu.M_BO_NA_1
+	case asdu.M_ME_TD_1:
+		return asdu.M_ME_NA_1
+	case asdu.M_ME_TE_1:
+		return asdu.M_ME_NB_1
+	case asdu.M_ME_TF_1:
+		return asdu.M_ME_NC_1
+	}
+	return t
+}
+
+// sendSamples send the samples grouped by the type in as few ASDUs as possible,
+// the type is made untimed if asUntimed, such as the interrogation.
+func sendSamples(c asdu.Connect, cause asdu.Cause, ca asdu.CommonAddr, samples []sample, asUntimed bool, now time.Time) error {
+	var types []asdu.TypeID
+	byType := make(map[asdu.TypeID][]sample)
+	for _, s := range samples {
+		t := s.typeID
+		if asUntimed {
+			t = untimed(t)
+		}
+		if _, ok := byType[t]; !ok {
+			types = append(types, t)
+		}
+		byType[t] = append(byType[t], s)
+	}
+	params := c.Params()
+	for _, t := range types {
+		objSize, err := asdu.GetInfoObjSize(t)
+		if err != nil {
+			return err
+		}
+		limit := (asdu.ASDUSizeMax - params.IdentifierSize()) / (objSize + params.InfoObjAddrSize)
+		if limit > 127 {
+			limit = 127
+		}
+		for list := byType[t]; len(list) > 0; {
+			n := len(list)
+			if n > limit {
+				n = limit
+			}
+			if err = encode(c, t, asdu.CauseOfTransmission{Cause: cause}, ca, list[:n], now); err != nil {
+				return err
+			}
+			list = list[n:]
+		}
+	}
+	return nil
+}
+
+// encode send the samples with the type
+func encode(c asdu.Connect, t asdu.TypeID, coa asdu.CauseOfTransmission, ca asdu.CommonAddr, samples []sample, now time.Time) error {
+	switch t {
+	case asdu.M_SP_NA_1, asdu.M_SP_TB_1:
+		infos := make([]asdu.SinglePointInfo, 0, len(samples))
+		for _, s := range samples {
+			infos = append(infos, asdu.SinglePointInfo{Ioa: s.ioa, Value: s.value != 0, Time: now})
+		}
+		if t == asdu.M_SP_TB_1 {
+			return asdu.SingleCP56Time2a(c, coa, ca, infos...)
+		}
+		return asdu.Single(c, false, coa, ca, infos...)
+	case asdu.M_DP_NA_1, asdu.M_DP_TB_1:
+		infos := make([]asdu.DoublePointInfo, 0, len(samples))
+		for _, s := range samples {
+			infos = append(infos, asdu.DoublePointInfo{Ioa: s.ioa, Value: asdu.DoublePoint(clamp(s.value, 0, 3)), Time: now})
+		}
+		if t == asdu.M_DP_TB_1 {
+			return asdu.DoubleCP56Time2a(c, coa, ca, infos...)
+		}
+		return asdu.Double(c, false, coa, ca, infos...)
+	case asdu.M_ST_NA_1, asdu.M_ST_TB_1:
+		infos := make([]asdu.StepPositionInfo, 0, len(samples))
+		for _, s := range samples {
+			infos = append(infos, asdu.StepPositionInfo{Ioa: s.ioa, Value: asdu.StepPosition{Val: int(clamp(s.value, -64, 63))}, Time: now})
+		}
+		if t == asdu.M_ST_TB_1 {
+			return asdu.StepCP56Time2a(c, coa, ca, infos...)
+		}
+		return asdu.Step(c, false, coa, ca, infos...)
+	case asdu.M_BO_NA_1, asdu.M_BO_TB_1:
+		infos := make([]asdu.BitString32Info, 0, len(samples))
+		for _, s := range samples {
+			infos = append(infos, asdu.BitString32Info{Ioa: s.ioa, Value: uint32(clamp(s.value, 0, math.MaxUint32)), Time: now})
+		}
+		if t == asdu.M_BO_TB_1 {
+			return asdu.BitString32CP56Time2a(c, coa, ca, infos...)
+		}
+		return asdu.BitString32(c, false, coa, ca, infos...)
+	case asdu.M_ME_NA_1, asdu.M_ME_TD_1, asdu.M_ME_ND_1:
+		infos := make([]asdu.MeasuredValueNormalInfo, 0, len(samples))
+		for _, s := range samples {
+			infos = append(infos, asdu.MeasuredValueNormalInfo{Ioa: s.ioa, Value: normalize(s.value), Time: now})
+		}
+		switch t {
+		case asdu.M_ME_TD_1:
+			return asdu.MeasuredValueNormalCP56Time2a(c, coa, ca, infos...)
+		case asdu.M_ME_ND_1:
+			return asdu.MeasuredValueNormalNoQuality(c, false, coa, ca, infos...)
+		}
+		return asdu.MeasuredValueNormal(c, false, coa, ca, infos...)
+	case asdu.M_ME_NB_1, asdu.M_ME_TE_1:
+		infos := make([]asdu.MeasuredValueScaledInfo, 0, len(samples))
+		for _, s := range samples {
+			infos = append(infos, asdu.MeasuredValueScaledInfo{Ioa: s.ioa, Value: int16(clamp(s.value, math.MinInt16, math.MaxInt16)), Time: now})
+		}
+		if t == asdu.M_ME_TE_1 {
+			return asdu.MeasuredValueScaledCP56Time2a(c, coa, ca, infos...)
+		}
+		return asdu.MeasuredValueScaled(c, false, coa, ca, infos...)
+	case asdu.M_ME_NC_1, asdu.M_ME_TF_1:
+		infos := make([]asdu.MeasuredValueFloatInfo, 0, len(samples))
+		for _, s := range samples {
+			infos = append(infos, asdu.MeasuredValueFloatInfo{Ioa: s.ioa, Value: float32(s.value), Time: now})
+		}
+		if t == asdu.M_ME_TF_1 {
+			return asdu.MeasuredValueFloatCP56Time2a(c, coa, ca, infos...)
+		}
+		return asdu.MeasuredValueFloat(c, false, coa, ca, infos...)
+	case asdu.M_IT_NA_1, asdu.M_IT_TB_1:
+		infos := make([]asdu.BinaryCounterReadingInfo, 0, len(samples))
+		for _, s := range samples {
+			infos = append(infos, asdu.BinaryCounterReadingInfo{
+				Ioa:   s.ioa,
+				Value: asdu.BinaryCounterReading{CounterReading: int32(clamp(s.value, math.MinInt32, math.MaxInt32))},
+				Time:  now,
+			})
+		}
+		if t == asdu.M_IT_TB_1 {
+			return asdu.IntegratedTotalsCP56Time2a(c, coa, ca, infos...)
+		}
+		return asdu.IntegratedTotals(c, false, coa, ca, infos...)
+	}
+	return asdu.ErrTypeIDNotMatch
+}
+
+// clamp round the value and limit it in [lo, hi]
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, math.Round(v)))
+}
+
+// normalize the value in [-1, 1) to the normalized value
+func normalize(v float64) asdu.Normalize {
+	return asdu.Normalize(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(v*32768))))
+}
+
+// broadcast a asdu.Connect send to all the sessions of the server
+type broadcast struct {
+	params *asdu.Params
+	send   func(*asdu.ASDU) error
+}
+
+func (sf broadcast) Params() *asdu.Params     { return sf.params }
+func (sf broadcast) Send(a *asdu.ASDU) error  { return sf.send(a) }
+func (sf broadcast) UnderlyingConn() net.Conn { return nil }
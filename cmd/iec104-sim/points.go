@@ -0,0 +1,192 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+// pointSpec a point of the point list file
+type pointSpec struct {
+	CA        uint16 `yaml:"ca"`
+	IOA       uint32 `yaml:"ioa"`
+	Type      string `yaml:"type"`
+	Value     string `yaml:"value"`
+	Group     int    `yaml:"group"`     // 召唤组,0只响应站召唤, 1~16 响应站召唤和该组召唤,累计量为1~4
+	Generator string `yaml:"generator"` // 值发生器,如 "sine amplitude=10 period=60s"
+	Link      uint32 `yaml:"link"`      // 命令点,命令值回写的状态点ioa
+	Name      string `yaml:"name"`
+}
+
+// monitorTypes the supported types of the monitor points
+var monitorTypes = []asdu.TypeID{
+	asdu.M_SP_NA_1, asdu.M_SP_TB_1, asdu.M_DP_NA_1, asdu.M_DP_TB_1,
+	asdu.M_ST_NA_1, asdu.M_ST_TB_1, asdu.M_BO_NA_1, asdu.M_BO_TB_1,
+	asdu.M_ME_NA_1, asdu.M_ME_TD_1, asdu.M_ME_ND_1, asdu.M_ME_NB_1, asdu.M_ME_TE_1,
+	asdu.M_ME_NC_1, asdu.M_ME_TF_1, asdu.M_IT_NA_1, asdu.M_IT_TB_1,
+}
+
+// commandTypes the supported types of the command points, the ones with time tag are accepted too
+var commandTypes = []asdu.TypeID{
+	asdu.C_SC_NA_1, asdu.C_DC_NA_1, asdu.C_RC_NA_1,
+	asdu.C_SE_NA_1, asdu.C_SE_NB_1, asdu.C_SE_NC_1, asdu.C_BO_NA_1,
+}
+
+// parseTypeID parse the type name such as M_SP_NA_1, or the number
+func parseTypeID(s string) (asdu.TypeID, error) {
+	if v, err := strconv.ParseUint(s, 10, 8); err == nil {
+		s = asdu.TypeID(v).String()
+	}
+	s = strings.ToUpper(strings.TrimSuffix(strings.TrimPrefix(s, "TID<"), ">"))
+	for _, types := range [][]asdu.TypeID{monitorTypes, commandTypes} {
+		for _, t := range types {
+			if typeName(t) == s {
+				return t, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unsupported type %q", s)
+}
+
+// typeName the name of the type without "TID<>", such as M_SP_NA_1
+func typeName(t asdu.TypeID) string {
+	return strings.TrimSuffix(strings.TrimPrefix(t.String(), "TID<"), ">")
+}
+
+// isCommand whether the type is a command type
+func isCommand(t asdu.TypeID) bool {
+	return t >= asdu.C_SC_NA_1 && t <= asdu.C_BO_TA_1
+}
+
+// parseNumber parse the value, on/off and true/false are 1/0, empty is 0
+func parseNumber(s string) (float64, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return 0, nil
+	case "on", "true":
+		return 1, nil
+	case "off", "false":
+		return 0, nil
+	}
+	if v, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return float64(v), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// loadPoints load the point list, the file is yaml if the extension is .yaml or .yml, otherwise csv
+func loadPoints(name string) ([]pointSpec, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return readYAML(f)
+	}
+	return readCSV(f)
+}
+
+// readYAML read the points of yaml, a list of the points or a map with the key "points"
+func readYAML(r io.Reader) ([]pointSpec, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty point list")
+		}
+		return nil, err
+	}
+	var specs []pointSpec
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+		var v struct {
+			Points []pointSpec `yaml:"points"`
+		}
+		if err := doc.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v.Points, nil
+	}
+	if err := doc.Decode(&specs); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// readCSV read the points of csv, the first row is the header of the columns
+// ca, ioa, type, value, group, generator, link and name, the ca, ioa and type are required.
+// The lines start with # are ignored.
+func readCSV(r io.Reader) ([]pointSpec, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("empty point list")
+		}
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, h := range []string{"ca", "ioa", "type"} {
+		if _, ok := columns[h]; !ok {
+			return nil, fmt.Errorf("missing column %q", h)
+		}
+	}
+
+	var specs []pointSpec
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return specs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		var spec pointSpec
+		var ca, ioa, group, link uint64
+		if ca, err = strconv.ParseUint(field("ca"), 0, 16); err != nil {
+			return nil, fmt.Errorf("line %d: invalid ca %q", line, field("ca"))
+		}
+		if ioa, err = strconv.ParseUint(field("ioa"), 0, 24); err != nil {
+			return nil, fmt.Errorf("line %d: invalid ioa %q", line, field("ioa"))
+		}
+		if s := field("group"); s != "" {
+			if group, err = strconv.ParseUint(s, 10, 8); err != nil {
+				return nil, fmt.Errorf("line %d: invalid group %q", line, s)
+			}
+		}
+		if s := field("link"); s != "" {
+			if link, err = strconv.ParseUint(s, 0, 24); err != nil {
+				return nil, fmt.Errorf("line %d: invalid link %q", line, s)
+			}
+		}
+		spec.CA, spec.IOA, spec.Group, spec.Link = uint16(ca), uint32(ioa), int(group), uint32(link)
+		spec.Type, spec.Value, spec.Generator, spec.Name = field("type"), field("value"), field("generator"), field("name")
+		specs = append(specs, spec)
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+// simulator a cs104.ServerHandlerInterface answer the requests from the point list
+type simulator struct {
+	model         *model
+	out           asdu.Connect  // spontaneous changes, to all the sessions
+	sbo           bool          // execute requires select
+	selectTimeout time.Duration // select expires after
+	log           *log.Logger
+}
+
+// run generate the values every tick and send the changes, until done closed
+func (sf *simulator) run(tick time.Duration, done <-chan struct{}) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-t.C:
+			sf.spontaneous(sf.model.generate(now), asdu.Spontaneous, now)
+		}
+	}
+}
+
+// spontaneous send the changes of the points by station
+func (sf *simulator) spontaneous(changed []sample, cause asdu.Cause, now time.Time) {
+	byStation := make(map[asdu.CommonAddr][]sample)
+	for _, s := range changed {
+		byStation[s.ca] = append(byStation[s.ca], s)
+	}
+	for _, ca := range sf.model.stations {
+		if samples := byStation[ca]; len(samples) > 0 {
+			if err := sendSamples(sf.out, cause, ca, samples, false, now); err != nil {
+				sf.log.Printf("send %s of ca %d failed, %v", cause, ca, err)
+			}
+		}
+	}
+}
+
+// stations the common addresses addressed by the request, all the stations if global
+func (sf *simulator) stations(ca asdu.CommonAddr) []asdu.CommonAddr {
+	if ca == asdu.GlobalCommonAddr {
+		return sf.model.stations
+	}
+	if sf.model.hasStation(ca) {
+		return []asdu.CommonAddr{ca}
+	}
+	return nil
+}
+
+// reply send the reply of the system command, the information object of the request has been consumed,
+// so it's rebuilt with the ioa 0 and the data.
+func reply(c asdu.Connect, a *asdu.ASDU, ca asdu.CommonAddr, cause asdu.Cause, negative bool, data ...byte) error {
+	r := asdu.NewASDU(a.Params, a.Identifier)
+	r.CommonAddr = ca
+	r.Coa.Cause, r.Coa.IsNegative = cause, negative
+	r.Variable = asdu.VariableStruct{Number: 1}
+	if err := r.AppendInfoObjAddr(asdu.InfoObjAddrIrrelevant); err != nil {
+		return err
+	}
+	r.AppendBytes(data...)
+	return c.Send(r)
+}
+
+// InterrogationHandler answer the station or group interrogation
+func (sf *simulator) InterrogationHandler(c asdu.Connect, a *asdu.ASDU, qoi asdu.QualifierOfInterrogation) error {
+	stations := sf.stations(a.CommonAddr)
+	if len(stations) == 0 {
+		return reply(c, a, a.CommonAddr, asdu.UnknownCA, true, byte(qoi))
+	}
+	if a.Coa.Cause == asdu.Deactivation {
+		return reply(c, a, a.CommonAddr, asdu.DeactivationCon, false, byte(qoi))
+	}
+	if qoi < asdu.QOIStation || qoi > asdu.QOIGroup16 {
+		return reply(c, a, a.CommonAddr, asdu.ActivationCon, true, byte(qoi))
+	}
+	group := int(qoi - asdu.QOIStation)
+	sf.log.Printf("interrogation ca %d, qoi %d", a.CommonAddr, qoi)
+	now := time.Now()
+	for _, ca := range stations {
+		if err := reply(c, a, ca, asdu.ActivationCon, false, byte(qoi)); err != nil {
+			return err
+		}
+		samples := sf.model.snapshot(ca, func(p *point) bool {
+			return !isCounter(p.typeID) && (group == 0 || p.group == group)
+		})
+		if err := sendSamples(c, asdu.Cause(qoi), ca, samples, true, now); err != nil {
+			return err
+		}
+		if err := reply(c, a, ca, asdu.ActivationTerm, false, byte(qoi)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CounterInterrogationHandler answer the counter interrogation with the integrated totals
+func (sf *simulator) CounterInterrogationHandler(c asdu.Connect, a *asdu.ASDU, qcc asdu.QualifierCountCall) error {
+	stations := sf.stations(a.CommonAddr)
+	if len(stations) == 0 {
+		return reply(c, a, a.CommonAddr, asdu.UnknownCA, true, qcc.Value())
+	}
+	if qcc.Request < asdu.QCCGroup1 || qcc.Request > asdu.QCCTotal {
+		return reply(c, a, a.CommonAddr, asdu.ActivationCon, true, qcc.Value())
+	}
+	group := int(qcc.Request)
+	cause := asdu.RequestByGroup1Counter + asdu.Cause(group-1)
+	if qcc.Request == asdu.QCCTotal {
+		group, cause = 0, asdu.RequestByGeneralCounter
+	}
+	sf.log.Printf("counter interrogation ca %d, qcc %d", a.CommonAddr, qcc.Request)
+	now := time.Now()
+	for _, ca := range stations {
+		if err := reply(c, a, ca, asdu.ActivationCon, false, qcc.Value()); err != nil {
+			return err
+		}
+		samples := sf.model.snapshot(ca, func(p *point) bool {
+			return isCounter(p.typeID) && (group == 0 || p.group == group)
+		})
+		if err := sendSamples(c, cause, ca, samples, false, now); err != nil {
+			return err
+		}
+		if err := reply(c, a, ca, asdu.ActivationTerm, false, qcc.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadHandler answer the value of the point, the integrated totals are read by the counter interrogation
+func (sf *simulator) ReadHandler(c asdu.Connect, a *asdu.ASDU, ioa asdu.InfoObjAddr) error {
+	unknown := func(cause asdu.Cause) error {
+		r := asdu.NewASDU(a.Params, a.Identifier)
+		r.Coa.Cause, r.Coa.IsNegative = cause, true
+		if err := r.AppendInfoObjAddr(ioa); err != nil {
+			return err
+		}
+		return c.Send(r)
+	}
+	if !sf.model.hasStation(a.CommonAddr) {
+		return unknown(asdu.UnknownCA)
+	}
+	s, ok := sf.model.read(a.CommonAddr, ioa)
+	if !ok || isCounter(s.typeID) {
+		return unknown(asdu.UnknownIOA)
+	}
+	return sendSamples(c, asdu.Request, a.CommonAddr, []sample{s}, false, time.Now())
+}
+
+// ClockSyncHandler confirm the clock synchronization with the local time, the clock is not changed
+func (sf *simulator) ClockSyncHandler(c asdu.Connect, a *asdu.ASDU, t time.Time) error {
+	sf.log.Printf("clock synchronization ca %d, %v", a.CommonAddr, t)
+	return reply(c, a, a.CommonAddr, asdu.ActivationCon, false, asdu.CP56Time2a(time.Now(), a.InfoObjTimeZone)...)
+}
+
+// ResetProcessHandler confirm the reset process command, nothing is reset
+func (sf *simulator) ResetProcessHandler(c asdu.Connect, a *asdu.ASDU, qrp asdu.QualifierOfResetProcessCmd) error {
+	sf.log.Printf("reset process ca %d, qrp %d", a.CommonAddr, qrp)
+	return reply(c, a, a.CommonAddr, asdu.ActivationCon, false, byte(qrp))
+}
+
+// DelayAcquisitionHandler confirm the delay acquisition command
+func (sf *simulator) DelayAcquisitionHandler(c asdu.Connect, a *asdu.ASDU, msec uint16) error {
+	return reply(c, a, a.CommonAddr, asdu.ActivationCon, false, byte(msec), byte(msec>>8))
+}
+
+// ASDUHandler execute the commands of the command points, an error replies the unknown type
+func (sf *simulator) ASDUHandler(c asdu.Connect, a *asdu.ASDU) error {
+	if !isCommand(a.Type) {
+		return asdu.ErrTypeIDNotMatch
+	}
+	if a.Coa.Cause != asdu.Activation && a.Coa.Cause != asdu.Deactivation {
+		return a.SendReplyMirror(c, asdu.UnknownCOT)
+	}
+	if !sf.model.hasStation(a.CommonAddr) {
+		return a.SendReplyMirror(c, asdu.UnknownCA)
+	}
+	cmd := decodeCommand(a.Clone())
+	sf.model.mu.Lock()
+	p, ok := sf.model.command[pointKey{a.CommonAddr, cmd.ioa}]
+	if !ok || (p.typeID != a.Type && p.typeID+asdu.C_SC_TA_1-asdu.C_SC_NA_1 != a.Type) {
+		sf.model.mu.Unlock()
+		return a.SendReplyMirror(c, asdu.UnknownIOA)
+	}
+
+	now := time.Now()
+	var echo []sample
+	cause, negative := asdu.ActivationCon, false
+	action := "execute"
+	switch {
+	case a.Coa.Cause == asdu.Deactivation:
+		action, cause = "deactivate", asdu.DeactivationCon
+		p.selected = time.Time{}
+	case cmd.inSelect:
+		action = "select"
+		p.selected = now
+	case sf.sbo && (p.selected.IsZero() || now.Sub(p.selected) > sf.selectTimeout):
+		action, negative = "execute without select", true
+	default:
+		p.selected = time.Time{}
+		p.value = cmd.value
+		if p.link != nil {
+			p.link.value = linkValue(p.typeID, p.link.typeID, p.link.value, cmd.value)
+			echo = append(echo, sample{p.link, p.link.value})
+		}
+	}
+	sf.model.mu.Unlock()
+
+	sf.log.Printf("command %s ca %d, ioa %d, value %v: %s, negative %v", typeName(a.Type), a.CommonAddr, cmd.ioa, cmd.value, action, negative)
+	r := a.Clone()
+	r.Coa.Cause, r.Coa.IsNegative = cause, negative
+	if err := c.Send(r); err != nil {
+		return err
+	}
+	if action != "execute" {
+		return nil
+	}
+	sf.spontaneous(echo, asdu.ReturnInfoRemote, now)
+	r = a.Clone()
+	r.Coa.Cause = asdu.ActivationTerm
+	return c.Send(r)
+}
+
+// command the decoded command
+type command struct {
+	ioa      asdu.InfoObjAddr
+	value    float64
+	inSelect bool
+}
+
+// decodeCommand decode the command, the type must be a command type
+func decodeCommand(a *asdu.ASDU) command {
+	switch a.Type {
+	case asdu.C_SC_NA_1, asdu.C_SC_TA_1:
+		cmd := a.GetSingleCmd()
+		v := 0.0
+		if cmd.Value {
+			v = 1
+		}
+		return command{cmd.Ioa, v, cmd.Qoc.InSelect}
+	case asdu.C_DC_NA_1, asdu.C_DC_TA_1:
+		cmd := a.GetDoubleCmd()
+		return command{cmd.Ioa, float64(cmd.Value), cmd.Qoc.InSelect}
+	case asdu.C_RC_NA_1, asdu.C_RC_TA_1:
+		cmd := a.GetStepCmd()
+		return command{cmd.Ioa, float64(cmd.Value), cmd.Qoc.InSelect}
+	case asdu.C_SE_NA_1, asdu.C_SE_TA_1:
+		cmd := a.GetSetpointNormalCmd()
+		return command{cmd.Ioa, cmd.Value.Float64(), cmd.Qos.InSelect}
+	case asdu.C_SE_NB_1, asdu.C_SE_TB_1:
+		cmd := a.GetSetpointCmdScaled()
+		return command{cmd.Ioa, float64(cmd.Value), cmd.Qos.InSelect}
+	case asdu.C_SE_NC_1, asdu.C_SE_TC_1:
+		cmd := a.GetSetpointFloatCmd()
+		return command{cmd.Ioa, float64(cmd.Value), cmd.Qos.InSelect}
+	default:
+		cmd := a.GetBitsString32Cmd()
+		return command{cmd.Ioa, float64(cmd.Value), false}
+	}
+}
+
+// linkValue the value of the linked point after the command executed.
+// The double command and the double point are both 1 off and 2 on, the single command or point
+// is mapped to them, the step command steps the step position, otherwise the command value is copied.
+func linkValue(cmdType, linkType asdu.TypeID, old, value float64) float64 {
+	double := linkType == asdu.M_DP_NA_1 || linkType == asdu.M_DP_TB_1
+	switch cmdType {
+	case asdu.C_SC_NA_1:
+		if double {
+			return value + 1
+		}
+	case asdu.C_DC_NA_1:
+		if !double {
+			return value - 1
+		}
+	case asdu.C_RC_NA_1:
+		switch asdu.StepCommand(value) {
+		case asdu.SCOStepDown:
+			return old - 1
+		case asdu.SCOStepUP:
+			return old + 1
+		}
+		return old
+	}
+	return value
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+// predefined roles of IEC 62351-8
+const (
+	RoleViewer    = "VIEWER"
+	RoleOperator  = "OPERATOR"
+	RoleEngineer  = "ENGINEER"
+	RoleInstaller = "INSTALLER"
+	RoleSecAdm    = "SECADM"
+	RoleSecAud    = "SECAUD"
+	RoleRBACMnt   = "RBACMNT"
+)
+
+// DefaultControlledTypes the types need authorization by default,
+// process commands, clock synchronization, reset process and parameter commands.
+var DefaultControlledTypes = []asdu.TypeID{
+	asdu.C_SC_NA_1, asdu.C_DC_NA_1, asdu.C_RC_NA_1, asdu.C_SE_NA_1, asdu.C_SE_NB_1, asdu.C_SE_NC_1, asdu.C_BO_NA_1,
+	asdu.C_SC_TA_1, asdu.C_DC_TA_1, asdu.C_RC_TA_1, asdu.C_SE_TA_1, asdu.C_SE_TB_1, asdu.C_SE_TC_1, asdu.C_BO_TA_1,
+	asdu.C_CS_NA_1, asdu.C_RP_NA_1,
+	asdu.P_ME_NA_1, asdu.P_ME_NB_1, asdu.P_ME_NC_1, asdu.P_AC_NA_1,
+}
+
+// IOARange the range of information object address, Min and Max included
+type IOARange struct {
+	Min, Max asdu.InfoObjAddr
+}
+
+// Permission the commands a role is allowed to execute, an empty list means no limit
+type Permission struct {
+	TypeIDs     []asdu.TypeID     // 允许的类型标识
+	CommonAddrs []asdu.CommonAddr // 允许的公共地址
+	IOAs        []IOARange        // 允许的信息对象地址范围
+}
+
+// AccessPolicy the role based access control of the commands,
+// the roles of a session come from the ip address and the client certificate,
+// a controlled command is executed only if one of the roles permits it,
+// otherwise the server replies a negative confirmation without calling the handler.
+type AccessPolicy struct {
+	Roles map[string][]Permission // 角色 => 允许的命令
+	// IPRoles ip or cidr => roles
+	IPRoles map[string][]string
+	// CertRoles get the roles from the client certificate,
+	// nil use the Subject.OrganizationalUnit as roles.
+	CertRoles func(*x509.Certificate) []string
+	// Controlled the types need authorization, nil use DefaultControlledTypes
+	Controlled []asdu.TypeID
+}
+
+// ErrPermissionDenied the command is not permitted
+var ErrPermissionDenied = errors.New("permission denied")
+
+type ipRoles struct {
+	net   *net.IPNet
+	roles []string
+}
+
+// accessControl the parsed access policy
+type accessControl struct {
+	roles      map[string][]Permission
+	ipRoles    []ipRoles
+	certRoles  func(*x509.Certificate) []string
+	controlled map[asdu.TypeID]bool
+}
+
+// SetAccessPolicy enable the role based access control,
+// it must be called before Serve, the established sessions are not affected.
+func (sf *Server) SetAccessPolicy(p AccessPolicy) error {
+	ac := &accessControl{
+		roles:      p.Roles,
+		certRoles:  p.CertRoles,
+		controlled: make(map[asdu.TypeID]bool),
+	}
+	for k, v := range p.IPRoles {
+		nets, err := parseIPNets([]string{k})
+		if err != nil {
+			return err
+		}
+		ac.ipRoles = append(ac.ipRoles, ipRoles{nets[0], v})
+	}
+	if ac.certRoles == nil {
+		ac.certRoles = func(cert *x509.Certificate) []string { return cert.Subject.OrganizationalUnit }
+	}
+	controlled := p.Controlled
+	if controlled == nil {
+		controlled = DefaultControlledTypes
+	}
+	for _, v := range controlled {
+		ac.controlled[v] = true
+	}
+	sf.mux.Lock()
+	sf.access = ac
+	sf.mux.Unlock()
+	return nil
+}
+
+// sessionRoles return the roles of the session
+func (sf *accessControl) sessionRoles(s *Session) []string {
+	var roles []string
+	if ip := remoteIP(s.remoteAddr); ip != nil {
+		for _, v := range sf.ipRoles {
+			if v.net.Contains(ip) {
+				roles = append(roles, v.roles...)
+			}
+		}
+	}
+	if cert := s.PeerCertificate(); cert != nil {
+		roles = append(roles, sf.certRoles(cert)...)
+	}
+	return roles
+}
+
+// authorize return nil if one of the roles permits the command
+func (sf *accessControl) authorize(roles []string, a *asdu.ASDU) error {
+	ioas, err := infoObjAddrs(a)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		for _, p := range sf.roles[role] {
+			if p.permit(a, ioas) {
+				return nil
+			}
+		}
+	}
+	return ErrPermissionDenied
+}
+
+func (sf Permission) permit(a *asdu.ASDU, ioas []asdu.InfoObjAddr) bool {
+	if len(sf.TypeIDs) > 0 && !containsType(sf.TypeIDs, a.Type) {
+		return false
+	}
+	if len(sf.CommonAddrs) > 0 && !containsCommonAddr(sf.CommonAddrs, a.CommonAddr) {
+		return false
+	}
+	if len(sf.IOAs) > 0 {
+		for _, ioa := range ioas {
+			if !containsIOA(sf.IOAs, ioa) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsType(list []asdu.TypeID, v asdu.TypeID) bool {
+	for _, t := range list {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCommonAddr(list []asdu.CommonAddr, v asdu.CommonAddr) bool {
+	for _, t := range list {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIOA(list []IOARange, v asdu.InfoObjAddr) bool {
+	for _, r := range list {
+		if v >= r.Min && v <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// infoObjAddrs return the information object addresses of the asdu
+func infoObjAddrs(a *asdu.ASDU) ([]asdu.InfoObjAddr, error) {
+	raw, err := a.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	raw = raw[a.IdentifierSize():]
+	decode := func(b []byte) asdu.InfoObjAddr {
+		var ioa asdu.InfoObjAddr
+		for i := 0; i < a.InfoObjAddrSize; i++ {
+			ioa |= asdu.InfoObjAddr(b[i]) << (8 * i)
+		}
+		return ioa
+	}
+
+	n := int(a.Variable.Number)
+	if n == 0 || len(raw) < a.InfoObjAddrSize {
+		return nil, errors.New("no information object")
+	}
+	if a.Variable.IsSequence {
+		base := decode(raw)
+		ioas := make([]asdu.InfoObjAddr, 0, n)
+		for i := 0; i < n; i++ {
+			ioas = append(ioas, base+asdu.InfoObjAddr(i))
+		}
+		return ioas, nil
+	}
+	size, err := asdu.GetInfoObjSize(a.Type)
+	if err != nil {
+		return nil, err
+	}
+	step := a.InfoObjAddrSize + size
+	if len(raw) != n*step {
+		return nil, fmt.Errorf("invalid information object length %d", len(raw))
+	}
+	ioas := make([]asdu.InfoObjAddr, 0, n)
+	for i := 0; i < n; i++ {
+		ioas = append(ioas, decode(raw[i*step:]))
+	}
+	return ioas, nil
+}
+
+// checkAccess check the controlled command, reply a negative confirmation if denied,
+// return true if the command can be passed to the handler.
+func (sf *SrvSession) checkAccess(a *asdu.ASDU) bool {
+	if sf.access == nil || !sf.access.controlled[a.Type] {
+		sf.auditRecord(AuditReceived, a, "", nil)
+		return true
+	}
+	s := sf.session
+	if err := sf.access.authorize(s.roles, a); err != nil {
+		sf.Warn("access deny %v from session %d(%v) roles %v, %v", a.Identifier, s.id, s.remoteAddr, s.roles, err)
+		sf.auditRecord(AuditReceived, a, "deny", err)
+		r := a.Clone()
+		r.Coa.IsNegative = true
+		if a.Coa.Cause == asdu.Deactivation {
+			r.Coa.Cause = asdu.DeactivationCon
+		} else {
+			r.Coa.Cause = asdu.ActivationCon
+		}
+		if err = sf.Send(r); err != nil {
+			sf.Error("send negative confirmation failed, %v", err)
+		}
+		return false
+	}
+	sf.Info("access permit %v from session %d(%v) roles %v", a.Identifier, s.id, s.remoteAddr, s.roles)
+	sf.auditRecord(AuditReceived, a, "permit", nil)
+	return true
+}
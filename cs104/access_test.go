@@ -0,0 +1,168 @@
+package cs104
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+type recordServerHandler struct {
+	nopServerHandler
+	received chan *asdu.ASDU
+}
+
+func (sf recordServerHandler) ASDUHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	sf.received <- a
+	return nil
+}
+
+func singleCmd(t *testing.T, ca asdu.CommonAddr, ioa asdu.InfoObjAddr) *asdu.ASDU {
+	t.Helper()
+	a := asdu.NewASDU(asdu.ParamsWide, asdu.Identifier{
+		Type:       asdu.C_SC_NA_1,
+		Variable:   asdu.VariableStruct{Number: 1},
+		Coa:        asdu.CauseOfTransmission{Cause: asdu.Activation},
+		CommonAddr: ca,
+	})
+	require.NoError(t, a.AppendInfoObjAddr(ioa))
+	a.AppendBytes(0x81)
+	return a
+}
+
+func Test_infoObjAddrs(t *testing.T) {
+	ioas, err := infoObjAddrs(singleCmd(t, 1, 0x123456))
+	require.NoError(t, err)
+	assert.Equal(t, []asdu.InfoObjAddr{0x123456}, ioas)
+
+	a := asdu.NewASDU(asdu.ParamsWide, asdu.Identifier{
+		Type:       asdu.P_ME_NC_1,
+		Variable:   asdu.VariableStruct{Number: 2},
+		Coa:        asdu.CauseOfTransmission{Cause: asdu.Activation},
+		CommonAddr: 1,
+	})
+	require.NoError(t, a.AppendInfoObjAddr(10))
+	a.AppendBytes(0, 0, 0, 0, 1)
+	require.NoError(t, a.AppendInfoObjAddr(20))
+	a.AppendBytes(0, 0, 0, 0, 1)
+	ioas, err = infoObjAddrs(a)
+	require.NoError(t, err)
+	assert.Equal(t, []asdu.InfoObjAddr{10, 20}, ioas)
+
+	a.Variable = asdu.VariableStruct{Number: 3, IsSequence: true}
+	ioas, err = infoObjAddrs(a)
+	require.NoError(t, err)
+	assert.Equal(t, []asdu.InfoObjAddr{10, 11, 12}, ioas)
+
+	a.Variable = asdu.VariableStruct{Number: 3}
+	_, err = infoObjAddrs(a)
+	assert.Error(t, err)
+}
+
+func Test_accessControl(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	assert.Error(t, srv.SetAccessPolicy(AccessPolicy{IPRoles: map[string][]string{"invalid": {RoleOperator}}}))
+	require.NoError(t, srv.SetAccessPolicy(AccessPolicy{
+		Roles: map[string][]Permission{
+			RoleOperator: {{TypeIDs: []asdu.TypeID{asdu.C_SC_NA_1}, CommonAddrs: []asdu.CommonAddr{1}, IOAs: []IOARange{{100, 199}}}},
+			RoleEngineer: {{}},
+		},
+		IPRoles: map[string][]string{"10.0.0.0/8": {RoleOperator}},
+	}))
+	ac := srv.access
+	assert.True(t, ac.controlled[asdu.C_SC_NA_1])
+	assert.False(t, ac.controlled[asdu.C_IC_NA_1])
+
+	s := &Session{remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.1.1.1"), Port: 2404}}
+	assert.Equal(t, []string{RoleOperator}, ac.sessionRoles(s))
+	s = &Session{remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 2404}}
+	assert.Empty(t, ac.sessionRoles(s))
+
+	cert := &x509.Certificate{Subject: pkix.Name{OrganizationalUnit: []string{RoleEngineer}}}
+	s.tlsState = newTLSState(cert)
+	assert.Equal(t, []string{RoleEngineer}, ac.sessionRoles(s))
+
+	roles := []string{RoleOperator}
+	assert.NoError(t, ac.authorize(roles, singleCmd(t, 1, 100)))
+	assert.ErrorIs(t, ac.authorize(roles, singleCmd(t, 1, 200)), ErrPermissionDenied)
+	assert.ErrorIs(t, ac.authorize(roles, singleCmd(t, 2, 100)), ErrPermissionDenied)
+	assert.ErrorIs(t, ac.authorize(nil, singleCmd(t, 1, 100)), ErrPermissionDenied)
+	assert.NoError(t, ac.authorize([]string{RoleViewer, RoleEngineer}, singleCmd(t, 2, 200)))
+}
+
+func TestServer_AccessPolicy(t *testing.T) {
+	handler := recordServerHandler{received: make(chan *asdu.ASDU, 4)}
+	logger := &memLogger{}
+	srv := NewServer(handler)
+	srv.SetLogProvider(logger)
+	srv.LogMode(true)
+	require.NoError(t, srv.SetAccessPolicy(AccessPolicy{
+		Roles:   map[string][]Permission{RoleOperator: {{IOAs: []IOARange{{100, 199}}}}},
+		IPRoles: map[string][]string{"127.0.0.1": {RoleOperator}},
+	}))
+	sessions := make(chan *Session, 1)
+	srv.SetOnConnectionHandler(func(c asdu.Connect) { sessions <- SessionOf(c) })
+	addr := startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	assert.Equal(t, []string{RoleOperator}, (<-sessions).Roles())
+	_, err = conn.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+
+	send := func(seq uint16, a *asdu.ASDU) {
+		raw, err := a.MarshalBinary()
+		require.NoError(t, err)
+		frame, err := NewIFrame(seq, 0, raw)
+		require.NoError(t, err)
+		_, err = conn.Write(frame)
+		require.NoError(t, err)
+	}
+
+	// permitted, passed to the handler
+	send(0, singleCmd(t, 1, 100))
+	select {
+	case a := <-handler.received:
+		assert.Equal(t, asdu.C_SC_NA_1, a.Type)
+	case <-time.After(time.Second):
+		t.Fatal("wait command timeout")
+	}
+
+	// denied, negative confirmation and the handler is not called
+	send(1, singleCmd(t, 1, 300))
+	_, raw := ParseAPDU(readAPDU(t, conn, r))
+	a := asdu.NewEmptyASDU(asdu.ParamsWide)
+	require.NoError(t, a.UnmarshalBinary(raw))
+	assert.Equal(t, asdu.C_SC_NA_1, a.Type)
+	assert.Equal(t, asdu.CauseOfTransmission{Cause: asdu.ActivationCon, IsNegative: true}, a.Coa)
+	assert.Equal(t, asdu.InfoObjAddr(300), a.DecodeInfoObjAddr())
+	select {
+	case <-handler.received:
+		t.Fatal("denied command passed to handler")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// both the decisions are logged, the logger without Info gets the permit as a warning
+	var decisions []string
+	for _, v := range logger.get() {
+		if strings.HasPrefix(v, "access ") {
+			decisions = append(decisions, strings.Fields(v)[1])
+		}
+	}
+	assert.Equal(t, []string{"permit", "deny"}, decisions)
+}
+
+func newTLSState(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+}
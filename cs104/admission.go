@@ -0,0 +1,139 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// AdmissionPolicy the admission control of the connections,
+// the connection rejected is closed before the session starts.
+// the ip lists and MaxSessionsPerIP only apply to the connections with ip address,
+// the others such as unix socket or net.Pipe are only limited by MaxSessions.
+type AdmissionPolicy struct {
+	MaxSessions      int      // 最大会话数, 0不限制
+	MaxSessionsPerIP int      // 每个IP的最大会话数, 0不限制
+	Allow            []string // 允许的IP或CIDR,如 192.168.1.10, 10.0.0.0/8, 为空允许所有
+	Deny             []string // 拒绝的IP或CIDR, 优先于Allow
+}
+
+// admission the parsed admission policy
+type admission struct {
+	AdmissionPolicy
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// parseIPNets parse the ip or cidr list, a single ip is treated as the host network
+func parseIPNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, v := range list {
+		if strings.Contains(v, "/") {
+			_, n, err := net.ParseCIDR(v)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, n)
+			continue
+		}
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip %q", v)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+	}
+	return nets, nil
+}
+
+// containsIP return true if any of the nets contains ip
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, v := range nets {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP return the ip of the address, nil if it has no ip
+func remoteIP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// SetAdmissionPolicy set the admission control of the new connections, the established sessions are not affected.
+func (sf *Server) SetAdmissionPolicy(p AdmissionPolicy) error {
+	allow, err := parseIPNets(p.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseIPNets(p.Deny)
+	if err != nil {
+		return err
+	}
+	sf.mux.Lock()
+	sf.admission = &admission{p, allow, deny}
+	sf.mux.Unlock()
+	return nil
+}
+
+// SetAdmissionHandler set the callback called before the session starts,
+// after the admission policy passed and the tls handshake completed,
+// return error reject the connection.
+func (sf *Server) SetAdmissionHandler(f func(*Session) error) *Server {
+	sf.onAdmission = f
+	return sf
+}
+
+// admit check the admission policy and reserve a place of the connection,
+// the release must be called when the connection closed.
+func (sf *Server) admit(addr net.Addr) (release func(), err error) {
+	ip := remoteIP(addr)
+	key := ""
+	if ip != nil {
+		key = ip.String()
+	}
+
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	if a := sf.admission; a != nil {
+		if ip != nil {
+			if containsIP(a.deny, ip) || (len(a.allow) > 0 && !containsIP(a.allow, ip)) {
+				return nil, ErrNotAllowed
+			}
+			if a.MaxSessionsPerIP > 0 && sf.connsPerIP[key] >= a.MaxSessionsPerIP {
+				return nil, ErrTooManySessions
+			}
+		}
+		if a.MaxSessions > 0 && sf.connCount >= a.MaxSessions {
+			return nil, ErrTooManySessions
+		}
+	}
+	sf.connCount++
+	if ip != nil {
+		sf.connsPerIP[key]++
+	}
+	return func() {
+		sf.mux.Lock()
+		sf.connCount--
+		if ip != nil {
+			if sf.connsPerIP[key]--; sf.connsPerIP[key] <= 0 {
+				delete(sf.connsPerIP, key)
+			}
+		}
+		sf.mux.Unlock()
+	}, nil
+}
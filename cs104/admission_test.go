@@ -0,0 +1,109 @@
+package cs104
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseIPNets(t *testing.T) {
+	nets, err := parseIPNets([]string{"192.168.1.10", "10.0.0.0/8", "fe80::1"})
+	require.NoError(t, err)
+	assert.True(t, containsIP(nets, net.ParseIP("192.168.1.10")))
+	assert.False(t, containsIP(nets, net.ParseIP("192.168.1.11")))
+	assert.True(t, containsIP(nets, net.ParseIP("10.1.2.3")))
+	assert.True(t, containsIP(nets, net.ParseIP("fe80::1")))
+	assert.False(t, containsIP(nets, net.ParseIP("fe80::2")))
+
+	_, err = parseIPNets([]string{"localhost"})
+	assert.Error(t, err)
+	_, err = parseIPNets([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
+
+func TestServer_admit(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	require.NoError(t, srv.SetAdmissionPolicy(AdmissionPolicy{
+		MaxSessions:      3,
+		MaxSessionsPerIP: 2,
+		Allow:            []string{"10.0.0.0/8"},
+		Deny:             []string{"10.0.0.66"},
+	}))
+	addr := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 2404} }
+
+	_, err := srv.admit(addr("192.168.1.1"))
+	assert.ErrorIs(t, err, ErrNotAllowed)
+	_, err = srv.admit(addr("10.0.0.66"))
+	assert.ErrorIs(t, err, ErrNotAllowed)
+
+	release1, err := srv.admit(addr("10.0.0.1"))
+	require.NoError(t, err)
+	release2, err := srv.admit(addr("10.0.0.1"))
+	require.NoError(t, err)
+	_, err = srv.admit(addr("10.0.0.1"))
+	assert.ErrorIs(t, err, ErrTooManySessions)
+	release3, err := srv.admit(addr("10.0.0.2"))
+	require.NoError(t, err)
+	_, err = srv.admit(addr("10.0.0.3"))
+	assert.ErrorIs(t, err, ErrTooManySessions)
+
+	release1()
+	release4, err := srv.admit(addr("10.0.0.1"))
+	require.NoError(t, err)
+	release2()
+	release3()
+	release4()
+	assert.Zero(t, srv.connCount)
+	assert.Empty(t, srv.connsPerIP)
+
+	// 无IP地址的连接只受总数限制
+	release, err := srv.admit(nil)
+	require.NoError(t, err)
+	release()
+}
+
+func TestServer_Admission(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	require.NoError(t, srv.SetAdmissionPolicy(AdmissionPolicy{MaxSessionsPerIP: 1, Allow: []string{"127.0.0.0/8"}}))
+	assert.Error(t, srv.SetAdmissionPolicy(AdmissionPolicy{Deny: []string{"invalid"}}))
+	var rejectNext atomic.Bool
+	srv.SetAdmissionHandler(func(s *Session) error {
+		if rejectNext.Load() {
+			return errors.New("rejected by user")
+		}
+		return nil
+	})
+	addr := startTestServer(t, srv)
+
+	isClosed := func(conn net.Conn) bool {
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err := conn.Read(make([]byte, 1))
+		var ne net.Error
+		return err != nil && !(errors.As(err, &ne) && ne.Timeout())
+	}
+
+	conn1, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	assert.False(t, isClosed(conn1))
+	require.Len(t, srv.Sessions(), 1)
+
+	conn2, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn2.Close()
+	assert.True(t, isClosed(conn2))
+
+	require.NoError(t, conn1.Close())
+	require.Eventually(t, func() bool { return len(srv.Sessions()) == 0 }, time.Second, 10*time.Millisecond)
+
+	rejectNext.Store(true)
+	conn3, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn3.Close()
+	assert.True(t, isClosed(conn3))
+	assert.Empty(t, srv.Sessions())
+}
@@ -28,60 +28,63 @@ const (
 
 // U帧 控制域功能
 const (
-	uStartDtActive  byte = 4 << iota // 启动激活 0x04
-	uStartDtConfirm                  // 启动确认 0x08
-	uStopDtActive                    // 停止激活 0x10
-	uStopDtConfirm                   // 停止确认 0x20
-	uTestFrActive                    // 测试激活 0x40
-	uTestFrConfirm                   // 测试确认 0x80
+	UStartDtActive  byte = 4 << iota // 启动激活 0x04
+	UStartDtConfirm                  // 启动确认 0x08
+	UStopDtActive                    // 停止激活 0x10
+	UStopDtConfirm                   // 停止确认 0x20
+	UTestFrActive                    // 测试激活 0x40
+	UTestFrConfirm                   // 测试确认 0x80
 )
 
 // I帧 含apci和asdu 信息帧.用于编号的信息传输 information
-type iAPCI struct {
-	sendSN, rcvSN uint16
+type IAPCI struct {
+	SendSN, RcvSN uint16 // N(S), N(R)
 }
 
-func (sf iAPCI) String() string {
-	return fmt.Sprintf("I[sendNO: %d, recvNO: %d]", sf.sendSN, sf.rcvSN)
+func (sf IAPCI) String() string {
+	return fmt.Sprintf("I[sendNO: %d, recvNO: %d]", sf.SendSN, sf.RcvSN)
 }
 
 // S帧 只含apci S帧用于主要用确认帧的正确传输,协议称是监视. supervisory
-type sAPCI struct {
-	rcvSN uint16
+type SAPCI struct {
+	RcvSN uint16 // N(R)
 }
 
-func (sf sAPCI) String() string {
-	return fmt.Sprintf("S[recvNO: %d]", sf.rcvSN)
+func (sf SAPCI) String() string {
+	return fmt.Sprintf("S[recvNO: %d]", sf.RcvSN)
 }
 
 // U帧 只含apci 未编号控制信息 unnumbered
-type uAPCI struct {
-	function byte // bit8 测试确认
+type UAPCI struct {
+	Function byte // UStartDtActive ... UTestFrConfirm
 }
 
-func (sf uAPCI) String() string {
-	var s string
-	switch sf.function {
-	case uStartDtActive:
-		s = "StartDtActive"
-	case uStartDtConfirm:
-		s = "StartDtConfirm"
-	case uStopDtActive:
-		s = "StopDtActive"
-	case uStopDtConfirm:
-		s = "StopDtConfirm"
-	case uTestFrActive:
-		s = "TestFrActive"
-	case uTestFrConfirm:
-		s = "TestFrConfirm"
+func (sf UAPCI) String() string {
+	return fmt.Sprintf("U[function: %s]", uFunctionName(sf.Function))
+}
+
+// uFunctionName the name of the U帧 function
+func uFunctionName(function byte) string {
+	switch function {
+	case UStartDtActive:
+		return "StartDtActive"
+	case UStartDtConfirm:
+		return "StartDtConfirm"
+	case UStopDtActive:
+		return "StopDtActive"
+	case UStopDtConfirm:
+		return "StopDtConfirm"
+	case UTestFrActive:
+		return "TestFrActive"
+	case UTestFrConfirm:
+		return "TestFrConfirm"
 	default:
-		s = "Unknown"
+		return "Unknown"
 	}
-	return fmt.Sprintf("U[function: %s]", s)
 }
 
-// newIFrame 创建I帧 ,返回apdu
-func newIFrame(sendSN, RcvSN uint16, asdus []byte) ([]byte, error) {
+// NewIFrame 创建I帧 ,返回apdu
+func NewIFrame(sendSN, RcvSN uint16, asdus []byte) ([]byte, error) {
 	if len(asdus) > asdu.ASDUSizeMax {
 		return nil, fmt.Errorf("ASDU filed large than max %d", asdu.ASDUSizeMax)
 	}
@@ -99,13 +102,13 @@ func newIFrame(sendSN, RcvSN uint16, asdus []byte) ([]byte, error) {
 	return b, nil
 }
 
-// newSFrame 创建S帧,返回apdu
-func newSFrame(RcvSN uint16) []byte {
+// NewSFrame 创建S帧,返回apdu
+func NewSFrame(RcvSN uint16) []byte {
 	return []byte{startFrame, 4, 0x01, 0x00, byte(RcvSN << 1), byte(RcvSN >> 7)}
 }
 
-// newUFrame 创建U帧,返回apdu
-func newUFrame(which byte) []byte {
+// NewUFrame 创建U帧,返回apdu
+func NewUFrame(which byte) []byte {
 	return []byte{startFrame, 4, which | 0x03, 0x00, 0x00, 0x00}
 }
 
@@ -116,8 +119,12 @@ type APCI struct {
 	ctr1, ctr2, ctr3, ctr4 byte
 }
 
-// return frame type , APCI, remain data
-func parse(apdu []byte) (interface{}, []byte) {
+// ParseAPDU parse a complete APDU, such as read by APDUReader,
+// return the APCI (IAPCI, SAPCI or UAPCI) and the asdu data, nil if apdu less than 6 bytes.
+func ParseAPDU(apdu []byte) (interface{}, []byte) {
+	if len(apdu) < APCICtlFiledSize+2 {
+		return nil, nil
+	}
 	apci := APCI{
 		start:        apdu[0],
 		apduFiledLen: apdu[1],
@@ -127,18 +134,18 @@ func parse(apdu []byte) (interface{}, []byte) {
 		ctr4:         apdu[5],
 	}
 	if apci.ctr1&0x01 == 0 {
-		return iAPCI{
-			sendSN: uint16(apci.ctr1)>>1 + uint16(apci.ctr2)<<7,
-			rcvSN:  uint16(apci.ctr3)>>1 + uint16(apci.ctr4)<<7,
+		return IAPCI{
+			SendSN: uint16(apci.ctr1)>>1 + uint16(apci.ctr2)<<7,
+			RcvSN:  uint16(apci.ctr3)>>1 + uint16(apci.ctr4)<<7,
 		}, apdu[6:]
 	}
 	if apci.ctr1&0x03 == 0x01 {
-		return sAPCI{
-			rcvSN: uint16(apci.ctr3)>>1 + uint16(apci.ctr4)<<7,
+		return SAPCI{
+			RcvSN: uint16(apci.ctr3)>>1 + uint16(apci.ctr4)<<7,
 		}, apdu[6:]
 	}
 	// apci.ctrl&0x03 == 0x03
-	return uAPCI{
-		function: apci.ctr1 & 0xfc,
+	return UAPCI{
+		Function: apci.ctr1 & 0xfc,
 	}, apdu[6:]
 }
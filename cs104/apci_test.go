@@ -1,17 +1,22 @@
 package cs104
 
 import (
+	"bytes"
+	"io"
 	"reflect"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIAPCI_String(t *testing.T) {
 	tests := []struct {
 		name string
-		this iAPCI
+		this IAPCI
 		want string
 	}{
-		{"iFrame", iAPCI{sendSN: 0x02, rcvSN: 0x02}, "I[sendNO: 2, recvNO: 2]"},
+		{"iFrame", IAPCI{SendSN: 0x02, RcvSN: 0x02}, "I[sendNO: 2, recvNO: 2]"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -24,10 +29,10 @@ func TestIAPCI_String(t *testing.T) {
 func TestSAPCI_String(t *testing.T) {
 	tests := []struct {
 		name string
-		this sAPCI
+		this SAPCI
 		want string
 	}{
-		{"sFrame", sAPCI{rcvSN: 123}, "S[recvNO: 123]"},
+		{"sFrame", SAPCI{RcvSN: 123}, "S[recvNO: 123]"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -40,10 +45,10 @@ func TestSAPCI_String(t *testing.T) {
 func TestUAPCI_String(t *testing.T) {
 	tests := []struct {
 		name string
-		this uAPCI
+		this UAPCI
 		want string
 	}{
-		{"uFrame", uAPCI{function: uStartDtActive}, "U[function: StartDtActive]"},
+		{"uFrame", UAPCI{Function: UStartDtActive}, "U[function: StartDtActive]"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -81,13 +86,13 @@ func Test_newIFrame(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := newIFrame(tt.args.sendSN, tt.args.RcvSN, tt.args.asdu)
+			got, err := NewIFrame(tt.args.sendSN, tt.args.RcvSN, tt.args.asdu)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("newIFrame() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("NewIFrame() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("newIFrame() = % x, want % x", got, tt.want)
+				t.Errorf("NewIFrame() = % x, want % x", got, tt.want)
 			}
 		})
 	}
@@ -106,8 +111,8 @@ func Test_newSFrame(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := newSFrame(tt.args.RcvSN); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("newSFrame() = % x, want % x", got, tt.want)
+			if got := NewSFrame(tt.args.RcvSN); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewSFrame() = % x, want % x", got, tt.want)
 			}
 		})
 	}
@@ -122,12 +127,12 @@ func Test_newUFrame(t *testing.T) {
 		args args
 		want []byte
 	}{
-		{"", args{uStopDtActive}, []byte{startFrame, 0x04, 0x13, 0x00, 0x00, 0x00}},
+		{"", args{UStopDtActive}, []byte{startFrame, 0x04, 0x13, 0x00, 0x00, 0x00}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := newUFrame(tt.args.which); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("newUFrame() = % x, want % x", got, tt.want)
+			if got := NewUFrame(tt.args.which); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewUFrame() = % x, want % x", got, tt.want)
 			}
 		})
 	}
@@ -144,33 +149,81 @@ func Test_parse(t *testing.T) {
 		want1 []byte
 	}{
 		{
-			"iAPCI",
+			"IAPCI",
 			args{[]byte{startFrame, 0x04, 0x02, 0x00, 0x03, 0x00}},
-			iAPCI{sendSN: 0x01, rcvSN: 0x01},
+			IAPCI{SendSN: 0x01, RcvSN: 0x01},
 			[]byte{},
 		},
 		{
-			"sAPCI",
+			"SAPCI",
 			args{[]byte{startFrame, 0x04, 0x01, 0x00, 0x02, 0x00}},
-			sAPCI{rcvSN: 0x01},
+			SAPCI{RcvSN: 0x01},
 			[]byte{},
 		},
 		{
-			"uAPCI",
+			"UAPCI",
 			args{[]byte{startFrame, 0x04, 0x07, 0x00, 0x00, 0x00}},
-			uAPCI{uStartDtActive},
+			UAPCI{UStartDtActive},
 			[]byte{},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1 := parse(tt.args.apdu)
+			got, got1 := ParseAPDU(tt.args.apdu)
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("parse() got = %v, want %v", got, tt.want)
+				t.Errorf("ParseAPDU() got = %v, want %v", got, tt.want)
 			}
 			if !reflect.DeepEqual(got1, tt.want1) {
-				t.Errorf("parse() got1 = %v, want %v", got1, tt.want1)
+				t.Errorf("ParseAPDU() got1 = %v, want %v", got1, tt.want1)
 			}
 		})
 	}
 }
+
+func TestParseAPDU_short(t *testing.T) {
+	head, raw := ParseAPDU([]byte{startFrame, 0x04, 0x07})
+	assert.Nil(t, head)
+	assert.Nil(t, raw)
+}
+
+func TestAPDUReader(t *testing.T) {
+	iframe, err := NewIFrame(1, 2, []byte{0x64, 0x01, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x14})
+	require.NoError(t, err)
+	stream := append(append(NewUFrame(UStartDtActive), iframe...), NewSFrame(3)...)
+
+	r := NewAPDUReader(bytes.NewReader(stream))
+	for _, want := range [][]byte{NewUFrame(UStartDtActive), iframe, NewSFrame(3)} {
+		got, err := r.ReadAPDU()
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err = r.ReadAPDU()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = NewAPDUReader(bytes.NewReader(iframe[:8])).ReadAPDU()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = NewAPDUReader(bytes.NewReader(iframe[:1])).ReadAPDU()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = NewAPDUReader(bytes.NewReader([]byte{0x67, 0x04, 0x07, 0x00, 0x00, 0x00})).ReadAPDU()
+	assert.ErrorIs(t, err, ErrInvalidAPDU)
+	_, err = NewAPDUReader(bytes.NewReader([]byte{startFrame, 0x02, 0x07, 0x00})).ReadAPDU()
+	assert.ErrorIs(t, err, ErrInvalidAPDU)
+	_, err = NewAPDUReader(bytes.NewReader([]byte{startFrame, 0xfe})).ReadAPDU()
+	assert.ErrorIs(t, err, ErrFrameTooLong)
+}
+
+func TestAPDUWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewAPDUWriter(&buf)
+	require.NoError(t, w.WriteAPDU(NewSFrame(1)))
+	require.NoError(t, w.WriteAPDU(NewUFrame(UTestFrConfirm)))
+	assert.Equal(t, append(NewSFrame(1), NewUFrame(UTestFrConfirm)...), buf.Bytes())
+
+	assert.ErrorIs(t, w.WriteAPDU([]byte{startFrame, 0x05, 0x01, 0x00, 0x00, 0x00}), ErrInvalidAPDU)
+	assert.ErrorIs(t, w.WriteAPDU([]byte{startFrame}), ErrInvalidAPDU)
+	assert.Equal(t, 12, buf.Len())
+
+	head, raw := ParseAPDU(buf.Bytes()[:6])
+	assert.Equal(t, SAPCI{RcvSN: 1}, head)
+	assert.Empty(t, raw)
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// APDUReader read the complete APDUs from a stream
+type APDUReader struct {
+	r *bufio.Reader
+}
+
+// NewAPDUReader new an APDUReader, the stream is buffered
+func NewAPDUReader(r io.Reader) *APDUReader {
+	return &APDUReader{bufio.NewReaderSize(r, 4096)}
+}
+
+// ReadAPDU read a complete APDU, the returned slice is newly allocated.
+// It returns io.EOF if the stream ends before an APDU, io.ErrUnexpectedEOF in the middle of one,
+// ErrInvalidAPDU if the start byte or the length is invalid, ErrFrameTooLong if the length exceeds 253.
+func (sf *APDUReader) ReadAPDU() ([]byte, error) {
+	head, err := sf.r.Peek(2)
+	if err != nil {
+		if err == io.EOF && len(head) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err = checkAPDUHead(head[0], head[1]); err != nil {
+		return nil, err
+	}
+	apdu := make([]byte, 2+int(head[1]))
+	if _, err = io.ReadFull(sf.r, apdu); err != nil {
+		return nil, err
+	}
+	return apdu, nil
+}
+
+// APDUWriter write the complete APDUs to a stream
+type APDUWriter struct {
+	w io.Writer
+}
+
+// NewAPDUWriter new an APDUWriter
+func NewAPDUWriter(w io.Writer) *APDUWriter {
+	return &APDUWriter{w}
+}
+
+// WriteAPDU validate and write a complete APDU, the temporary errors of net.Conn are retried.
+func (sf *APDUWriter) WriteAPDU(apdu []byte) error {
+	if len(apdu) < 2 {
+		return fmt.Errorf("%w: too short", ErrInvalidAPDU)
+	}
+	if err := checkAPDUHead(apdu[0], apdu[1]); err != nil {
+		return err
+	}
+	if int(apdu[1]) != len(apdu)-2 {
+		return fmt.Errorf("%w: length %d mismatch %d bytes", ErrInvalidAPDU, apdu[1], len(apdu)-2)
+	}
+	for wrCnt := 0; len(apdu) > wrCnt; {
+		byteCount, err := sf.w.Write(apdu[wrCnt:])
+		if err != nil {
+			// See: https://github.com/golang/go/issues/4373
+			if err != io.EOF && err != io.ErrClosedPipe ||
+				strings.Contains(err.Error(), "use of closed network connection") {
+				return err
+			}
+			if e, ok := err.(net.Error); !ok || !e.Temporary() {
+				return err
+			}
+			// temporary error may be recoverable
+		}
+		wrCnt += byteCount
+	}
+	return nil
+}
+
+// checkAPDUHead check the start byte and the length of the APDU
+func checkAPDUHead(start, length byte) error {
+	switch {
+	case start != startFrame:
+		return fmt.Errorf("%w: start byte 0x%02x", ErrInvalidAPDU, start)
+	case int(length) > APDUSizeMax-2:
+		return fmt.Errorf("%w, length %d", ErrFrameTooLong, length)
+	case length < APCICtlFiledSize:
+		return fmt.Errorf("%w: length %d", ErrInvalidAPDU, length)
+	}
+	return nil
+}
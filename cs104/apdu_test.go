@@ -14,25 +14,25 @@ var (
 
 func TestSFrame(t *testing.T) {
 	// start
-	req := newSFrame(0x01) // server->client
+	req := NewSFrame(0x01) // server->client
 	assert.Equal(t, []byte{0x68, 0x04, 0x01, 0x0, 0x02, 0x0}, req)
 }
 
 func TestUFrame(t *testing.T) {
 	// start
-	startReq := newUFrame(uStartDtActive) // client->server
+	startReq := NewUFrame(UStartDtActive) // client->server
 	assert.Equal(t, []byte{0x68, 0x04, 0x07, 0x0, 0x0, 0x0}, startReq)
-	startResp := newUFrame(uStartDtConfirm) // server->client
+	startResp := NewUFrame(UStartDtConfirm) // server->client
 	assert.Equal(t, []byte{0x68, 0x04, 0x0B, 0x0, 0x0, 0x0}, startResp)
 	// stop
-	stopReq := newUFrame(uStopDtActive) // client->server
+	stopReq := NewUFrame(UStopDtActive) // client->server
 	assert.Equal(t, []byte{0x68, 0x04, 0x13, 0x0, 0x0, 0x0}, stopReq)
-	stopResp := newUFrame(uStopDtConfirm) // server->client
+	stopResp := NewUFrame(UStopDtConfirm) // server->client
 	assert.Equal(t, []byte{0x68, 0x04, 0x23, 0x0, 0x0, 0x0}, stopResp)
 	// test
-	testReq := newUFrame(uTestFrActive) // client->server
+	testReq := NewUFrame(UTestFrActive) // client->server
 	assert.Equal(t, []byte{0x68, 0x04, 0x43, 0x0, 0x0, 0x0}, testReq)
-	testResp := newUFrame(uTestFrConfirm) // server->client
+	testResp := NewUFrame(UTestFrConfirm) // server->client
 	assert.Equal(t, []byte{0x68, 0x04, 0x83, 0x0, 0x0, 0x0}, testResp)
 }
 
@@ -79,7 +79,7 @@ func TestSendInterrogation(t *testing.T) {
 	rStart.AppendBytes(byte(asdu.QOIStation))
 
 	rStartBuf, _ := rStart.MarshalBinary()
-	rStartData, _ := newIFrame(0xDE>>1, 0x06<<7+0x22>>1, rStartBuf)
+	rStartData, _ := NewIFrame(0xDE>>1, 0x06<<7+0x22>>1, rStartBuf)
 	assert.Equal(t, []byte{0x68, 0x0E, 0xDE, 0x00, 0x22, 0x06, 0x64, 0x01, 0x06, 0x00, 0x07, 0x11, 0x00, 0x00, 0x00, 0x14}, rStartData)
 
 	rDone := asdu.NewASDU(globalP, asdu.Identifier{
@@ -94,7 +94,7 @@ func TestSendInterrogation(t *testing.T) {
 	rDone.AppendBytes(byte(asdu.QOIStation))
 
 	rDoneBuf, _ := rDone.MarshalBinary()
-	rDoneData, _ := newIFrame(0x10>>1, 0x0A>>1, rDoneBuf)
+	rDoneData, _ := NewIFrame(0x10>>1, 0x0A>>1, rDoneBuf)
 	assert.Equal(t, []byte{0x68, 0x0E, 0x10, 0x00, 0x0A, 0x00, 0x64, 0x01, 0x07, 0x00, 0x07, 0x11, 0x00, 0x00, 0x00, 0x14}, rDoneData)
 }
 
@@ -126,7 +126,7 @@ func TestCallCount(t *testing.T) {
 	rStart.AppendBytes(byte(asdu.QCCTotal))
 
 	rStartBuf, _ := rStart.MarshalBinary()
-	rStartData, _ := newIFrame(0x0>>1, 0x0>>1, rStartBuf)
+	rStartData, _ := NewIFrame(0x0>>1, 0x0>>1, rStartBuf)
 	assert.Equal(t, []byte{0x68, 0x0E, 0x00, 0x00, 0x00, 0x00, 0x65, 0x01, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x05}, rStartData)
 
 	rDone := asdu.NewASDU(globalP, asdu.Identifier{
@@ -141,7 +141,7 @@ func TestCallCount(t *testing.T) {
 	rDone.AppendBytes(byte(asdu.QCCTotal))
 
 	rDoneBuf, _ := rDone.MarshalBinary()
-	rDoneData, _ := newIFrame(0x0>>1, 0x02>>1, rDoneBuf)
+	rDoneData, _ := NewIFrame(0x0>>1, 0x02>>1, rDoneBuf)
 	assert.Equal(t, []byte{0x68, 0x0E, 0x00, 0x00, 0x02, 0x00, 0x65, 0x01, 0x07, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x05}, rDoneData)
 
 	// 68-1A
@@ -173,7 +173,7 @@ func TestCallCount(t *testing.T) {
 	rResp.AppendBinaryCounterReading(brcs[1].Value)
 
 	rRespBuf, _ := rResp.MarshalBinary()
-	rRespData, _ := newIFrame(0x02>>1, 0x02>>1, rRespBuf)
+	rRespData, _ := NewIFrame(0x02>>1, 0x02>>1, rRespBuf)
 	assert.Equal(t, targetData, rRespData)
 
 	rEnd := asdu.NewASDU(globalP, asdu.Identifier{
@@ -188,7 +188,7 @@ func TestCallCount(t *testing.T) {
 	rEnd.AppendBytes(byte(asdu.QCCTotal))
 
 	rEndBuf, _ := rEnd.MarshalBinary()
-	rEndData, _ := newIFrame(0x4>>1, 0x02>>1, rEndBuf)
+	rEndData, _ := NewIFrame(0x4>>1, 0x02>>1, rEndBuf)
 	assert.Equal(t, []byte{0x68, 0x0E, 0x04, 0x00, 0x02, 0x00, 0x65, 0x01, 0x0A, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x05}, rEndData)
 }
 
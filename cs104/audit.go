@@ -0,0 +1,281 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+// audit direction
+const (
+	AuditReceived = "rx"
+	AuditSent     = "tx"
+)
+
+// AuditRecord the audit record of a command asdu,
+// each step of the cause of transmission progression (Act -> ActCon/negative -> ActTerm)
+// is recorded separately, and the confirmations carry the time of the activation.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Side      string    `json:"side"`      // "server" 被控站, "client" 控制站
+	Direction string    `json:"direction"` // AuditReceived or AuditSent
+	SessionID uint64    `json:"session_id,omitempty"`
+	Local     string    `json:"local,omitempty"`
+	Remote    string    `json:"remote,omitempty"`
+	Peer      string    `json:"peer,omitempty"` // subject of the peer certificate
+	Roles     []string  `json:"roles,omitempty"`
+
+	Type       asdu.TypeID      `json:"type"`
+	TypeName   string           `json:"type_name"`
+	Cause      string           `json:"cause"`
+	Negative   bool             `json:"negative,omitempty"`
+	Test       bool             `json:"test,omitempty"`
+	OrigAddr   asdu.OriginAddr  `json:"orig_addr,omitempty"`
+	CommonAddr asdu.CommonAddr  `json:"common_addr"`
+	IOA        asdu.InfoObjAddr `json:"ioa"`
+	Value      interface{}      `json:"value,omitempty"`
+	Qualifier  uint             `json:"qualifier,omitempty"`
+	Select     bool             `json:"select,omitempty"` // true: select, false: execute
+	CmdTime    *time.Time       `json:"cmd_time,omitempty"`
+	ActTime    *time.Time       `json:"act_time,omitempty"` // 对应的激活/停止激活的时间
+
+	Decision string `json:"decision,omitempty"` // access control decision of the server, "permit" or "deny"
+	Error    string `json:"error,omitempty"`
+}
+
+// AuditSink receive the audit records, it is called in the session goroutines,
+// so it should not block for long.
+type AuditSink interface {
+	Audit(rec AuditRecord) error
+}
+
+// AuditSinkFunc adapter a function as AuditSink
+type AuditSinkFunc func(rec AuditRecord) error
+
+// Audit imp AuditSink
+func (sf AuditSinkFunc) Audit(rec AuditRecord) error { return sf(rec) }
+
+// isAuditType return true if the type is a command need audit
+func isAuditType(t asdu.TypeID) bool {
+	return containsType(DefaultControlledTypes, t)
+}
+
+// maxPendingActs the limit of the activations waiting for the termination
+const maxPendingActs = 1024
+
+type auditKey struct {
+	typ  asdu.TypeID
+	ca   asdu.CommonAddr
+	ioa  asdu.InfoObjAddr
+	deac bool
+}
+
+// pendingAct the activation waiting for the termination
+type pendingAct struct {
+	time time.Time
+	seq  uint64 // 激活的先后顺序
+}
+
+// auditor record the command asdus of a link to the sink
+type auditor struct {
+	sink    AuditSink
+	mu      sync.Mutex
+	acts    map[auditKey]pendingAct // 等待确认的激活
+	nextSeq uint64
+}
+
+func newAuditor(sink AuditSink) *auditor {
+	if sink == nil {
+		return nil
+	}
+	return &auditor{sink: sink, acts: make(map[auditKey]pendingAct)}
+}
+
+// evictOldest remove the oldest activation, must hold the lock
+func (sf *auditor) evictOldest() {
+	var oldest auditKey
+	var seq uint64
+	first := true
+	for k, v := range sf.acts {
+		if first || v.seq < seq {
+			oldest, seq, first = k, v.seq, false
+		}
+	}
+	delete(sf.acts, oldest)
+}
+
+// record build the audit record of a based on rec and send it to the sink
+func (sf *auditor) record(rec AuditRecord, a *asdu.ASDU) error {
+	if sf == nil || !isAuditType(a.Type) {
+		return nil
+	}
+	rec.Time = time.Now()
+	rec.Type = a.Type
+	rec.TypeName = a.Type.String()
+	rec.Cause = a.Coa.Cause.String()
+	rec.Negative = a.Coa.IsNegative
+	rec.Test = a.Coa.IsTest
+	rec.OrigAddr = a.OrigAddr
+	rec.CommonAddr = a.CommonAddr
+	if err := decodeAuditValue(&rec, a.Clone()); err != nil && rec.Error == "" {
+		rec.Error = err.Error()
+	}
+
+	key := auditKey{a.Type, a.CommonAddr, rec.IOA, false}
+	sf.mu.Lock()
+	switch a.Coa.Cause {
+	case asdu.Activation, asdu.Deactivation:
+		key.deac = a.Coa.Cause == asdu.Deactivation
+		if _, ok := sf.acts[key]; !ok && len(sf.acts) >= maxPendingActs {
+			// 从未终止的激活过多时,丢弃最早的
+			sf.evictOldest()
+		}
+		sf.nextSeq++
+		sf.acts[key] = pendingAct{rec.Time, sf.nextSeq}
+	case asdu.ActivationCon, asdu.ActivationTerm, asdu.DeactivationCon:
+		key.deac = a.Coa.Cause == asdu.DeactivationCon
+		if act, ok := sf.acts[key]; ok {
+			rec.ActTime = &act.time
+			// 终止,否定确认或停止激活确认后过程结束
+			if a.Coa.Cause != asdu.ActivationCon || a.Coa.IsNegative {
+				delete(sf.acts, key)
+			}
+		}
+	}
+	sf.mu.Unlock()
+	return sf.sink.Audit(rec)
+}
+
+// decodeAuditValue decode the value of the command to rec
+func decodeAuditValue(rec *AuditRecord, a *asdu.ASDU) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("decode %s failed, %v", a.Type, r)
+		}
+	}()
+
+	var tm time.Time
+	switch a.Type {
+	case asdu.C_SC_NA_1, asdu.C_SC_TA_1:
+		v := a.GetSingleCmd()
+		rec.IOA, rec.Value, tm = v.Ioa, v.Value, v.Time
+		rec.Qualifier, rec.Select = uint(v.Qoc.Qual), v.Qoc.InSelect
+	case asdu.C_DC_NA_1, asdu.C_DC_TA_1:
+		v := a.GetDoubleCmd()
+		rec.IOA, rec.Value, tm = v.Ioa, v.Value, v.Time
+		rec.Qualifier, rec.Select = uint(v.Qoc.Qual), v.Qoc.InSelect
+	case asdu.C_RC_NA_1, asdu.C_RC_TA_1:
+		v := a.GetStepCmd()
+		rec.IOA, rec.Value, tm = v.Ioa, v.Value, v.Time
+		rec.Qualifier, rec.Select = uint(v.Qoc.Qual), v.Qoc.InSelect
+	case asdu.C_SE_NA_1, asdu.C_SE_TA_1:
+		v := a.GetSetpointNormalCmd()
+		rec.IOA, rec.Value, tm = v.Ioa, v.Value.Float64(), v.Time
+		rec.Qualifier, rec.Select = uint(v.Qos.Qual), v.Qos.InSelect
+	case asdu.C_SE_NB_1, asdu.C_SE_TB_1:
+		v := a.GetSetpointCmdScaled()
+		rec.IOA, rec.Value, tm = v.Ioa, v.Value, v.Time
+		rec.Qualifier, rec.Select = uint(v.Qos.Qual), v.Qos.InSelect
+	case asdu.C_SE_NC_1, asdu.C_SE_TC_1:
+		v := a.GetSetpointFloatCmd()
+		rec.IOA, rec.Value, tm = v.Ioa, v.Value, v.Time
+		rec.Qualifier, rec.Select = uint(v.Qos.Qual), v.Qos.InSelect
+	case asdu.C_BO_NA_1, asdu.C_BO_TA_1:
+		v := a.GetBitsString32Cmd()
+		rec.IOA, rec.Value, tm = v.Ioa, v.Value, v.Time
+	case asdu.C_CS_NA_1:
+		rec.IOA, tm = a.GetClockSynchronizationCmd()
+		rec.Value = tm
+	case asdu.C_RP_NA_1:
+		var q asdu.QualifierOfResetProcessCmd
+		rec.IOA, q = a.GetResetProcessCmd()
+		rec.Qualifier = uint(q)
+	case asdu.P_ME_NA_1:
+		v := a.GetParameterNormal()
+		rec.IOA, rec.Value = v.Ioa, v.Value.Float64()
+		rec.Qualifier = uint(v.Qpm.Value())
+	case asdu.P_ME_NB_1:
+		v := a.GetParameterScaled()
+		rec.IOA, rec.Value = v.Ioa, v.Value
+		rec.Qualifier = uint(v.Qpm.Value())
+	case asdu.P_ME_NC_1:
+		v := a.GetParameterFloat()
+		rec.IOA, rec.Value = v.Ioa, v.Value
+		rec.Qualifier = uint(v.Qpm.Value())
+	case asdu.P_AC_NA_1:
+		v := a.GetParameterActivation()
+		rec.IOA, rec.Qualifier = v.Ioa, uint(v.Qpa)
+	}
+	if !tm.IsZero() && a.Type != asdu.C_CS_NA_1 {
+		rec.CmdTime = &tm
+	}
+	return nil
+}
+
+// SetAuditSink set the sink of the audit records of the commands, it must be called before Serve.
+func (sf *Server) SetAuditSink(sink AuditSink) *Server {
+	sf.auditSink = sink
+	return sf
+}
+
+// auditRecord record the command asdu of the session
+func (sf *SrvSession) auditRecord(direction string, a *asdu.ASDU, decision string, err error) {
+	if sf.audit == nil {
+		return
+	}
+	s := sf.session
+	rec := AuditRecord{
+		Side:      "server",
+		Direction: direction,
+		SessionID: s.id,
+		Roles:     s.roles,
+		Decision:  decision,
+	}
+	if s.localAddr != nil {
+		rec.Local = s.localAddr.String()
+	}
+	if s.remoteAddr != nil {
+		rec.Remote = s.remoteAddr.String()
+	}
+	if cert := s.PeerCertificate(); cert != nil {
+		rec.Peer = cert.Subject.String()
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if err = sf.audit.record(rec, a); err != nil {
+		sf.Error("audit failed, %v", err)
+	}
+}
+
+// SetAuditSink set the sink of the audit records of the commands
+func (sf *ClientOption) SetAuditSink(sink AuditSink) *ClientOption {
+	sf.auditSink = sink
+	return sf
+}
+
+// auditRecord record the command asdu of the client
+func (sf *Client) auditRecord(direction string, a *asdu.ASDU, err error) {
+	if sf.audit == nil {
+		return
+	}
+	rec := AuditRecord{
+		Side:      "client",
+		Direction: direction,
+	}
+	if ep := sf.ActiveEndpoint(); ep != nil {
+		rec.Remote = ep.Host
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if err = sf.audit.record(rec, a); err != nil {
+		sf.Error("audit failed, %v", err)
+	}
+}
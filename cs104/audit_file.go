@@ -0,0 +1,106 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileAuditSink an AuditSink write the records as JSON lines to a file,
+// when the file exceeds the max size, it is renamed to path.1, the older
+// ones to path.2 ... path.N, and the one beyond maxBackups is removed.
+type FileAuditSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenFileAuditSink open or create the audit file at path, append to it if it exists,
+// maxSize <= 0 never rotates, maxBackups <= 0 keeps one backup.
+func OpenFileAuditSink(path string, maxSize int64, maxBackups int) (*FileAuditSink, error) {
+	if maxBackups <= 0 {
+		maxBackups = 1
+	}
+	sf := &FileAuditSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := sf.open(); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+func (sf *FileAuditSink) open() error {
+	file, err := os.OpenFile(sf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sf.file, sf.size = file, info.Size()
+	return nil
+}
+
+// Audit imp AuditSink
+func (sf *FileAuditSink) Audit(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.file == nil {
+		return os.ErrClosed
+	}
+	if sf.maxSize > 0 && sf.size > 0 && sf.size+int64(len(line)) > sf.maxSize {
+		if err = sf.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := sf.file.Write(line)
+	sf.size += int64(n)
+	return err
+}
+
+// rotate shift the backups and reopen a new file, must hold the lock
+func (sf *FileAuditSink) rotate() error {
+	if err := sf.file.Close(); err != nil {
+		return err
+	}
+	sf.file = nil
+	_ = os.Remove(fmt.Sprintf("%s.%d", sf.path, sf.maxBackups))
+	for i := sf.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", sf.path, i), fmt.Sprintf("%s.%d", sf.path, i+1))
+	}
+	if err := os.Rename(sf.path, sf.path+".1"); err != nil {
+		if e := sf.open(); e != nil {
+			return e
+		}
+		return err
+	}
+	return sf.open()
+}
+
+// Close close the file
+func (sf *FileAuditSink) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.file == nil {
+		return nil
+	}
+	err := sf.file.Close()
+	sf.file = nil
+	return err
+}
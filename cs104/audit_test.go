@@ -0,0 +1,187 @@
+package cs104
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+type memAuditSink struct {
+	mu      sync.Mutex
+	records []AuditRecord
+}
+
+func (sf *memAuditSink) Audit(rec AuditRecord) error {
+	sf.mu.Lock()
+	sf.records = append(sf.records, rec)
+	sf.mu.Unlock()
+	return nil
+}
+
+func (sf *memAuditSink) get() []AuditRecord {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return append([]AuditRecord(nil), sf.records...)
+}
+
+func Test_auditor(t *testing.T) {
+	assert.Nil(t, newAuditor(nil))
+	assert.NoError(t, (*auditor)(nil).record(AuditRecord{}, singleCmd(t, 1, 100)))
+
+	sink := &memAuditSink{}
+	a := newAuditor(sink)
+	require.NoError(t, a.record(AuditRecord{}, singlePoint(t, 1)))
+	assert.Empty(t, sink.get())
+
+	cmd := singleCmd(t, 1, 100)
+	require.NoError(t, a.record(AuditRecord{Direction: AuditSent}, cmd))
+	con := cmd.Clone()
+	con.Coa.Cause = asdu.ActivationCon
+	require.NoError(t, a.record(AuditRecord{Direction: AuditReceived}, con))
+	term := cmd.Clone()
+	term.Coa.Cause = asdu.ActivationTerm
+	require.NoError(t, a.record(AuditRecord{Direction: AuditReceived}, term))
+	require.NoError(t, a.record(AuditRecord{Direction: AuditReceived}, term))
+
+	records := sink.get()
+	require.Len(t, records, 4)
+	act := records[0]
+	assert.Equal(t, AuditSent, act.Direction)
+	assert.Equal(t, asdu.C_SC_NA_1, act.Type)
+	assert.Equal(t, asdu.C_SC_NA_1.String(), act.TypeName)
+	assert.Equal(t, asdu.Activation.String(), act.Cause)
+	assert.Equal(t, asdu.CommonAddr(1), act.CommonAddr)
+	assert.Equal(t, asdu.InfoObjAddr(100), act.IOA)
+	assert.Equal(t, true, act.Value)
+	assert.True(t, act.Select)
+	assert.Nil(t, act.ActTime)
+	for _, v := range records[1:3] {
+		require.NotNil(t, v.ActTime)
+		assert.Equal(t, act.Time, *v.ActTime)
+	}
+	assert.Nil(t, records[3].ActTime, "terminated")
+
+	// the asdu is not consumed
+	assert.Equal(t, asdu.InfoObjAddr(100), cmd.GetSingleCmd().Ioa)
+}
+
+func Test_auditor_maxPendingActs(t *testing.T) {
+	sink := &memAuditSink{}
+	a := newAuditor(sink)
+	for i := 1; i <= maxPendingActs+1; i++ {
+		require.NoError(t, a.record(AuditRecord{}, singleCmd(t, 1, asdu.InfoObjAddr(i))))
+	}
+	assert.Len(t, a.acts, maxPendingActs)
+
+	// only the oldest activation is evicted
+	for _, ioa := range []asdu.InfoObjAddr{1, 2, maxPendingActs + 1} {
+		con := singleCmd(t, 1, ioa)
+		con.Coa.Cause = asdu.ActivationCon
+		require.NoError(t, a.record(AuditRecord{}, con))
+	}
+	records := sink.get()
+	assert.Nil(t, records[len(records)-3].ActTime)
+	assert.NotNil(t, records[len(records)-2].ActTime)
+	assert.NotNil(t, records[len(records)-1].ActTime)
+}
+
+func TestFileAuditSink(t *testing.T) {
+	path := t.TempDir() + "/audit.log"
+	sink, err := OpenFileAuditSink(path, 400, 2)
+	require.NoError(t, err)
+	rec := AuditRecord{Side: "server", Type: asdu.C_SC_NA_1, TypeName: "C_SC_NA_1", Value: true}
+	for i := 0; i < 12; i++ {
+		rec.IOA = asdu.InfoObjAddr(i)
+		require.NoError(t, sink.Audit(rec))
+	}
+	require.NoError(t, sink.Close())
+	assert.ErrorIs(t, sink.Audit(rec), os.ErrClosed)
+
+	var ioas []asdu.InfoObjAddr
+	for _, name := range []string{path + ".2", path + ".1", path} {
+		info, err := os.Stat(name)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(400))
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var v AuditRecord
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &v))
+			ioas = append(ioas, v.IOA)
+		}
+		f.Close()
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+	// the oldest records are removed, the rest are in order
+	require.NotEmpty(t, ioas)
+	assert.Equal(t, asdu.InfoObjAddr(11), ioas[len(ioas)-1])
+	for i := 1; i < len(ioas); i++ {
+		assert.Equal(t, ioas[i-1]+1, ioas[i])
+	}
+
+	// reopen append to the file
+	sink, err = OpenFileAuditSink(path, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, sink.Audit(rec))
+	require.NoError(t, sink.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(data), "\n"))
+}
+
+type commandServerHandler struct{ nopServerHandler }
+
+func (commandServerHandler) ASDUHandler(c asdu.Connect, a *asdu.ASDU) error {
+	if err := a.SendReplyMirror(c, asdu.ActivationCon); err != nil {
+		return err
+	}
+	return a.SendReplyMirror(c, asdu.ActivationTerm)
+}
+
+func TestAudit_ClientServer(t *testing.T) {
+	srvSink, cliSink := &memAuditSink{}, &memAuditSink{}
+	srv := NewServer(commandServerHandler{})
+	srv.SetAuditSink(srvSink)
+	addr := startTestServer(t, srv)
+
+	o := NewOption().SetAuditSink(cliSink)
+	require.NoError(t, o.AddRemoteServer(addr))
+	c := NewClient(nopClientHandler{}, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	require.NoError(t, c.Start())
+	defer c.Close()
+	require.Eventually(t, c.GetActiveStatus, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, asdu.SetpointCmdFloat(c, asdu.C_SE_NC_1, asdu.CauseOfTransmission{Cause: asdu.Activation}, 1,
+		asdu.SetpointCommandFloatInfo{Ioa: 200, Value: 1.5}))
+	require.Eventually(t, func() bool { return len(cliSink.get()) == 3 && len(srvSink.get()) == 3 }, time.Second, 10*time.Millisecond)
+
+	causes := func(records []AuditRecord) (v []string) {
+		for _, r := range records {
+			v = append(v, r.Direction+" "+r.Cause)
+		}
+		return v
+	}
+	assert.Equal(t, []string{"tx Activation", "rx ActivationCon", "rx ActivationTerm"}, causes(cliSink.get()))
+	assert.Equal(t, []string{"rx Activation", "tx ActivationCon", "tx ActivationTerm"}, causes(srvSink.get()))
+	for _, r := range append(cliSink.get(), srvSink.get()...) {
+		assert.Equal(t, asdu.InfoObjAddr(200), r.IOA)
+		assert.Equal(t, float32(1.5), r.Value)
+	}
+	rec := srvSink.get()[0]
+	assert.Equal(t, "server", rec.Side)
+	assert.Equal(t, srv.Sessions()[0].ID(), rec.SessionID)
+	assert.Equal(t, addr, rec.Local)
+	assert.Equal(t, "client", cliSink.get()[0].Side)
+	assert.Equal(t, addr, cliSink.get()[0].Remote)
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Backoff defines the delay before the next reconnect attempt
+type Backoff interface {
+	// Next returns the delay before the attempt-th retry, attempt starts from 1,
+	// and it is reset after connected successfully.
+	Next(attempt int) time.Duration
+}
+
+// BackoffFunc is an adapter to allow the use of ordinary functions as Backoff
+type BackoffFunc func(attempt int) time.Duration
+
+// Next imp interface Backoff
+func (sf BackoffFunc) Next(attempt int) time.Duration { return sf(attempt) }
+
+// ConstantBackoff retry with fixed interval, and random jitter in [0, Jitter)
+type ConstantBackoff struct {
+	Interval time.Duration
+	Jitter   time.Duration
+}
+
+// Next imp interface Backoff
+func (sf ConstantBackoff) Next(int) time.Duration {
+	return sf.Interval + jitter(sf.Jitter)
+}
+
+// ExponentialBackoff retry with exponential growing interval,
+// delay = min(Initial * Multiplier^(attempt-1), Max), then randomized by Jitter.
+type ExponentialBackoff struct {
+	Initial    time.Duration // 首次重试间隔
+	Max        time.Duration // 最大重试间隔, 0 表示不限制
+	Multiplier float64       // 增长倍数, 小于1时使用2
+	Jitter     float64       // 随机因子[0, 1], 实际间隔在 delay*(1-Jitter) 到 delay 之间
+}
+
+// DefaultExponentialBackoff 首次重试500ms,最大间隔1分钟
+func DefaultExponentialBackoff() *ExponentialBackoff {
+	return &ExponentialBackoff{
+		Initial:    500 * time.Millisecond,
+		Max:        DefaultReconnectInterval,
+		Multiplier: 2,
+		Jitter:     0.2,
+	}
+}
+
+// Next imp interface Backoff
+func (sf *ExponentialBackoff) Next(attempt int) time.Duration {
+	multiplier := sf.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(sf.Initial)
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if sf.Max > 0 && delay >= float64(sf.Max) {
+			break
+		}
+	}
+	if sf.Max > 0 && delay > float64(sf.Max) {
+		delay = float64(sf.Max)
+	}
+	if sf.Jitter > 0 {
+		j := sf.Jitter
+		if j > 1 {
+			j = 1
+		}
+		delay -= delay * j * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// reconnectDelay returns the delay before the attempt-th retry.
+// without Backoff, it is reconnectInterval after dial failed,
+// and random 500ms-1s after disconnected.
+func (sf *ClientOption) reconnectDelay(attempt int, dialFailed bool) time.Duration {
+	if sf.backoff != nil {
+		return sf.backoff.Next(attempt)
+	}
+	if dialFailed {
+		return sf.reconnectInterval
+	}
+	// 随机500ms-1s的重试，避免快速重试造成服务器许多无效连接
+	return time.Millisecond * time.Duration(500+rand.Intn(500))
+}
+
+// sleepContext sleep d, return false if ctx done
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,39 @@
+package cs104
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponentialBackoff_Next(t *testing.T) {
+	b := &ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, b.Next(tt.attempt), "attempt %d", tt.attempt)
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Next(5)
+		assert.True(t, d > 500*time.Millisecond && d <= time.Second, "jitter delay %v", d)
+	}
+}
+
+func TestConstantBackoff_Next(t *testing.T) {
+	b := ConstantBackoff{Interval: time.Second, Jitter: 100 * time.Millisecond}
+	for i := 1; i < 100; i++ {
+		d := b.Next(i)
+		assert.True(t, d >= time.Second && d < 1100*time.Millisecond, "delay %v", d)
+	}
+}
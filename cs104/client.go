@@ -5,13 +5,10 @@
 package cs104
 
 import (
-	"bufio"
 	"context"
-	"errors"
 	"io"
-	"math/rand"
 	"net"
-	"strings"
+	"net/url"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,6 +27,8 @@ type Client struct {
 	option  ClientOption
 	conn    net.Conn
 	handler ClientHandlerInterface
+	audit   *auditor
+	stats   linkCounters
 
 	// channel
 	rcvASDU  chan []byte // for received asdu
@@ -61,9 +60,22 @@ type Client struct {
 	ctx         context.Context
 	cancel      context.CancelFunc
 	closeCancel context.CancelFunc
+	runDone     chan struct{} // running 退出时关闭
+	shutdown    uint32        // 优雅关闭中
 
 	onConnect        func(c *Client) // need non-blocking
 	onConnectionLost func(c *Client) // need non-blocking
+	onEndpointChange func(c *Client, endpoint *url.URL)
+
+	endpoint int32 // 当前连接的端点索引,-1 表示未连接
+
+	// 连接状态事件
+	stateMux      sync.Mutex
+	state         ConnState
+	onStateChange func(c *Client, ev ConnStateEvent)
+	subscribers   map[chan ConnStateEvent]struct{}
+	lostReason    ConnStateReason
+	lostErr       error
 }
 
 // NewClient returns an IEC104 master,default config and default asdu.ParamsWide params
@@ -71,6 +83,7 @@ func NewClient(handler ClientHandlerInterface, o *ClientOption) *Client {
 	return &Client{
 		option:           *o,
 		handler:          handler,
+		audit:            newAuditor(o.auditSink),
 		rcvASDU:          make(chan []byte, o.config.RecvUnAckLimitW<<4),
 		sendASDU:         make(chan []byte, o.config.SendUnAckLimitK<<4),
 		rcvRaw:           make(chan []byte, o.config.RecvUnAckLimitW<<5),
@@ -78,6 +91,8 @@ func NewClient(handler ClientHandlerInterface, o *ClientOption) *Client {
 		Clog:             clog.NewLogger("cs104 client => "),
 		onConnect:        func(*Client) {},
 		onConnectionLost: func(*Client) {},
+		onEndpointChange: func(*Client, *url.URL) {},
+		endpoint:         -1,
 	}
 }
 
@@ -97,10 +112,28 @@ func (sf *Client) SetConnectionLostHandler(f func(c *Client)) *Client {
 	return sf
 }
 
+// SetEndpointChangeHandler set the handler called when the client connected to an endpoint,
+// which may be different from the last one after failover.
+func (sf *Client) SetEndpointChangeHandler(f func(c *Client, endpoint *url.URL)) *Client {
+	if f != nil {
+		sf.onEndpointChange = f
+	}
+	return sf
+}
+
+// ActiveEndpoint returns the endpoint currently connected, nil if not connected
+func (sf *Client) ActiveEndpoint() *url.URL {
+	i := atomic.LoadInt32(&sf.endpoint)
+	if i < 0 {
+		return nil
+	}
+	return sf.option.servers[i]
+}
+
 // Start start the server,and return quickly,if it nil,the server will disconnected background,other failed
 func (sf *Client) Start() error {
-	if sf.option.server == nil {
-		return errors.New("empty remote server")
+	if err := sf.option.ensureServers(); err != nil {
+		return err
 	}
 
 	go sf.running()
@@ -117,41 +150,103 @@ func (sf *Client) running() {
 		return
 	}
 	ctx, sf.closeCancel = context.WithCancel(context.Background())
+	done := make(chan struct{})
+	sf.runDone = done
+	atomic.StoreUint32(&sf.shutdown, 0)
 	sf.rwMux.Unlock()
-	defer sf.setConnectStatus(initial)
+	defer func() {
+		defer close(done)
+		sf.setConnectStatus(initial)
+		sf.setState(StateClosed, ReasonLocalClosed, nil, nil)
+	}()
+
+	var standby *standbyPool
+	if sf.option.standby && len(sf.option.servers) > 1 {
+		standby = newStandbyPool(&sf.option, sf.Clog)
+		go standby.run(ctx)
+	}
 
+	last, attempt, established := -1, 0, false
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
 		}
+		if atomic.LoadUint32(&sf.shutdown) == 1 {
+			return
+		}
 
-		sf.Debug("connecting server %+v", sf.option.server)
-		conn, err := openConnection(sf.option.server, sf.option.TLSConfig, sf.option.config.ConnectTimeout0)
-		if err != nil {
-			sf.Error("connect failed, %v", err)
-			if !sf.option.autoReconnect {
+		connected := false
+		var held func(int) bool
+		if standby != nil {
+			held = standby.held
+		}
+		order := sf.option.endpointOrder(ctx, last, held)
+		if standby != nil {
+			// 有可用的备用连接时优先切换,不先重拨刚断开的端点
+			order = standby.prefer(order)
+		}
+		for _, i := range order {
+			select {
+			case <-ctx.Done():
 				return
+			default:
 			}
-			time.Sleep(sf.option.reconnectInterval)
-			continue
-		}
-		sf.Debug("connect success")
-		sf.conn = conn
-		sf.run(ctx)
-		if sf.conn != nil {
-			sf.conn.Close()
-			sf.conn = nil
+
+			last = i
+			server := sf.option.servers[i]
+			var conn net.Conn
+			if standby != nil {
+				conn = standby.take(i)
+			}
+			if conn != nil {
+				sf.Debug("switch to standby server %+v", server)
+			} else {
+				sf.Debug("connecting server %+v", server)
+				sf.setState(StateDialing, ReasonNone, nil, server)
+				var err error
+				if conn, err = sf.option.openConnection(ctx, server); err != nil {
+					sf.Error("connect failed, %v", err)
+					sf.setState(StateLost, ReasonDialError, err, server)
+					continue
+				}
+				if standby != nil {
+					standby.setActive(i)
+				}
+			}
+			sf.Debug("connect success")
+			connected, attempt = true, 0
+			if established {
+				atomic.AddUint64(&sf.stats.reconnects, 1)
+			}
+			established = true
+			atomic.StoreInt32(&sf.endpoint, int32(i))
+			sf.onEndpointChange(sf, server)
+			sf.conn = conn
+			sf.run(ctx)
+			if sf.conn != nil {
+				sf.conn.Close()
+				sf.conn = nil
+			}
+			atomic.StoreInt32(&sf.endpoint, -1)
+			if standby != nil {
+				standby.clearActive()
+			}
+			sf.Debug("disconnected server %+v", server)
+			break
 		}
 
-		sf.Debug("disconnected server %+v", sf.option.server)
-		select {
-		case <-ctx.Done():
+		if (!connected && !sf.option.autoReconnect) || atomic.LoadUint32(&sf.shutdown) == 1 {
+			return
+		}
+		// 有备用连接时立即切换
+		if connected && standby != nil {
+			continue
+		}
+		attempt++
+		if !sleepContext(ctx, sf.option.reconnectDelay(attempt, !connected)) {
 			return
-		default:
-			// 随机500ms-1s的重试，避免快速重试造成服务器许多无效连接
-			time.Sleep(time.Millisecond * time.Duration(500+rand.Intn(500)))
 		}
 	}
 }
@@ -164,49 +259,23 @@ func (sf *Client) recvLoop() {
 		sf.Debug("recvLoop stopped")
 	}()
 
-	var err error
-	var peekBuf []byte
-	var pkgLen byte
-	reader := bufio.NewReaderSize(sf.conn, 4096)
+	reader := NewAPDUReader(sf.conn)
 	for {
-		if peekBuf, err = reader.Peek(2); err != nil {
-			if err == io.EOF {
-				sf.Error("peek header remote connect closed")
-			} else {
-				sf.Error("peek header receive failed, %v", err)
-			}
-
-			return
-		}
-
-		if peekBuf[0] != startFrame {
-			sf.Error("receive apdu peek, %v", peekBuf)
-			return
-		}
-
-		pkgLen = 2 + peekBuf[1]
-		if _, err = reader.Peek(int(pkgLen)); err != nil {
-			if err == io.EOF {
-				sf.Error("peek pkg remote connect closed, %v", err)
-			} else {
-				sf.Error("peek pkg receive failed, %v", err)
-			}
-
-			return
-		}
-
-		rawData := make([]byte, pkgLen)
-		if _, err = io.ReadFull(reader, rawData); err != nil {
-			if err == io.EOF {
-				sf.Error("remote connect closed")
+		rawData, err := reader.ReadAPDU()
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				sf.Error("remote connect closed, %v", err)
+				sf.setLostReason(ReasonRemoteClosed, err)
 			} else {
 				sf.Error("receive failed, %v", err)
+				sf.setLostReason(ReasonIOError, err)
 			}
-
 			return
 		}
 
 		sf.Debug("RX Raw[% x]", rawData)
+		sf.stats.received(rawData)
+		sf.observeFrame(FrameReceived, rawData)
 		sf.rcvRaw <- rawData
 	}
 }
@@ -218,29 +287,20 @@ func (sf *Client) sendLoop() {
 		sf.wg.Done()
 		sf.Debug("sendLoop stopped")
 	}()
+	writer := NewAPDUWriter(sf.conn)
 	for {
 		select {
 		case <-sf.ctx.Done():
 			return
 		case apdu := <-sf.sendRaw:
 			sf.Debug("TX Raw[% x]", apdu)
-			for wrCnt := 0; len(apdu) > wrCnt; {
-				byteCount, err := sf.conn.Write(apdu[wrCnt:])
-				if err != nil {
-					// See: https://github.com/golang/go/issues/4373
-					if err != io.EOF && err != io.ErrClosedPipe ||
-						strings.Contains(err.Error(), "use of closed network connection") {
-						sf.Error("sendRaw failed, %v", err)
-						return
-					}
-					if e, ok := err.(net.Error); !ok || !e.Temporary() {
-						sf.Error("sendRaw failed, %v", err)
-						return
-					}
-					// temporary error may be recoverable
-				}
-				wrCnt += byteCount
+			if err := writer.WriteAPDU(apdu); err != nil {
+				sf.Error("sendRaw failed, %v", err)
+				sf.setLostReason(ReasonIOError, err)
+				return
 			}
+			sf.stats.sent(apdu)
+			sf.observeFrame(FrameSent, apdu)
 		}
 	}
 }
@@ -251,8 +311,12 @@ func (sf *Client) run(ctx context.Context) {
 	// before any thing make sure init
 	sf.cleanUp()
 
+	sf.rwMux.Lock()
 	sf.ctx, sf.cancel = context.WithCancel(ctx)
+	sf.rwMux.Unlock()
 	sf.setConnectStatus(connected)
+	sf.takeLostReason()
+	sf.setState(StateConnected, ReasonNone, nil, sf.ActiveEndpoint())
 	sf.wg.Add(3)
 	go sf.recvLoop()
 	go sf.sendLoop()
@@ -271,22 +335,23 @@ func (sf *Client) run(ctx context.Context) {
 	sf.stopDtActiveSendSince.Store(willNotTimeout)
 
 	sendSFrame := func(rcvSN uint16) {
-		sf.Debug("TX sFrame %v", sAPCI{rcvSN})
-		sf.sendRaw <- newSFrame(rcvSN)
+		sf.Debug("TX sFrame %v", SAPCI{rcvSN})
+		sf.sendRaw <- NewSFrame(rcvSN)
 	}
 
 	sendIFrame := func(asdu1 []byte) {
 		seqNo := sf.seqNoSend
 
-		iframe, err := newIFrame(seqNo, sf.seqNoRcv, asdu1)
+		iframe, err := NewIFrame(seqNo, sf.seqNoRcv, asdu1)
 		if err != nil {
 			return
 		}
 		sf.ackNoRcv = sf.seqNoRcv
 		sf.seqNoSend = (seqNo + 1) & 32767
-		sf.pending = append(sf.pending, seqPending{seqNo & 32767, time.Now()})
+		sf.pending = append(sf.pending, seqPending{seqNo & 32767, time.Now(), 0})
+		sf.stats.setUnacked(int(seqNoCount(sf.ackNoSend, sf.seqNoSend)))
 
-		sf.Debug("TX iFrame %v", iAPCI{seqNo, sf.seqNoRcv})
+		sf.Debug("TX iFrame %v", IAPCI{seqNo, sf.seqNoRcv})
 		sf.sendRaw <- iframe
 	}
 
@@ -294,9 +359,15 @@ func (sf *Client) run(ctx context.Context) {
 		// default: STOPDT, when connected establish and not enable "data transfer" yet
 		atomic.StoreUint32(&sf.isActive, inactive)
 		sf.setConnectStatus(disconnected)
+		sf.setLostReason(ReasonLocalClosed, nil) // 无其他原因时为本端关闭
+		reason, err := sf.takeLostReason()
+		endpoint := sf.ActiveEndpoint()
 		checkTicker.Stop()
 		_ = sf.conn.Close() // 连锁引发cancel
 		sf.wg.Wait()
+		sf.observeClose()
+		sf.takeLostReason() // 丢弃关闭连接引发的错误
+		sf.setState(StateLost, reason, err, endpoint)
 		sf.onConnectionLost(sf)
 		sf.Debug("run stopped!")
 	}()
@@ -323,6 +394,8 @@ func (sf *Client) run(ctx context.Context) {
 				now.Sub(sf.startDtActiveSendSince.Load().(time.Time)) >= sf.option.config.SendUnAckTimeout1 ||
 				now.Sub(sf.stopDtActiveSendSince.Load().(time.Time)) >= sf.option.config.SendUnAckTimeout1 {
 				sf.Error("test frame alive confirm timeout t₁")
+				atomic.AddUint64(&sf.stats.t1, 1)
+				sf.setLostReason(ReasonT1Timeout, nil)
 				return
 			}
 			// check oldest unacknowledged outbound
@@ -330,7 +403,9 @@ func (sf *Client) run(ctx context.Context) {
 				//now.Sub(sf.peek()) >= sf.SendUnAckTimeout1 {
 				now.Sub(sf.pending[0].sendTime) >= sf.option.config.SendUnAckTimeout1 {
 				sf.ackNoSend++
+				atomic.AddUint64(&sf.stats.t1, 1)
 				sf.Error("fatal transmission timeout t₁")
+				sf.setLostReason(ReasonT1Timeout, nil)
 				return
 			}
 
@@ -338,36 +413,55 @@ func (sf *Client) run(ctx context.Context) {
 			if sf.ackNoRcv != sf.seqNoRcv &&
 				(now.Sub(unAckRcvSince) >= sf.option.config.RecvUnAckTimeout2 ||
 					now.Sub(idleTimeout3Sine) >= timeoutResolution) {
+				if now.Sub(unAckRcvSince) >= sf.option.config.RecvUnAckTimeout2 {
+					atomic.AddUint64(&sf.stats.t2, 1)
+				}
 				sendSFrame(sf.seqNoRcv)
 				sf.ackNoRcv = sf.seqNoRcv
 			}
 
 			// 空闲时间到，发送TestFrActive帧,保活
 			if now.Sub(idleTimeout3Sine) >= sf.option.config.IdleTimeout3 {
-				sf.sendUFrame(uTestFrActive)
+				atomic.AddUint64(&sf.stats.t3, 1)
+				sf.sendUFrame(UTestFrActive)
 				testFrAliveSendSince = time.Now()
 				idleTimeout3Sine = testFrAliveSendSince
 			}
 
+			// 优雅关闭: 发送完毕且所有I帧均已确认后发送STOPDT,收到确认后断开
+			if atomic.LoadUint32(&sf.shutdown) == 1 &&
+				sf.stopDtActiveSendSince.Load().(time.Time).Equal(willNotTimeout) {
+				if atomic.LoadUint32(&sf.isActive) == inactive {
+					sf.Debug("shutdown, disconnect")
+					return
+				}
+				if len(sf.sendASDU) == 0 && sf.ackNoSend == sf.seqNoSend && sf.ackNoRcv == sf.seqNoRcv {
+					sf.Debug("shutdown, all I-frames acknowledged, stop data transfer")
+					sf.SendStopDt()
+				}
+			}
+
 		case apdu := <-sf.rcvRaw:
 			idleTimeout3Sine = time.Now() // 每收到一个i帧,S帧,U帧, 重置空闲定时器, t3
-			apci, asduVal := parse(apdu)
+			apci, asduVal := ParseAPDU(apdu)
 			switch head := apci.(type) {
-			case sAPCI:
+			case SAPCI:
 				sf.Debug("RX sFrame %v", head)
-				if !sf.updateAckNoOut(head.rcvSN) {
+				if !sf.updateAckNoOut(head.RcvSN) {
 					sf.Error("fatal incoming acknowledge either earlier than previous or later than sendTime")
+					sf.setLostReason(ReasonSequenceError, nil)
 					return
 				}
 
-			case iAPCI:
+			case IAPCI:
 				sf.Debug("RX iFrame %v", head)
 				if atomic.LoadUint32(&sf.isActive) == inactive {
 					sf.Warn("station not active")
 					break // not active, discard apdu
 				}
-				if !sf.updateAckNoOut(head.rcvSN) || head.sendSN != sf.seqNoRcv {
+				if !sf.updateAckNoOut(head.RcvSN) || head.SendSN != sf.seqNoRcv {
 					sf.Error("fatal incoming acknowledge either earlier than previous or later than sendTime")
+					sf.setLostReason(ReasonSequenceError, nil)
 					return
 				}
 
@@ -382,27 +476,29 @@ func (sf *Client) run(ctx context.Context) {
 					sf.ackNoRcv = sf.seqNoRcv
 				}
 
-			case uAPCI:
+			case UAPCI:
 				sf.Debug("RX uFrame %v", head)
-				switch head.function {
-				//case uStartDtActive:
-				//	sf.sendUFrame(uStartDtConfirm)
+				switch head.Function {
+				//case UStartDtActive:
+				//	sf.sendUFrame(UStartDtConfirm)
 				//	atomic.StoreUint32(&sf.isActive, active)
-				case uStartDtConfirm:
+				case UStartDtConfirm:
 					atomic.StoreUint32(&sf.isActive, active)
 					sf.startDtActiveSendSince.Store(willNotTimeout)
-				//case uStopDtActive:
-				//	sf.sendUFrame(uStopDtConfirm)
+					sf.setState(StateActive, ReasonNone, nil, sf.ActiveEndpoint())
+				//case UStopDtActive:
+				//	sf.sendUFrame(UStopDtConfirm)
 				//	atomic.StoreUint32(&sf.isActive, inactive)
-				case uStopDtConfirm:
+				case UStopDtConfirm:
 					atomic.StoreUint32(&sf.isActive, inactive)
 					sf.stopDtActiveSendSince.Store(willNotTimeout)
-				case uTestFrActive:
-					sf.sendUFrame(uTestFrConfirm)
-				case uTestFrConfirm:
+					sf.setState(StateConnected, ReasonNone, nil, sf.ActiveEndpoint())
+				case UTestFrActive:
+					sf.sendUFrame(UTestFrConfirm)
+				case UTestFrConfirm:
 					testFrAliveSendSince = willNotTimeout
 				default:
-					sf.Error("illegal U-Frame functions[0x%02x] ignored", head.function)
+					sf.Error("illegal U-Frame functions[0x%02x] ignored", head.Function)
 				}
 			}
 		}
@@ -437,6 +533,14 @@ func (sf *Client) handlerLoop() {
 	}
 }
 
+// linkContext return the context and its cancel of the current connection,
+// they are replaced on every reconnect, nil before the first connection.
+func (sf *Client) linkContext() (context.Context, context.CancelFunc) {
+	sf.rwMux.RLock()
+	defer sf.rwMux.RUnlock()
+	return sf.ctx, sf.cancel
+}
+
 func (sf *Client) setConnectStatus(status uint32) {
 	sf.rwMux.Lock()
 	atomic.StoreUint32(&sf.status, status)
@@ -456,6 +560,7 @@ func (sf *Client) cleanUp() {
 	sf.seqNoRcv = 0
 	sf.seqNoSend = 0
 	sf.pending = nil
+	sf.stats.setUnacked(0)
 	// clear sending chan buffer
 loop:
 	for {
@@ -471,8 +576,8 @@ loop:
 }
 
 func (sf *Client) sendUFrame(which byte) {
-	sf.Debug("TX uFrame %v", uAPCI{which})
-	sf.sendRaw <- newUFrame(which)
+	sf.Debug("TX uFrame %v", UAPCI{which})
+	sf.sendRaw <- NewUFrame(which)
 }
 
 func (sf *Client) updateAckNoOut(ackNo uint16) (ok bool) {
@@ -486,13 +591,15 @@ func (sf *Client) updateAckNoOut(ackNo uint16) (ok bool) {
 
 	// confirm reception
 	for i, v := range sf.pending {
-		if v.seq == (ackNo - 1) {
+		if v.seq == (ackNo-1)&32767 {
+			sf.stats.acked(v.sendTime)
 			sf.pending = sf.pending[i+1:]
 			break
 		}
 	}
 
 	sf.ackNoSend = ackNo
+	sf.stats.setUnacked(int(seqNoCount(sf.ackNoSend, sf.seqNoSend)))
 	return true
 }
 
@@ -510,31 +617,38 @@ func (sf *Client) clientHandler(asduPack *asdu.ASDU) error {
 	}()
 
 	sf.Debug("ASDU %+v", asduPack)
+	sf.auditRecord(AuditReceived, asduPack, nil)
+
+	return HandleClientASDU(sf.handler, sf, asduPack)
+}
 
+// HandleClientASDU dispatch the asdu received by the master to the method of the handler by its type,
+// it's also useful to feed the handler with the asdus not from a live connection, such as a capture.
+func HandleClientASDU(h ClientHandlerInterface, c asdu.Connect, asduPack *asdu.ASDU) error {
 	switch asduPack.Identifier.Type {
 	case asdu.C_IC_NA_1: // InterrogationCmd
-		return sf.handler.InterrogationHandler(sf, asduPack)
+		return h.InterrogationHandler(c, asduPack)
 
 	case asdu.C_CI_NA_1: // CounterInterrogationCmd
-		return sf.handler.CounterInterrogationHandler(sf, asduPack)
+		return h.CounterInterrogationHandler(c, asduPack)
 
 	case asdu.C_RD_NA_1: // ReadCmd
-		return sf.handler.ReadHandler(sf, asduPack)
+		return h.ReadHandler(c, asduPack)
 
 	case asdu.C_CS_NA_1: // ClockSynchronizationCmd
-		return sf.handler.ClockSyncHandler(sf, asduPack)
+		return h.ClockSyncHandler(c, asduPack)
 
 	case asdu.C_TS_NA_1: // TestCommand
-		return sf.handler.TestCommandHandler(sf, asduPack)
+		return h.TestCommandHandler(c, asduPack)
 
 	case asdu.C_RP_NA_1: // ResetProcessCmd
-		return sf.handler.ResetProcessHandler(sf, asduPack)
+		return h.ResetProcessHandler(c, asduPack)
 
 	case asdu.C_CD_NA_1: // DelayAcquireCommand
-		return sf.handler.DelayAcquisitionHandler(sf, asduPack)
+		return h.DelayAcquisitionHandler(c, asduPack)
 	}
 
-	return sf.handler.ASDUHandler(sf, asduPack)
+	return h.ASDUHandler(c, asduPack)
 }
 
 // Params returns params of client
@@ -542,8 +656,21 @@ func (sf *Client) Params() *asdu.Params {
 	return &sf.option.params
 }
 
-// Send send asdu
+// Send send asdu, when the send buffer is full it behaves as the overflow policy
 func (sf *Client) Send(a *asdu.ASDU) error {
+	return sf.send(context.Background(), a, sf.option.overflow)
+}
+
+// SendContext send asdu, it blocks until the send buffer has free space,
+// the ctx is done or the connection is lost.
+func (sf *Client) SendContext(ctx context.Context, a *asdu.ASDU) error {
+	return sf.send(ctx, a, OverflowBlock)
+}
+
+func (sf *Client) send(ctx context.Context, a *asdu.ASDU, policy OverflowPolicy) error {
+	if atomic.LoadUint32(&sf.shutdown) == 1 {
+		return ErrShuttingDown
+	}
 	if !sf.IsConnected() {
 		return ErrUseClosedConnection
 	}
@@ -554,12 +681,19 @@ func (sf *Client) Send(a *asdu.ASDU) error {
 	if err != nil {
 		return err
 	}
-	select {
-	case sf.sendASDU <- data:
-	default:
-		return ErrBufferFulled
+	linkCtx, linkCancel := sf.linkContext()
+	if linkCtx == nil {
+		return ErrUseClosedConnection
 	}
-	return nil
+	err = enqueue(ctx, linkCtx, sf.sendASDU, data, policy, func() {
+		sf.setLostReason(ReasonOverflow, ErrBufferFulled)
+		linkCancel()
+	})
+	if err == ErrBufferFulled && policy == OverflowDisconnect {
+		sf.Error("send buffer is full, disconnect")
+	}
+	sf.auditRecord(AuditSent, a, err)
+	return err
 }
 
 // UnderlyingConn returns underlying conn of client
@@ -577,16 +711,45 @@ func (sf *Client) Close() error {
 	return nil
 }
 
+// Shutdown gracefully close the client, new sends are rejected with ErrShuttingDown,
+// the queued asdus are sent, and after all the I-frames are acknowledged it sends STOPDT
+// and waits for the confirmation before closing the connection, no reconnect after that.
+// if ctx is done before that, the connection is closed by force and ctx.Err() is returned.
+func (sf *Client) Shutdown(ctx context.Context) error {
+	sf.rwMux.Lock()
+	atomic.StoreUint32(&sf.shutdown, 1)
+	done, closeCancel := sf.runDone, sf.closeCancel
+	sf.rwMux.Unlock()
+	if done == nil {
+		return nil
+	}
+	// 未连接时无需等待,直接停止重连
+	if !sf.IsConnected() {
+		closeCancel()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		sf.Warn("shutdown timeout, close by force")
+		closeCancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
 // SendStartDt start data transmission on this connection
 func (sf *Client) SendStartDt() {
+	sf.setState(StateStartDtPending, ReasonNone, nil, sf.ActiveEndpoint())
 	sf.startDtActiveSendSince.Store(time.Now())
-	sf.sendUFrame(uStartDtActive)
+	sf.sendUFrame(UStartDtActive)
 }
 
 // SendStopDt stop data transmission on this connection
 func (sf *Client) SendStopDt() {
+	sf.setState(StateStopDtPending, ReasonNone, nil, sf.ActiveEndpoint())
 	sf.stopDtActiveSendSince.Store(time.Now())
-	sf.sendUFrame(uStopDtActive)
+	sf.sendUFrame(UStopDtActive)
 }
 
 // InterrogationCmd wrap asdu.InterrogationCmd
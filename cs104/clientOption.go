@@ -6,6 +6,7 @@ package cs104
 
 import (
 	"crypto/tls"
+	"errors"
 	"net/url"
 	"strings"
 	"time"
@@ -17,10 +18,18 @@ import (
 type ClientOption struct {
 	config            Config
 	params            asdu.Params
-	server            *url.URL      // 连接的服务器端
-	autoReconnect     bool          // 是否启动重连
-	reconnectInterval time.Duration // 重连间隔时间
-	TLSConfig         *tls.Config   // tls配置
+	servers           []*url.URL       // 连接的服务器端,按优先级排列
+	failover          FailoverStrategy // 多端点切换策略
+	standby           bool             // 是否保持到其他端点的备用连接(STOPDT状态)
+	autoReconnect     bool             // 是否启动重连
+	reconnectInterval time.Duration    // 重连间隔时间
+	backoff           Backoff          // 重连退避策略,nil 使用 reconnectInterval
+	dial              DialContextFunc  // 自定义拨号,nil 使用 net.Dialer
+	factory           ConnFactory      // 自定义连接工厂,设置后替代内置拨号
+	overflow          OverflowPolicy   // 发送缓冲区满时的处理策略
+	auditSink         AuditSink        // 命令审计
+	frameObserver     FrameObserver    // 帧观察者
+	TLSConfig         *tls.Config      // tls配置
 }
 
 // NewOption with default config and default asdu.ParamsWide params
@@ -29,9 +38,17 @@ func NewOption() *ClientOption {
 		DefaultConfig(),
 		*asdu.ParamsWide,
 		nil,
+		FailoverSticky,
+		false,
 		true,
 		DefaultReconnectInterval,
 		nil,
+		nil,
+		nil,
+		OverflowDropNewest,
+		nil,
+		nil,
+		nil,
 	}
 }
 
@@ -63,12 +80,55 @@ func (sf *ClientOption) SetReconnectInterval(t time.Duration) *ClientOption {
 	return sf
 }
 
+// SetBackoff set the reconnect backoff policy, it takes precedence over the reconnect interval,
+// nil restores the default: reconnect interval after dial failed, random 500ms-1s after disconnected.
+func (sf *ClientOption) SetBackoff(b Backoff) *ClientOption {
+	sf.backoff = b
+	return sf
+}
+
+// SetDialer set the custom dialer used to open the tcp connection,
+// tls handshake is done over the connection returned if the scheme is tls.
+func (sf *ClientOption) SetDialer(dial DialContextFunc) *ClientOption {
+	sf.dial = dial
+	return sf
+}
+
+// SetConnFactory set the factory to open the connection to the endpoint, it replaces
+// the built-in dialing (and the dialer set by SetDialer), no tls handshake is done.
+// if no remote server added, a placeholder endpoint conn:// is used.
+func (sf *ClientOption) SetConnFactory(f ConnFactory) *ClientOption {
+	sf.factory = f
+	return sf
+}
+
 // SetAutoReconnect enable auto reconnect
 func (sf *ClientOption) SetAutoReconnect(b bool) *ClientOption {
 	sf.autoReconnect = b
 	return sf
 }
 
+// SetOverflowPolicy set the policy applied by Send when the send buffer is full
+func (sf *ClientOption) SetOverflowPolicy(p OverflowPolicy) *ClientOption {
+	sf.overflow = p
+	return sf
+}
+
+// SetFailoverStrategy set the strategy to choose the endpoint when there are several remote servers
+func (sf *ClientOption) SetFailoverStrategy(s FailoverStrategy) *ClientOption {
+	sf.failover = s
+	return sf
+}
+
+// SetStandby enable keeping parallel standby connections in STOPDT state
+// to the remote servers other than the active one, when the active connection lost,
+// the client switches to the standby connection without dialing.
+// a standby connection failed is re-dialed with the delay of the reconnect backoff.
+func (sf *ClientOption) SetStandby(b bool) *ClientOption {
+	sf.standby = b
+	return sf
+}
+
 // SetTLSConfig set tls config
 func (sf *ClientOption) SetTLSConfig(t *tls.Config) *ClientOption {
 	sf.TLSConfig = t
@@ -76,7 +136,8 @@ func (sf *ClientOption) SetTLSConfig(t *tls.Config) *ClientOption {
 }
 
 // AddRemoteServer adds a broker URI to the list of brokers to be used.
-// The format should be scheme://host:port
+// the first one added is the primary, the others are used by failover in order.
+// The format should be scheme://host:port, or unix:///path/to/socket for unix socket
 // Default values for hostname is "127.0.0.1", for schema is "tcp://".
 // An example broker URI would look like: tcp://foobar.com:1204
 func (sf *ClientOption) AddRemoteServer(server string) error {
@@ -90,6 +151,19 @@ func (sf *ClientOption) AddRemoteServer(server string) error {
 	if err != nil {
 		return err
 	}
-	sf.server = remoteURL
+	sf.servers = append(sf.servers, remoteURL)
+	return nil
+}
+
+// ensureServers return error if no remote server, add the placeholder endpoint
+// when the conn factory is set.
+func (sf *ClientOption) ensureServers() error {
+	if len(sf.servers) > 0 {
+		return nil
+	}
+	if sf.factory == nil {
+		return errors.New("empty remote server")
+	}
+	sf.servers = []*url.URL{{Scheme: "conn"}}
 	return nil
 }
@@ -0,0 +1,163 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"net/url"
+	"time"
+)
+
+// ConnState the connection state of client
+type ConnState uint8
+
+// connection state defined
+const (
+	StateClosed         ConnState = iota // 已关闭,未启动或已调用Close
+	StateDialing                         // 正在建立tcp连接
+	StateConnected                       // 已连接,未启动数据传输(STOPDT)
+	StateStartDtPending                  // 已发送STARTDT act,等待确认
+	StateActive                          // 已启动数据传输(STARTDT)
+	StateStopDtPending                   // 已发送STOPDT act,等待确认
+	StateLost                            // 连接断开或建立失败,将重连
+)
+
+// String returns the name of state
+func (sf ConnState) String() string {
+	switch sf {
+	case StateClosed:
+		return "Closed"
+	case StateDialing:
+		return "Dialing"
+	case StateConnected:
+		return "Connected"
+	case StateStartDtPending:
+		return "StartDtPending"
+	case StateActive:
+		return "Active"
+	case StateStopDtPending:
+		return "StopDtPending"
+	case StateLost:
+		return "Lost"
+	}
+	return "Unknown"
+}
+
+// ConnStateReason the reason of the state changed
+type ConnStateReason uint8
+
+// connection state reason defined
+const (
+	ReasonNone          ConnStateReason = iota // 正常状态转换
+	ReasonDialError                            // 建立连接失败
+	ReasonT1Timeout                            // t₁ 超时未收到确认
+	ReasonSequenceError                        // 收发序号错误
+	ReasonRemoteClosed                         // 对端关闭连接
+	ReasonIOError                              // 读写错误
+	ReasonOverflow                             // 发送缓冲区满,按策略断开
+	ReasonLocalClosed                          // 本端主动关闭
+)
+
+// String returns the name of reason
+func (sf ConnStateReason) String() string {
+	switch sf {
+	case ReasonNone:
+		return "None"
+	case ReasonDialError:
+		return "DialError"
+	case ReasonT1Timeout:
+		return "T1Timeout"
+	case ReasonSequenceError:
+		return "SequenceError"
+	case ReasonRemoteClosed:
+		return "RemoteClosed"
+	case ReasonIOError:
+		return "IOError"
+	case ReasonOverflow:
+		return "Overflow"
+	case ReasonLocalClosed:
+		return "LocalClosed"
+	}
+	return "Unknown"
+}
+
+// ConnStateEvent the event published when the client state changed
+type ConnStateEvent struct {
+	State    ConnState
+	Reason   ConnStateReason
+	Err      error    // 导致状态变化的错误,可为nil
+	Endpoint *url.URL // 相关的端点,可为nil
+	Time     time.Time
+}
+
+// SetStateHandler set the handler called on each state changed, need non-blocking
+func (sf *Client) SetStateHandler(f func(c *Client, ev ConnStateEvent)) *Client {
+	sf.stateMux.Lock()
+	sf.onStateChange = f
+	sf.stateMux.Unlock()
+	return sf
+}
+
+// Subscribe returns a channel receiving the state events, the event is dropped
+// if the channel is full. call cancel to unsubscribe.
+func (sf *Client) Subscribe(size int) (events <-chan ConnStateEvent, cancel func()) {
+	ch := make(chan ConnStateEvent, size)
+	sf.stateMux.Lock()
+	if sf.subscribers == nil {
+		sf.subscribers = make(map[chan ConnStateEvent]struct{})
+	}
+	sf.subscribers[ch] = struct{}{}
+	sf.stateMux.Unlock()
+	return ch, func() {
+		sf.stateMux.Lock()
+		delete(sf.subscribers, ch)
+		sf.stateMux.Unlock()
+	}
+}
+
+// State returns the current state of client
+func (sf *Client) State() ConnState {
+	sf.stateMux.Lock()
+	defer sf.stateMux.Unlock()
+	return sf.state
+}
+
+// setState change the state and publish the event
+func (sf *Client) setState(state ConnState, reason ConnStateReason, err error, endpoint *url.URL) {
+	ev := ConnStateEvent{state, reason, err, endpoint, time.Now()}
+
+	sf.stateMux.Lock()
+	sf.state = state
+	f := sf.onStateChange
+	for ch := range sf.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	sf.stateMux.Unlock()
+
+	sf.Debug("state %s, reason %s", state, reason)
+	if f != nil {
+		f(sf, ev)
+	}
+}
+
+// setLostReason record the reason why the connection lost, only the first one is kept
+func (sf *Client) setLostReason(reason ConnStateReason, err error) {
+	sf.stateMux.Lock()
+	if sf.lostReason == ReasonNone {
+		sf.lostReason, sf.lostErr = reason, err
+	}
+	sf.stateMux.Unlock()
+}
+
+// takeLostReason return the reason recorded and reset it
+func (sf *Client) takeLostReason() (ConnStateReason, error) {
+	sf.stateMux.Lock()
+	defer sf.stateMux.Unlock()
+	reason, err := sf.lostReason, sf.lostErr
+	sf.lostReason, sf.lostErr = ReasonNone, nil
+	return reason, err
+}
@@ -0,0 +1,358 @@
+package cs104
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+type nopClientHandler struct{}
+
+func (nopClientHandler) InterrogationHandler(asdu.Connect, *asdu.ASDU) error        { return nil }
+func (nopClientHandler) CounterInterrogationHandler(asdu.Connect, *asdu.ASDU) error { return nil }
+func (nopClientHandler) ReadHandler(asdu.Connect, *asdu.ASDU) error                 { return nil }
+func (nopClientHandler) TestCommandHandler(asdu.Connect, *asdu.ASDU) error          { return nil }
+func (nopClientHandler) ClockSyncHandler(asdu.Connect, *asdu.ASDU) error            { return nil }
+func (nopClientHandler) ResetProcessHandler(asdu.Connect, *asdu.ASDU) error         { return nil }
+func (nopClientHandler) DelayAcquisitionHandler(asdu.Connect, *asdu.ASDU) error     { return nil }
+func (nopClientHandler) ASDUHandler(asdu.Connect, *asdu.ASDU) error                 { return nil }
+
+// deadAddr return a local address which refuses connection
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listen.Addr().String()
+	require.NoError(t, listen.Close())
+	return addr
+}
+
+func TestClient_Failover(t *testing.T) {
+	dead := deadAddr(t)
+	addr := startTestServer(t, NewServer(nopServerHandler{}))
+
+	for _, strategy := range []FailoverStrategy{FailoverSticky, FailoverRoundRobin, FailoverProbe} {
+		t.Run(strategy.String(), func(t *testing.T) {
+			o := NewOption().SetFailoverStrategy(strategy).SetReconnectInterval(100 * time.Millisecond)
+			require.NoError(t, o.AddRemoteServer(dead))
+			require.NoError(t, o.AddRemoteServer(addr))
+
+			var mu sync.Mutex
+			var endpoint *url.URL
+			c := NewClient(nopClientHandler{}, o)
+			c.SetEndpointChangeHandler(func(c *Client, u *url.URL) {
+				mu.Lock()
+				endpoint = u
+				mu.Unlock()
+			})
+			require.NoError(t, c.Start())
+			defer c.Close()
+
+			require.Eventually(t, c.IsConnected, 3*time.Second, 10*time.Millisecond)
+			assert.Equal(t, addr, c.ActiveEndpoint().Host)
+			mu.Lock()
+			assert.Equal(t, addr, endpoint.Host)
+			mu.Unlock()
+		})
+	}
+}
+
+func TestClient_Standby(t *testing.T) {
+	srvA := NewServer(nopServerHandler{})
+	addrA := startTestServer(t, srvA)
+	srvB := NewServer(nopServerHandler{})
+	addrB := startTestServer(t, srvB)
+
+	o := NewOption().SetStandby(true).SetReconnectInterval(100 * time.Millisecond)
+	require.NoError(t, o.AddRemoteServer(addrA))
+	require.NoError(t, o.AddRemoteServer(addrB))
+	c := NewClient(nopClientHandler{}, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	require.NoError(t, c.Start())
+	defer c.Close()
+
+	require.Eventually(t, c.GetActiveStatus, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, addrA, c.ActiveEndpoint().Host)
+	// wait the standby connection established
+	require.Eventually(t, func() bool {
+		srvB.mux.Lock()
+		defer srvB.mux.Unlock()
+		return len(srvB.sessions) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, srvA.Close())
+	require.Eventually(t, func() bool {
+		u := c.ActiveEndpoint()
+		return u != nil && u.Host == addrB && c.GetActiveStatus()
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// the standby connection is taken over, no new connection dialed
+	srvB.mux.Lock()
+	assert.Len(t, srvB.sessions, 1)
+	srvB.mux.Unlock()
+}
+
+func TestClient_StandbyBeforeLost(t *testing.T) {
+	srvA := NewServer(nopServerHandler{})
+	addrA := startTestServer(t, srvA)
+	srvB := NewServer(nopServerHandler{})
+	addrB := startTestServer(t, srvB)
+
+	o := NewOption().SetStandby(true).SetReconnectInterval(100 * time.Millisecond)
+	require.NoError(t, o.AddRemoteServer(addrA))
+	require.NoError(t, o.AddRemoteServer(addrB))
+	c := NewClient(nopClientHandler{}, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	require.NoError(t, c.Start())
+	defer c.Close()
+
+	require.Eventually(t, c.GetActiveStatus, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, addrA, c.ActiveEndpoint().Host)
+	require.Eventually(t, func() bool {
+		srvB.mux.Lock()
+		defer srvB.mux.Unlock()
+		return len(srvB.sessions) == 1
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// the primary still accepts, but the standby is taken over before re-dialing it
+	sessions := srvA.Sessions()
+	require.Len(t, sessions, 1)
+	require.NoError(t, srvA.DisconnectSession(sessions[0].ID()))
+	require.Eventually(t, func() bool {
+		u := c.ActiveEndpoint()
+		return u != nil && u.Host == addrB && c.GetActiveStatus()
+	}, 3*time.Second, 10*time.Millisecond)
+	srvB.mux.Lock()
+	assert.Len(t, srvB.sessions, 1)
+	srvB.mux.Unlock()
+}
+
+func TestClient_StandbyBackoff(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	addrA := startTestServer(t, srv)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addrB := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	var mu sync.Mutex
+	var dials int
+	o := NewOption().SetStandby(true).SetReconnectInterval(10 * time.Millisecond).
+		SetDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+			if address == addrB {
+				mu.Lock()
+				dials++
+				mu.Unlock()
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}).
+		SetBackoff(BackoffFunc(func(int) time.Duration { return time.Hour }))
+	require.NoError(t, o.AddRemoteServer(addrA))
+	require.NoError(t, o.AddRemoteServer(addrB))
+	c := NewClient(nopClientHandler{}, o)
+	require.NoError(t, c.Start())
+	defer c.Close()
+
+	require.Eventually(t, c.IsConnected, 3*time.Second, 10*time.Millisecond)
+	// the standby pool ticks every second, the dead endpoint is dialed once then backed off
+	time.Sleep(2500 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 1, dials)
+	mu.Unlock()
+}
+
+func TestClient_SendDuringReconnect(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	addr := startTestServer(t, srv)
+
+	o := NewOption().SetReconnectInterval(10 * time.Millisecond)
+	require.NoError(t, o.AddRemoteServer(addr))
+	c := NewClient(nopClientHandler{}, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	require.NoError(t, c.Start())
+	defer c.Close()
+	require.Eventually(t, c.GetActiveStatus, 3*time.Second, 10*time.Millisecond)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_ = c.Send(singlePoint(t, 1))
+		}
+	}()
+	// the connection is replaced while sending, checked by the race detector
+	for i := 0; i < 3; i++ {
+		for _, s := range srv.Sessions() {
+			_ = srv.DisconnectSession(s.ID())
+		}
+		require.Eventually(t, func() bool { return len(srv.Sessions()) == 1 && c.GetActiveStatus() },
+			3*time.Second, 10*time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestClient_StateEvents(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listen.Close()
+	go func() {
+		conn, err := listen.Accept()
+		if err != nil {
+			return
+		}
+		// confirm STARTDT then close the connection
+		buf := make([]byte, 6)
+		_, _ = conn.Read(buf)
+		_, _ = conn.Write(NewUFrame(UStartDtConfirm))
+		time.Sleep(50 * time.Millisecond)
+		_ = conn.Close()
+	}()
+
+	o := NewOption().SetAutoReconnect(false)
+	require.NoError(t, o.AddRemoteServer(listen.Addr().String()))
+	c := NewClient(nopClientHandler{}, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	events, cancel := c.Subscribe(16)
+	defer cancel()
+	require.NoError(t, c.Start())
+	defer c.Close()
+
+	next := func() ConnStateEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(3 * time.Second):
+			t.Fatal("wait state event timeout")
+		}
+		return ConnStateEvent{}
+	}
+
+	assert.Equal(t, StateDialing, next().State)
+	assert.Equal(t, StateConnected, next().State)
+	assert.Equal(t, StateStartDtPending, next().State)
+	ev := next()
+	assert.Equal(t, StateActive, ev.State)
+	assert.Equal(t, listen.Addr().String(), ev.Endpoint.Host)
+	ev = next()
+	assert.Equal(t, StateLost, ev.State)
+	assert.Equal(t, ReasonRemoteClosed, ev.Reason)
+
+	// the listener closed, dial failed
+	require.NoError(t, listen.Close())
+	assert.Equal(t, StateDialing, next().State)
+	ev = next()
+	assert.Equal(t, StateLost, ev.State)
+	assert.Equal(t, ReasonDialError, ev.Reason)
+	assert.Equal(t, StateClosed, next().State)
+	assert.Equal(t, StateClosed, c.State())
+}
+
+func TestClient_DialerAndBackoff(t *testing.T) {
+	addr := startTestServer(t, NewServer(nopServerHandler{}))
+
+	var mu sync.Mutex
+	var dials int
+	var attempts []int
+	o := NewOption().
+		SetDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+			mu.Lock()
+			dials++
+			n := dials
+			mu.Unlock()
+			if n <= 2 {
+				return nil, errors.New("dial refused")
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}).
+		SetBackoff(BackoffFunc(func(attempt int) time.Duration {
+			mu.Lock()
+			attempts = append(attempts, attempt)
+			mu.Unlock()
+			return 10 * time.Millisecond
+		}))
+	require.NoError(t, o.AddRemoteServer(addr))
+	c := NewClient(nopClientHandler{}, o)
+	require.NoError(t, c.Start())
+	defer c.Close()
+
+	require.Eventually(t, c.IsConnected, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 3, dials)
+	assert.Equal(t, []int{1, 2}, attempts)
+	mu.Unlock()
+}
+
+type interrogationServerHandler struct{ nopServerHandler }
+
+func (interrogationServerHandler) InterrogationHandler(c asdu.Connect, a *asdu.ASDU, _ asdu.QualifierOfInterrogation) error {
+	return asdu.Single(c, false, asdu.CauseOfTransmission{Cause: asdu.InterrogatedByStation}, a.CommonAddr,
+		asdu.SinglePointInfo{Ioa: 100, Value: true})
+}
+
+type recordClientHandler struct {
+	nopClientHandler
+	received chan *asdu.ASDU
+}
+
+func (sf recordClientHandler) ASDUHandler(_ asdu.Connect, a *asdu.ASDU) error {
+	sf.received <- a
+	return nil
+}
+
+func TestClient_OverPipe(t *testing.T) {
+	srv := NewServer(interrogationServerHandler{})
+	defer srv.Close()
+
+	handler := recordClientHandler{received: make(chan *asdu.ASDU, 8)}
+	o := NewOption().SetConnFactory(func(ctx context.Context, endpoint *url.URL) (net.Conn, error) {
+		cli, ser := net.Pipe()
+		go srv.ServeConn(ser)
+		return cli, nil
+	})
+	c := NewClient(handler, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	require.NoError(t, c.Start())
+	defer c.Close()
+	require.Eventually(t, c.GetActiveStatus, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "conn", c.ActiveEndpoint().Scheme)
+
+	require.NoError(t, c.InterrogationCmd(asdu.CauseOfTransmission{Cause: asdu.Activation}, 1, asdu.QOIStation))
+	select {
+	case a := <-handler.received:
+		assert.Equal(t, asdu.M_SP_NA_1, a.Type)
+		assert.Equal(t, asdu.InterrogatedByStation, a.Coa.Cause)
+	case <-time.After(time.Second):
+		t.Fatal("wait asdu timeout")
+	}
+}
+
+func TestServer_ListenUnix(t *testing.T) {
+	path := t.TempDir() + "/iec104.sock"
+	srv := NewServer(nopServerHandler{})
+	require.NoError(t, srv.ListenAndServer("unix://"+path))
+	defer srv.Close()
+
+	o := NewOption()
+	require.NoError(t, o.AddRemoteServer("unix://"+path))
+	c := NewClient(nopClientHandler{}, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	require.NoError(t, c.Start())
+	defer c.Close()
+	require.Eventually(t, c.GetActiveStatus, time.Second, 10*time.Millisecond)
+}
@@ -5,6 +5,7 @@
 package cs104
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"net"
@@ -15,21 +16,129 @@ import (
 // DefaultReconnectInterval defined default value
 const DefaultReconnectInterval = 1 * time.Minute
 
+// OverflowPolicy defines how Send behaves when the send buffer is full
+type OverflowPolicy uint8
+
+// overflow policy defined
+const (
+	// OverflowDropNewest 丢弃当前要发送的数据,并返回 ErrBufferFulled(默认)
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest 丢弃缓冲区中最早的数据,为当前数据腾出空间
+	OverflowDropOldest
+	// OverflowBlock 阻塞直到缓冲区有空间或连接断开
+	OverflowBlock
+	// OverflowDisconnect 断开当前连接,并返回 ErrBufferFulled
+	OverflowDisconnect
+)
+
+// String returns the name of overflow policy
+func (sf OverflowPolicy) String() string {
+	switch sf {
+	case OverflowDropNewest:
+		return "DropNewest"
+	case OverflowDropOldest:
+		return "DropOldest"
+	case OverflowBlock:
+		return "Block"
+	case OverflowDisconnect:
+		return "Disconnect"
+	}
+	return "Unknown"
+}
+
 type seqPending struct {
 	seq      uint16
 	sendTime time.Time
+	eventID  uint64 // 对应的缓存事件id, 0 表示非缓存事件
 }
 
-func openConnection(uri *url.URL, tlsc *tls.Config, timeout time.Duration) (net.Conn, error) {
+// DialContextFunc dial the address on the named network, as net.Dialer.DialContext.
+// it can be used to bind a source address, set socket options or go through a proxy.
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// ConnFactory open the connection to the endpoint, it replaces the built-in dialing,
+// so the endpoint scheme can be anything, e.g. pipe://, ssh://.
+// it can be used to run the session over net.Pipe, a tunnel or a wrapped connection.
+type ConnFactory func(ctx context.Context, endpoint *url.URL) (net.Conn, error)
+
+// openConnection open the connection to uri within t₀,
+// with the conn factory or the dial hook if set.
+func (sf *ClientOption) openConnection(ctx context.Context, uri *url.URL) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, sf.config.ConnectTimeout0)
+	defer cancel()
+	if sf.factory != nil {
+		return sf.factory(ctx, uri)
+	}
+	dial := sf.dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
 	switch uri.Scheme {
 	case "tcp":
-		return net.DialTimeout("tcp", uri.Host, timeout)
-	case "ssl":
-		fallthrough
-	case "tls":
-		fallthrough
-	case "tcps":
-		return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", uri.Host, tlsc)
+		return dial(ctx, "tcp", uri.Host)
+	case "unix":
+		return dial(ctx, "unix", uri.Path)
+	case "ssl", "tls", "tcps":
+		conn, err := dial(ctx, "tcp", uri.Host)
+		if err != nil {
+			return nil, err
+		}
+		cfg := &tls.Config{}
+		if sf.TLSConfig != nil {
+			cfg = sf.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = uri.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
 	}
 	return nil, errors.New("unknown protocol")
 }
+
+// enqueue put data into the send buffer according to the overflow policy.
+// done is the connection context, when it done return ErrUseClosedConnection,
+// disconnect is called when the policy is OverflowDisconnect and the buffer is full.
+func enqueue(ctx, done context.Context, ch chan []byte, data []byte, policy OverflowPolicy, disconnect func()) error {
+	select {
+	case ch <- data:
+		return nil
+	default:
+	}
+
+	switch policy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case <-ch: // drop oldest
+			default:
+			}
+			select {
+			case ch <- data:
+				return nil
+			case <-done.Done():
+				return ErrUseClosedConnection
+			default:
+			}
+		}
+	case OverflowBlock:
+		select {
+		case ch <- data:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done.Done():
+			return ErrUseClosedConnection
+		}
+	case OverflowDisconnect:
+		if disconnect != nil {
+			disconnect()
+		}
+	}
+	return ErrBufferFulled
+}
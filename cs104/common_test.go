@@ -0,0 +1,68 @@
+package cs104
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_enqueue(t *testing.T) {
+	t.Run("drop newest", func(t *testing.T) {
+		ch := make(chan []byte, 1)
+		ch <- []byte{0x01}
+		err := enqueue(context.Background(), context.Background(), ch, []byte{0x02}, OverflowDropNewest, nil)
+		assert.Equal(t, ErrBufferFulled, err)
+		assert.Equal(t, []byte{0x01}, <-ch)
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		ch := make(chan []byte, 2)
+		ch <- []byte{0x01}
+		ch <- []byte{0x02}
+		err := enqueue(context.Background(), context.Background(), ch, []byte{0x03}, OverflowDropOldest, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0x02}, <-ch)
+		assert.Equal(t, []byte{0x03}, <-ch)
+	})
+
+	t.Run("block until free", func(t *testing.T) {
+		ch := make(chan []byte, 1)
+		ch <- []byte{0x01}
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			<-ch
+		}()
+		err := enqueue(context.Background(), context.Background(), ch, []byte{0x02}, OverflowBlock, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0x02}, <-ch)
+	})
+
+	t.Run("block until ctx done", func(t *testing.T) {
+		ch := make(chan []byte, 1)
+		ch <- []byte{0x01}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := enqueue(ctx, context.Background(), ch, []byte{0x02}, OverflowBlock, nil)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("block until connection done", func(t *testing.T) {
+		ch := make(chan []byte, 1)
+		ch <- []byte{0x01}
+		done, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := enqueue(context.Background(), done, ch, []byte{0x02}, OverflowBlock, nil)
+		assert.Equal(t, ErrUseClosedConnection, err)
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		ch := make(chan []byte, 1)
+		ch <- []byte{0x01}
+		disconnected := false
+		err := enqueue(context.Background(), context.Background(), ch, []byte{0x02}, OverflowDisconnect, func() { disconnected = true })
+		assert.Equal(t, ErrBufferFulled, err)
+		assert.True(t, disconnected)
+	})
+}
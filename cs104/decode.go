@@ -0,0 +1,295 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+// DecodedAPDU the description of an APDU, the fields are set according to the format
+type DecodedAPDU struct {
+	Format   FrameFormat  `json:"format"`
+	SendSN   uint16       `json:"send_sn,omitempty"`  // N(S) of the I-frame
+	RcvSN    uint16       `json:"rcv_sn,omitempty"`   // N(R) of the I-frame and the S-frame
+	Function string       `json:"function,omitempty"` // function of the U-frame, such as StartDtActive
+	ASDU     *DecodedASDU `json:"asdu,omitempty"`     // asdu of the I-frame
+}
+
+func (sf *DecodedAPDU) String() string {
+	switch sf.Format {
+	case FrameI:
+		s := IAPCI{sf.SendSN, sf.RcvSN}.String()
+		if sf.ASDU != nil {
+			s += " " + sf.ASDU.String()
+		}
+		return s
+	case FrameS:
+		return SAPCI{sf.RcvSN}.String()
+	default:
+		return fmt.Sprintf("U[function: %s]", sf.Function)
+	}
+}
+
+// DecodedASDU the description of an ASDU
+type DecodedASDU struct {
+	ASDU            *asdu.ASDU `json:"-"`
+	CauseSize       int        `json:"cot_size"`
+	CommonAddrSize  int        `json:"ca_size"`
+	InfoObjAddrSize int        `json:"ioa_size"`
+	Guessed         bool       `json:"guessed,omitempty"` // the sizes are guessed
+
+	Type       asdu.TypeID     `json:"type"`
+	TypeName   string          `json:"type_name"`
+	Sequence   bool            `json:"sequence,omitempty"`
+	Number     byte            `json:"number"`
+	Cause      string          `json:"cause"`
+	Negative   bool            `json:"negative,omitempty"`
+	Test       bool            `json:"test,omitempty"`
+	OrigAddr   asdu.OriginAddr `json:"orig_addr,omitempty"`
+	CommonAddr asdu.CommonAddr `json:"common_addr"`
+	Objects    interface{}     `json:"objects,omitempty"` // the slice of the info of asdu getters, or InfoObjValue
+	Error      string          `json:"error,omitempty"`   // the information objects failed to decode
+}
+
+func (sf *DecodedASDU) String() string {
+	s := fmt.Sprintf("%s %s", sf.TypeName, sf.Cause)
+	if sf.Negative {
+		s += " negative"
+	}
+	if sf.Test {
+		s += " test"
+	}
+	s += fmt.Sprintf(" %d@%d", sf.OrigAddr, sf.CommonAddr)
+	if sf.Error != "" {
+		return s + " error: " + sf.Error
+	}
+	return s + fmt.Sprintf(" %+v", sf.Objects)
+}
+
+// InfoObjValue the information object of the commands and system information
+type InfoObjValue struct {
+	Ioa   asdu.InfoObjAddr `json:"ioa"`
+	Value interface{}      `json:"value,omitempty"`
+	Time  *time.Time       `json:"time,omitempty"`
+}
+
+// guessCandidates the sizes of cause, common address and information object address
+// tried in order when guessing the params, the common configurations first.
+var guessCandidates = [][3]int{
+	{2, 2, 3}, {1, 1, 2}, {1, 2, 3}, {2, 2, 2}, {1, 1, 1}, {1, 2, 2},
+	{2, 1, 2}, {2, 1, 3}, {1, 1, 3}, {2, 2, 1}, {1, 2, 1}, {2, 1, 1},
+}
+
+// DecodeAPDU decode an APDU, the asdu params are guessed.
+func DecodeAPDU(apdu []byte) (*DecodedAPDU, error) {
+	return DecodeAPDUWithParams(apdu, nil)
+}
+
+// DecodeAPDUWithParams decode an APDU with the asdu params, the sizes which are nil or 0 are guessed.
+// If the asdu of the I-frame failed to decode, the APCI is still returned with the error.
+func DecodeAPDUWithParams(apdu []byte, params *asdu.Params) (*DecodedAPDU, error) {
+	if len(apdu) < APCICtlFiledSize+2 || apdu[0] != startFrame {
+		return nil, fmt.Errorf("%w: bad start or too short", ErrInvalidAPDU)
+	}
+	if int(apdu[1]) != len(apdu)-2 {
+		return nil, fmt.Errorf("%w: length %d mismatch %d bytes", ErrInvalidAPDU, apdu[1], len(apdu)-2)
+	}
+	d := &DecodedAPDU{}
+	head, raw := ParseAPDU(apdu)
+	switch v := head.(type) {
+	case IAPCI:
+		d.Format, d.SendSN, d.RcvSN = FrameI, v.SendSN, v.RcvSN
+		a, err := DecodeASDU(raw, params)
+		if err != nil {
+			return d, err
+		}
+		d.ASDU = a
+	case SAPCI:
+		d.Format, d.RcvSN = FrameS, v.RcvSN
+	case UAPCI:
+		d.Format, d.Function = FrameU, uFunctionName(v.Function)
+	}
+	if d.Format != FrameI && len(raw) > 0 {
+		return d, fmt.Errorf("%w: %s-frame with %d bytes asdu", ErrInvalidAPDU, d.Format, len(raw))
+	}
+	return d, nil
+}
+
+// DecodeASDU decode an ASDU with the params, the sizes which are nil or 0 are guessed.
+// The guess prefers the sizes that the information objects fill the asdu exactly.
+func DecodeASDU(raw []byte, params *asdu.Params) (*DecodedASDU, error) {
+	var hint asdu.Params
+	if params != nil {
+		hint = *params
+	}
+	if hint.InfoObjTimeZone == nil {
+		hint.InfoObjTimeZone = time.UTC
+	}
+	if hint.CauseSize != 0 && hint.CommonAddrSize != 0 && hint.InfoObjAddrSize != 0 {
+		return decodeASDU(raw, hint, false)
+	}
+
+	var candidates []asdu.Params
+	for _, c := range guessCandidates {
+		if (hint.CauseSize == 0 || hint.CauseSize == c[0]) &&
+			(hint.CommonAddrSize == 0 || hint.CommonAddrSize == c[1]) &&
+			(hint.InfoObjAddrSize == 0 || hint.InfoObjAddrSize == c[2]) {
+			p := hint
+			p.CauseSize, p.CommonAddrSize, p.InfoObjAddrSize = c[0], c[1], c[2]
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, asdu.ErrParam
+	}
+	for _, p := range candidates {
+		if fitASDU(raw, &p) {
+			if d, err := decodeASDU(raw, p, true); err == nil && d.Error == "" {
+				return d, nil
+			}
+		}
+	}
+	for _, p := range candidates {
+		if d, err := decodeASDU(raw, p, true); err == nil {
+			return d, nil
+		}
+	}
+	return decodeASDU(raw, candidates[0], true)
+}
+
+// fitASDU whether the information objects fill the asdu exactly with the params
+func fitASDU(raw []byte, p *asdu.Params) bool {
+	if len(raw) < 2 {
+		return false
+	}
+	objSize, err := asdu.GetInfoObjSize(asdu.TypeID(raw[0]))
+	if err != nil {
+		return false
+	}
+	v := asdu.ParseVariableStruct(raw[1])
+	size := int(v.Number) * (p.InfoObjAddrSize + objSize)
+	if v.IsSequence {
+		size = p.InfoObjAddrSize + int(v.Number)*objSize
+	}
+	return v.Number > 0 && p.IdentifierSize()+size == len(raw)
+}
+
+func decodeASDU(raw []byte, p asdu.Params, guessed bool) (*DecodedASDU, error) {
+	a := asdu.NewEmptyASDU(&p)
+	if err := a.UnmarshalBinary(raw); err != nil {
+		return nil, err
+	}
+	d := &DecodedASDU{
+		ASDU:            a,
+		CauseSize:       p.CauseSize,
+		CommonAddrSize:  p.CommonAddrSize,
+		InfoObjAddrSize: p.InfoObjAddrSize,
+		Guessed:         guessed,
+		Type:            a.Type,
+		TypeName:        a.Type.String(),
+		Sequence:        a.Variable.IsSequence,
+		Number:          a.Variable.Number,
+		Cause:           a.Coa.Cause.String(),
+		Negative:        a.Coa.IsNegative,
+		Test:            a.Coa.IsTest,
+		OrigAddr:        a.OrigAddr,
+		CommonAddr:      a.CommonAddr,
+	}
+	objs, err := decodeObjects(a.Clone())
+	if err != nil {
+		d.Error = err.Error()
+	} else {
+		d.Objects = objs
+	}
+	return d, nil
+}
+
+// decodeObjects decode the information objects by the type
+func decodeObjects(a *asdu.ASDU) (objs interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("decode %s failed, %v", a.Type, r)
+		}
+	}()
+
+	switch a.Type {
+	case asdu.M_SP_NA_1, asdu.M_SP_TA_1, asdu.M_SP_TB_1:
+		return a.GetSinglePoint(), nil
+	case asdu.M_DP_NA_1, asdu.M_DP_TA_1, asdu.M_DP_TB_1:
+		return a.GetDoublePoint(), nil
+	case asdu.M_ST_NA_1, asdu.M_ST_TA_1, asdu.M_ST_TB_1:
+		return a.GetStepPosition(), nil
+	case asdu.M_BO_NA_1, asdu.M_BO_TA_1, asdu.M_BO_TB_1:
+		return a.GetBitString32(), nil
+	case asdu.M_ME_NA_1, asdu.M_ME_TA_1, asdu.M_ME_TD_1, asdu.M_ME_ND_1:
+		return a.GetMeasuredValueNormal(), nil
+	case asdu.M_ME_NB_1, asdu.M_ME_TB_1, asdu.M_ME_TE_1:
+		return a.GetMeasuredValueScaled(), nil
+	case asdu.M_ME_NC_1, asdu.M_ME_TC_1, asdu.M_ME_TF_1:
+		return a.GetMeasuredValueFloat(), nil
+	case asdu.M_IT_NA_1, asdu.M_IT_TA_1, asdu.M_IT_TB_1:
+		return a.GetIntegratedTotals(), nil
+	case asdu.M_EP_TA_1, asdu.M_EP_TD_1:
+		return a.GetEventOfProtectionEquipment(), nil
+	case asdu.M_EP_TB_1, asdu.M_EP_TE_1:
+		return a.GetPackedStartEventsOfProtectionEquipment(), nil
+	case asdu.M_EP_TC_1, asdu.M_EP_TF_1:
+		return a.GetPackedOutputCircuitInfo(), nil
+	case asdu.M_PS_NA_1:
+		return a.GetPackedSinglePointWithSCD(), nil
+	case asdu.M_EI_NA_1:
+		ioa, coi := a.GetEndOfInitialization()
+		return InfoObjValue{Ioa: ioa, Value: coi}, nil
+	case asdu.C_SC_NA_1, asdu.C_SC_TA_1:
+		return a.GetSingleCmd(), nil
+	case asdu.C_DC_NA_1, asdu.C_DC_TA_1:
+		return a.GetDoubleCmd(), nil
+	case asdu.C_RC_NA_1, asdu.C_RC_TA_1:
+		return a.GetStepCmd(), nil
+	case asdu.C_SE_NA_1, asdu.C_SE_TA_1:
+		return a.GetSetpointNormalCmd(), nil
+	case asdu.C_SE_NB_1, asdu.C_SE_TB_1:
+		return a.GetSetpointCmdScaled(), nil
+	case asdu.C_SE_NC_1, asdu.C_SE_TC_1:
+		return a.GetSetpointFloatCmd(), nil
+	case asdu.C_BO_NA_1, asdu.C_BO_TA_1:
+		return a.GetBitsString32Cmd(), nil
+	case asdu.C_IC_NA_1:
+		ioa, q := a.GetInterrogationCmd()
+		return InfoObjValue{Ioa: ioa, Value: q}, nil
+	case asdu.C_CI_NA_1:
+		ioa, q := a.GetCounterInterrogationCmd()
+		return InfoObjValue{Ioa: ioa, Value: q}, nil
+	case asdu.C_RD_NA_1:
+		return InfoObjValue{Ioa: a.GetReadCmd()}, nil
+	case asdu.C_CS_NA_1:
+		ioa, t := a.GetClockSynchronizationCmd()
+		return InfoObjValue{Ioa: ioa, Time: &t}, nil
+	case asdu.C_TS_NA_1:
+		ioa, v := a.GetTestCommand()
+		return InfoObjValue{Ioa: ioa, Value: v}, nil
+	case asdu.C_RP_NA_1:
+		ioa, q := a.GetResetProcessCmd()
+		return InfoObjValue{Ioa: ioa, Value: q}, nil
+	case asdu.C_CD_NA_1:
+		ioa, msec := a.GetDelayAcquireCommand()
+		return InfoObjValue{Ioa: ioa, Value: msec}, nil
+	case asdu.C_TS_TA_1:
+		ioa, v, t := a.GetTestCommandCP56Time2a()
+		return InfoObjValue{Ioa: ioa, Value: v, Time: &t}, nil
+	case asdu.P_ME_NA_1:
+		return a.GetParameterNormal(), nil
+	case asdu.P_ME_NB_1:
+		return a.GetParameterScaled(), nil
+	case asdu.P_ME_NC_1:
+		return a.GetParameterFloat(), nil
+	case asdu.P_AC_NA_1:
+		return a.GetParameterActivation(), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", a.Type)
+}
@@ -0,0 +1,91 @@
+package cs104
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+func TestDecodeAPDU(t *testing.T) {
+	t.Run("S/U", func(t *testing.T) {
+		d, err := DecodeAPDU(NewSFrame(10))
+		require.NoError(t, err)
+		assert.Equal(t, &DecodedAPDU{Format: FrameS, RcvSN: 10}, d)
+		assert.Equal(t, "S[recvNO: 10]", d.String())
+
+		d, err = DecodeAPDU(NewUFrame(UTestFrActive))
+		require.NoError(t, err)
+		assert.Equal(t, &DecodedAPDU{Format: FrameU, Function: "TestFrActive"}, d)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, b := range [][]byte{
+			{0x68, 0x04, 0x07},
+			{0x67, 0x04, 0x07, 0x00, 0x00, 0x00},
+			{0x68, 0x05, 0x07, 0x00, 0x00, 0x00},
+			{0x68, 0x05, 0x07, 0x00, 0x00, 0x00, 0x01},
+		} {
+			_, err := DecodeAPDU(b)
+			assert.True(t, errors.Is(err, ErrInvalidAPDU), "% x", b)
+		}
+	})
+
+	for _, params := range []*asdu.Params{asdu.ParamsWide, {CauseSize: 1, CommonAddrSize: 1, InfoObjAddrSize: 2}} {
+		// M_ME_NC_1 两个不连续对象
+		a := asdu.NewASDU(params, asdu.Identifier{Type: asdu.M_ME_NC_1, Coa: asdu.CauseOfTransmission{Cause: asdu.Periodic}, CommonAddr: 7})
+		a.Variable.Number = 2
+		require.NoError(t, a.AppendInfoObjAddr(100))
+		a.AppendFloat32(1.5).AppendBytes(0)
+		require.NoError(t, a.AppendInfoObjAddr(200))
+		a.AppendFloat32(-2).AppendBytes(0)
+		raw, err := a.MarshalBinary()
+		require.NoError(t, err)
+		apdu, err := NewIFrame(3, 5, raw)
+		require.NoError(t, err)
+
+		d, err := DecodeAPDU(apdu)
+		require.NoError(t, err)
+		assert.Equal(t, FrameI, d.Format)
+		assert.Equal(t, uint16(3), d.SendSN)
+		assert.Equal(t, uint16(5), d.RcvSN)
+		require.NotNil(t, d.ASDU)
+		assert.True(t, d.ASDU.Guessed)
+		assert.Equal(t, []int{params.CauseSize, params.CommonAddrSize, params.InfoObjAddrSize},
+			[]int{d.ASDU.CauseSize, d.ASDU.CommonAddrSize, d.ASDU.InfoObjAddrSize})
+		assert.Equal(t, asdu.CommonAddr(7), d.ASDU.CommonAddr)
+		assert.Equal(t, "Periodic", d.ASDU.Cause)
+		objs, ok := d.ASDU.Objects.([]asdu.MeasuredValueFloatInfo)
+		require.True(t, ok)
+		require.Len(t, objs, 2)
+		assert.Equal(t, asdu.InfoObjAddr(200), objs[1].Ioa)
+		assert.Equal(t, float32(-2), objs[1].Value)
+
+		// 指定参数
+		d, err = DecodeAPDUWithParams(apdu, params)
+		require.NoError(t, err)
+		assert.False(t, d.ASDU.Guessed)
+
+		b, err := json.Marshal(d)
+		require.NoError(t, err)
+		var got DecodedAPDU
+		require.NoError(t, json.Unmarshal(b, &got))
+		assert.Equal(t, FrameI, got.Format)
+	}
+
+	// ioa size given, cot and ca guessed
+	a := asdu.NewASDU(asdu.ParamsWide, asdu.Identifier{Type: asdu.C_IC_NA_1, Coa: asdu.CauseOfTransmission{Cause: asdu.Activation}, CommonAddr: 1})
+	require.NoError(t, a.AppendInfoObjAddr(0))
+	a.AppendBytes(byte(asdu.QOIStation))
+	a.Variable.Number = 1
+	raw, err := a.MarshalBinary()
+	require.NoError(t, err)
+	d, err := DecodeASDU(raw, &asdu.Params{InfoObjAddrSize: 3})
+	require.NoError(t, err)
+	assert.Equal(t, 2, d.CauseSize)
+	assert.Equal(t, InfoObjValue{Ioa: 0, Value: asdu.QOIStation}, d.Objects)
+}
@@ -6,6 +6,7 @@ package cs104
 
 import (
 	"errors"
+	"fmt"
 )
 
 // error defined
@@ -13,4 +14,29 @@ var (
 	ErrUseClosedConnection = errors.New("use of closed connection")
 	ErrBufferFulled        = errors.New("buffer is full")
 	ErrNotActive           = errors.New("server is not active")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrNotAllowed          = errors.New("connection not allowed")
+	ErrTooManySessions     = errors.New("too many sessions")
+	ErrShuttingDown        = errors.New("shutting down")
+	ErrInvalidAPDU         = errors.New("invalid APDU")
 )
+
+// protocol error defined, the session reports them to the protocol error handler
+var (
+	ErrSequence         = errors.New("sequence number error")
+	ErrT1Timeout        = errors.New("confirmation timeout t₁")
+	ErrUnexpectedUFrame = errors.New("unexpected U-frame")
+	ErrFrameTooLong     = errors.New("frame too long")
+)
+
+// SessionError the error of a session when the server sends to several sessions
+type SessionError struct {
+	ID  uint64 // session id
+	Err error
+}
+
+func (sf *SessionError) Error() string {
+	return fmt.Sprintf("session %d: %v", sf.ID, sf.Err)
+}
+
+func (sf *SessionError) Unwrap() error { return sf.Err }
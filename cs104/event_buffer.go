@@ -0,0 +1,300 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"sync"
+)
+
+// EventBufferStats the snapshot of the outstation event buffer
+type EventBufferStats struct {
+	Len       int            // 当前缓存的事件数(含已发送未确认)
+	InFlight  int            // 已发送但未收到确认的事件数
+	Cap       int            // 缓存容量
+	Policy    OverflowPolicy // 缓存满时的处理策略
+	Pushed    uint64         // 累计缓存的事件数
+	Dropped   uint64         // 累计因缓存满而丢弃的事件数
+	Delivered uint64         // 累计已被主站确认的事件数
+}
+
+type eventEntry struct {
+	id        uint64
+	data      []byte
+	pending   map[*SrvSession]bool // 待发送该事件的会话, 值为true表示已发送,等待I帧确认
+	delivered bool                 // 已被至少一个主站确认
+}
+
+// eventBuffer bounded and ordered buffer for spontaneous events,
+// it keeps the events while no master has data transfer active.
+// each event is delivered to every session registered when it is pushed, an event
+// pushed while no session registered goes to the sessions which register next.
+// an event is removed only after all its sessions acknowledged the I-frame carried it,
+// if all of them lost before any acknowledge, the event will be sent again.
+type eventBuffer struct {
+	mu        sync.Mutex
+	entries   []eventEntry
+	consumers map[*SrvSession]struct{} // 已启动数据传输的会话
+	size      int
+	policy    OverflowPolicy
+	store     EventStore // 持久化存储,可为nil
+	nextID    uint64
+
+	pushed    uint64
+	dropped   uint64
+	delivered uint64
+}
+
+// newEventBuffer new a event buffer, only OverflowDropNewest and OverflowDropOldest
+// are meaningful, others behave as OverflowDropNewest.
+func newEventBuffer(size int, policy OverflowPolicy) *eventBuffer {
+	return &eventBuffer{
+		entries:   make([]eventEntry, 0, size),
+		consumers: make(map[*SrvSession]struct{}),
+		size:      size,
+		policy:    policy,
+	}
+}
+
+// setStore bind the store and load the unacknowledged events from it.
+func (sf *eventBuffer) setStore(store EventStore) error {
+	events, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.store = store
+	for _, ev := range events {
+		if !sf.makeRoom() {
+			_ = store.Remove(ev.ID)
+			continue
+		}
+		sf.entries = append(sf.entries, eventEntry{id: ev.ID, data: ev.Data, pending: sf.claim()})
+		if ev.ID > sf.nextID {
+			sf.nextID = ev.ID
+		}
+	}
+	return nil
+}
+
+// claim return the pending set of a new event with all the registered sessions, must hold the lock.
+func (sf *eventBuffer) claim() map[*SrvSession]bool {
+	pending := make(map[*SrvSession]bool, len(sf.consumers))
+	for s := range sf.consumers {
+		pending[s] = false
+	}
+	return pending
+}
+
+// makeRoom make room for a new event according to the policy, must hold the lock.
+// return false if the new event should be dropped.
+func (sf *eventBuffer) makeRoom() bool {
+	if len(sf.entries) < sf.size {
+		return true
+	}
+	sf.dropped++
+	if sf.policy != OverflowDropOldest || sf.size == 0 {
+		return false
+	}
+	if sf.store != nil {
+		_ = sf.store.Remove(sf.entries[0].id)
+	}
+	sf.entries[0] = eventEntry{}
+	sf.entries = sf.entries[1:]
+	return true
+}
+
+// push append the event to the tail, return ErrBufferFulled if the event dropped.
+func (sf *eventBuffer) push(data []byte) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if len(sf.entries) >= sf.size && (sf.policy != OverflowDropOldest || sf.size == 0) {
+		sf.dropped++
+		return ErrBufferFulled
+	}
+
+	var id uint64
+	if sf.store != nil {
+		var err error
+		// 先持久化成功,再淘汰最旧的事件,避免写入失败时白白丢弃
+		if id, err = sf.store.Append(data); err != nil {
+			return err
+		}
+	} else {
+		sf.nextID++
+		id = sf.nextID
+	}
+	sf.makeRoom()
+	sf.entries = append(sf.entries, eventEntry{id: id, data: data, pending: sf.claim()})
+	sf.pushed++
+	return nil
+}
+
+// register add the session which starts data transfer, it takes over
+// the events no session is waiting for, in order.
+func (sf *eventBuffer) register(s *SrvSession) {
+	if sf == nil {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if _, ok := sf.consumers[s]; ok {
+		return
+	}
+	sf.consumers[s] = struct{}{}
+	for i := range sf.entries {
+		if len(sf.entries[i].pending) == 0 {
+			sf.entries[i].pending[s] = false
+		}
+	}
+}
+
+// unregister remove the session which stops data transfer or disconnects,
+// the events it has not acknowledged and no other session is waiting for
+// go to the registered sessions, or the next session registers, unless
+// another session has acknowledged them.
+func (sf *eventBuffer) unregister(s *SrvSession) {
+	if sf == nil {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if _, ok := sf.consumers[s]; !ok {
+		return
+	}
+	delete(sf.consumers, s)
+	entries := sf.entries[:0]
+	for _, e := range sf.entries {
+		if _, ok := e.pending[s]; ok {
+			delete(e.pending, s)
+			if len(e.pending) == 0 {
+				if e.delivered {
+					_ = sf.remove(e.id)
+					continue
+				}
+				e.pending = sf.claim()
+			}
+		}
+		entries = append(entries, e)
+	}
+	for i := len(entries); i < len(sf.entries); i++ {
+		sf.entries[i] = eventEntry{}
+	}
+	sf.entries = entries
+}
+
+// remove count the event delivered and remove it from the store, must hold the lock.
+func (sf *eventBuffer) remove(id uint64) error {
+	sf.delivered++
+	if sf.store != nil {
+		return sf.store.Remove(id)
+	}
+	return nil
+}
+
+// pop return the oldest event of the session which is not in flight and mark it in flight
+func (sf *eventBuffer) pop(s *SrvSession) (uint64, []byte, bool) {
+	if sf == nil {
+		return 0, nil, false
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for i := range sf.entries {
+		if inFlight, ok := sf.entries[i].pending[s]; ok && !inFlight {
+			sf.entries[i].pending[s] = true
+			return sf.entries[i].id, sf.entries[i].data, true
+		}
+	}
+	return 0, nil, false
+}
+
+// ack the event has been acknowledged by the master of the session,
+// it is removed once all its sessions acknowledged.
+func (sf *eventBuffer) ack(s *SrvSession, id uint64) error {
+	if sf == nil {
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for i := range sf.entries {
+		if sf.entries[i].id != id {
+			continue
+		}
+		if _, ok := sf.entries[i].pending[s]; !ok {
+			return nil
+		}
+		delete(sf.entries[i].pending, s)
+		sf.entries[i].delivered = true
+		if len(sf.entries[i].pending) > 0 {
+			return nil
+		}
+		sf.entries = append(sf.entries[:i], sf.entries[i+1:]...)
+		return sf.remove(id)
+	}
+	return nil
+}
+
+// remaining return the count of events the session has not acknowledged yet
+func (sf *eventBuffer) remaining(s *SrvSession) int {
+	if sf == nil {
+		return 0
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	n := 0
+	for i := range sf.entries {
+		if _, ok := sf.entries[i].pending[s]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// len return the count of buffered events
+func (sf *eventBuffer) len() int {
+	if sf == nil {
+		return 0
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return len(sf.entries)
+}
+
+// persistent return true if the buffer has a store
+func (sf *eventBuffer) persistent() bool {
+	if sf == nil {
+		return false
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.store != nil
+}
+
+func (sf *eventBuffer) stats() EventBufferStats {
+	if sf == nil {
+		return EventBufferStats{}
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	inFlight := 0
+	for i := range sf.entries {
+		for _, v := range sf.entries[i].pending {
+			if v {
+				inFlight++
+				break
+			}
+		}
+	}
+	return EventBufferStats{
+		Len:       len(sf.entries),
+		InFlight:  inFlight,
+		Cap:       sf.size,
+		Policy:    sf.policy,
+		Pushed:    sf.pushed,
+		Dropped:   sf.dropped,
+		Delivered: sf.delivered,
+	}
+}
@@ -0,0 +1,187 @@
+package cs104
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_eventBuffer(t *testing.T) {
+	t.Run("drop newest", func(t *testing.T) {
+		s := &SrvSession{}
+		b := newEventBuffer(2, OverflowDropNewest)
+		b.register(s)
+		assert.NoError(t, b.push([]byte{0x01}))
+		assert.NoError(t, b.push([]byte{0x02}))
+		assert.Equal(t, ErrBufferFulled, b.push([]byte{0x03}))
+
+		id, v, ok := b.pop(s)
+		assert.True(t, ok)
+		assert.Equal(t, []byte{0x01}, v)
+		assert.NoError(t, b.ack(s, id))
+		id, v, ok = b.pop(s)
+		assert.True(t, ok)
+		assert.Equal(t, []byte{0x02}, v)
+		assert.NoError(t, b.ack(s, id))
+		_, _, ok = b.pop(s)
+		assert.False(t, ok)
+		assert.Equal(t, EventBufferStats{Cap: 2, Policy: OverflowDropNewest, Pushed: 2, Dropped: 1, Delivered: 2}, b.stats())
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		s := &SrvSession{}
+		b := newEventBuffer(2, OverflowDropOldest)
+		assert.NoError(t, b.push([]byte{0x01}))
+		assert.NoError(t, b.push([]byte{0x02}))
+		assert.NoError(t, b.push([]byte{0x03}))
+		assert.Equal(t, 2, b.len())
+
+		b.register(s)
+		_, v, _ := b.pop(s)
+		assert.Equal(t, []byte{0x02}, v)
+		_, v, _ = b.pop(s)
+		assert.Equal(t, []byte{0x03}, v)
+		assert.Equal(t, EventBufferStats{Len: 2, InFlight: 2, Cap: 2, Policy: OverflowDropOldest, Pushed: 3, Dropped: 1}, b.stats())
+	})
+
+	t.Run("unregister unacknowledged", func(t *testing.T) {
+		s1, s2 := &SrvSession{}, &SrvSession{}
+		b := newEventBuffer(4, OverflowDropNewest)
+		assert.NoError(t, b.push([]byte{0x01}))
+		assert.NoError(t, b.push([]byte{0x02}))
+
+		_, _, ok := b.pop(s1)
+		assert.False(t, ok, "not registered")
+		b.register(s1)
+		b.pop(s1)
+		b.pop(s1)
+		_, _, ok = b.pop(s1)
+		assert.False(t, ok)
+
+		b.unregister(s1)
+		b.register(s2)
+		_, v, _ := b.pop(s2)
+		assert.Equal(t, []byte{0x01}, v)
+		_, v, _ = b.pop(s2)
+		assert.Equal(t, []byte{0x02}, v)
+		assert.Equal(t, 2, b.remaining(s2))
+		assert.Equal(t, 0, b.remaining(s1))
+
+		// handed over to the registered session
+		assert.NoError(t, b.push([]byte{0x03}))
+		b.register(s1)
+		b.unregister(s2)
+		assert.Equal(t, 3, b.remaining(s1))
+	})
+
+	t.Run("fan out", func(t *testing.T) {
+		s1, s2 := &SrvSession{}, &SrvSession{}
+		b := newEventBuffer(4, OverflowDropNewest)
+		b.register(s1)
+		b.register(s2)
+		assert.NoError(t, b.push([]byte{0x01}))
+		assert.NoError(t, b.push([]byte{0x02}))
+
+		for _, s := range []*SrvSession{s1, s2} {
+			for _, want := range []byte{0x01, 0x02} {
+				_, v, ok := b.pop(s)
+				assert.True(t, ok)
+				assert.Equal(t, []byte{want}, v)
+			}
+		}
+		assert.NoError(t, b.ack(s1, 1))
+		assert.NoError(t, b.ack(s1, 2))
+		assert.Equal(t, 2, b.len(), "s2 has not acknowledged")
+		assert.NoError(t, b.ack(s2, 1))
+		assert.Equal(t, 1, b.len())
+
+		// acknowledged by s1, s2 lost, the event is removed
+		b.unregister(s2)
+		assert.Equal(t, 0, b.len())
+		assert.Equal(t, uint64(2), b.stats().Delivered)
+	})
+
+	t.Run("with store", func(t *testing.T) {
+		s := &SrvSession{}
+		store, err := OpenFileEventStore(t.TempDir() + "/events.log")
+		require.NoError(t, err)
+		defer store.Close()
+		_, err = store.Append([]byte{0x01})
+		require.NoError(t, err)
+
+		b := newEventBuffer(4, OverflowDropNewest)
+		require.NoError(t, b.setStore(store))
+		b.register(s)
+		assert.NoError(t, b.push([]byte{0x02}))
+
+		id, v, _ := b.pop(s)
+		assert.Equal(t, []byte{0x01}, v)
+		assert.NoError(t, b.ack(s, id))
+
+		events, err := store.Load()
+		require.NoError(t, err)
+		assert.Equal(t, []StoredEvent{{ID: 2, Data: []byte{0x02}}}, events)
+	})
+
+	t.Run("store append failed", func(t *testing.T) {
+		s := &SrvSession{}
+		store := &failingEventStore{}
+		b := newEventBuffer(1, OverflowDropOldest)
+		require.NoError(t, b.setStore(store))
+		b.register(s)
+		assert.NoError(t, b.push([]byte{0x01}))
+
+		store.err = errors.New("disk full")
+		assert.Equal(t, store.err, b.push([]byte{0x02}))
+		// the oldest event is not evicted, neither from the buffer nor from the store
+		assert.Equal(t, []uint64{1}, store.ids)
+		assert.Equal(t, uint64(0), b.stats().Dropped)
+		_, v, ok := b.pop(s)
+		assert.True(t, ok)
+		assert.Equal(t, []byte{0x01}, v)
+	})
+
+	t.Run("nil buffer", func(t *testing.T) {
+		var b *eventBuffer
+		s := &SrvSession{}
+		b.register(s)
+		_, _, ok := b.pop(s)
+		assert.False(t, ok)
+		assert.NoError(t, b.ack(s, 1))
+		b.unregister(s)
+		assert.Equal(t, 0, b.len())
+		assert.Equal(t, 0, b.remaining(s))
+		assert.Equal(t, EventBufferStats{}, b.stats())
+	})
+}
+
+// failingEventStore a memory store whose Append fails with err if set
+type failingEventStore struct {
+	err    error
+	nextID uint64
+	ids    []uint64
+}
+
+func (sf *failingEventStore) Append([]byte) (uint64, error) {
+	if sf.err != nil {
+		return 0, sf.err
+	}
+	sf.nextID++
+	sf.ids = append(sf.ids, sf.nextID)
+	return sf.nextID, nil
+}
+
+func (sf *failingEventStore) Remove(id uint64) error {
+	for i, v := range sf.ids {
+		if v == id {
+			sf.ids = append(sf.ids[:i], sf.ids[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (sf *failingEventStore) Load() ([]StoredEvent, error) { return nil, nil }
+func (sf *failingEventStore) Close() error                 { return nil }
@@ -0,0 +1,253 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// StoredEvent the event kept in EventStore
+type StoredEvent struct {
+	ID   uint64 // 单调递增的事件序号
+	Data []byte // asdu 序列化数据
+}
+
+// EventStore the persistent storage of outstation events,
+// events are removed only after the master acknowledged them.
+type EventStore interface {
+	// Append persist the event, return the id which must be larger than any previous one.
+	Append(data []byte) (uint64, error)
+	// Remove the acknowledged event.
+	Remove(id uint64) error
+	// Load return all the events not removed yet, in the order of id.
+	Load() ([]StoredEvent, error)
+	// Close the store.
+	Close() error
+}
+
+// file record type
+const (
+	recordAppend byte = 'A'
+	recordRemove byte = 'R'
+)
+
+// record head: type(1) + id(8) + data length(2) + crc32(4)
+const recordHeadSize = 15
+
+// the minimum records count to trigger compaction
+const compactThreshold = 1024
+
+// ErrEventStoreClosed the event store is closed
+var ErrEventStoreClosed = errors.New("event store is closed")
+
+// FileEventStore append-only file implement of EventStore.
+// every append and removal is synced to the disk, a removal is appended as a tombstone record,
+// the file is compacted when it is opened and truncated when all events are removed.
+type FileEventStore struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	live   map[uint64][]byte
+	order  []uint64
+	nextID uint64
+}
+
+var _ EventStore = (*FileEventStore)(nil)
+
+// OpenFileEventStore open or create the file event store at path,
+// a broken record at the tail (e.g. power failed while writing) is discarded.
+func OpenFileEventStore(path string) (*FileEventStore, error) {
+	sf := &FileEventStore{
+		path: path,
+		live: make(map[uint64][]byte),
+	}
+	if err := sf.recover(); err != nil {
+		return nil, err
+	}
+	if err := sf.compact(); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// recover read all the valid records from the file
+func (sf *FileEventStore) recover() error {
+	f, err := os.Open(sf.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	head := make([]byte, recordHeadSize)
+	for {
+		if _, err = io.ReadFull(r, head); err != nil {
+			break
+		}
+		id := binary.BigEndian.Uint64(head[1:])
+		data := make([]byte, binary.BigEndian.Uint16(head[9:]))
+		if _, err = io.ReadFull(r, data); err != nil {
+			break
+		}
+		crc := crc32.NewIEEE()
+		_, _ = crc.Write(head[:11])
+		_, _ = crc.Write(data)
+		if crc.Sum32() != binary.BigEndian.Uint32(head[11:]) {
+			break
+		}
+
+		switch head[0] {
+		case recordAppend:
+			sf.live[id] = data
+			sf.order = append(sf.order, id)
+		case recordRemove:
+			delete(sf.live, id)
+		}
+		if id > sf.nextID {
+			sf.nextID = id
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == nil {
+		return nil
+	}
+	return err
+}
+
+// compact rewrite the file only with the live events into a temporary file,
+// which replaces the file and its handle only if all succeed,
+// otherwise the file and its handle are kept as they were.
+func (sf *FileEventStore) compact() error {
+	tmp := sf.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	order := make([]uint64, 0, len(sf.live))
+	for _, id := range sf.order {
+		data, ok := sf.live[id]
+		if !ok {
+			continue
+		}
+		if _, err = f.Write(newRecord(recordAppend, id, data)); err != nil {
+			break
+		}
+		order = append(order, id)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, sf.path)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if sf.file != nil {
+		_ = sf.file.Close()
+	}
+	sf.file, sf.order = f, order
+	return nil
+}
+
+func newRecord(typ byte, id uint64, data []byte) []byte {
+	b := make([]byte, recordHeadSize+len(data))
+	b[0] = typ
+	binary.BigEndian.PutUint64(b[1:], id)
+	binary.BigEndian.PutUint16(b[9:], uint16(len(data)))
+	copy(b[recordHeadSize:], data)
+	crc := crc32.NewIEEE()
+	_, _ = crc.Write(b[:11])
+	_, _ = crc.Write(data)
+	binary.BigEndian.PutUint32(b[11:], crc.Sum32())
+	return b
+}
+
+// Append imp interface EventStore
+func (sf *FileEventStore) Append(data []byte) (uint64, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.file == nil {
+		return 0, ErrEventStoreClosed
+	}
+	id := sf.nextID + 1
+	if _, err := sf.file.Write(newRecord(recordAppend, id, data)); err != nil {
+		return 0, err
+	}
+	if err := sf.file.Sync(); err != nil {
+		return 0, err
+	}
+	sf.nextID = id
+	sf.live[id] = append([]byte(nil), data...)
+	sf.order = append(sf.order, id)
+	return id, nil
+}
+
+// Remove imp interface EventStore
+func (sf *FileEventStore) Remove(id uint64) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.file == nil {
+		return ErrEventStoreClosed
+	}
+	if _, ok := sf.live[id]; !ok {
+		return nil
+	}
+	delete(sf.live, id)
+	if len(sf.live) == 0 {
+		// 全部确认,清空文件
+		sf.order = sf.order[:0]
+		if err := sf.file.Truncate(0); err != nil {
+			return err
+		}
+		return sf.file.Sync()
+	}
+	if _, err := sf.file.Write(newRecord(recordRemove, id, nil)); err != nil {
+		return err
+	}
+	if err := sf.file.Sync(); err != nil {
+		return err
+	}
+	// 墓碑记录过多时压缩文件,失败时保留原文件继续使用
+	if len(sf.order) > compactThreshold && len(sf.order) > 4*len(sf.live) {
+		return sf.compact()
+	}
+	return nil
+}
+
+// Load imp interface EventStore
+func (sf *FileEventStore) Load() ([]StoredEvent, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	events := make([]StoredEvent, 0, len(sf.live))
+	for _, id := range sf.order {
+		if data, ok := sf.live[id]; ok {
+			events = append(events, StoredEvent{id, data})
+		}
+	}
+	return events, nil
+}
+
+// Close imp interface EventStore
+func (sf *FileEventStore) Close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.file == nil {
+		return nil
+	}
+	err := sf.file.Close()
+	sf.file = nil
+	return err
+}
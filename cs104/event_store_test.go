@@ -0,0 +1,87 @@
+package cs104
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileEventStore(t *testing.T) {
+	path := t.TempDir() + "/events.log"
+
+	store, err := OpenFileEventStore(path)
+	require.NoError(t, err)
+	for i := byte(1); i <= 3; i++ {
+		id, err := store.Append([]byte{i, i})
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i), id)
+	}
+	require.NoError(t, store.Remove(2))
+	require.NoError(t, store.Close())
+	_, err = store.Append([]byte{0x04})
+	assert.Equal(t, ErrEventStoreClosed, err)
+
+	// simulate a torn write at the tail
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(newRecord(recordAppend, 4, []byte{0x04})[:5])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// restart, replay in order
+	store, err = OpenFileEventStore(path)
+	require.NoError(t, err)
+	events, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, []StoredEvent{{1, []byte{1, 1}}, {3, []byte{3, 3}}}, events)
+
+	id, err := store.Append([]byte{0x04})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), id)
+
+	// all acknowledged, file truncated
+	require.NoError(t, store.Remove(1))
+	require.NoError(t, store.Remove(3))
+	require.NoError(t, store.Remove(4))
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), fi.Size())
+	require.NoError(t, store.Close())
+}
+
+func TestFileEventStore_CompactFailed(t *testing.T) {
+	path := t.TempDir() + "/events.log"
+	store, err := OpenFileEventStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	for i := 0; i <= compactThreshold; i++ {
+		_, err = store.Append([]byte{0x01})
+		require.NoError(t, err)
+	}
+
+	// the temporary file can not be created, compaction fails
+	require.NoError(t, os.Mkdir(path+".tmp", 0755))
+	id := uint64(1)
+	for ; err == nil; id++ {
+		err = store.Remove(id)
+	}
+	assert.Error(t, err)
+
+	// the store is still usable
+	require.NoError(t, os.Remove(path+".tmp"))
+	_, err = store.Append([]byte{0x02})
+	require.NoError(t, err)
+	require.NoError(t, store.Remove(id))
+	want, err := store.Load()
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	store, err = OpenFileEventStore(path)
+	require.NoError(t, err)
+	events, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, want, events)
+	assert.Len(t, events, compactThreshold+2-int(id))
+}
@@ -0,0 +1,336 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/clog"
+)
+
+// FailoverStrategy defines how the client chooses the endpoint to connect
+type FailoverStrategy uint8
+
+// failover strategy defined
+const (
+	// FailoverSticky 每次重连都从第一个(主)端点开始依次尝试(默认)
+	FailoverSticky FailoverStrategy = iota
+	// FailoverRoundRobin 从上次断开端点的下一个端点开始依次尝试
+	FailoverRoundRobin
+	// FailoverProbe 切换前并发探测所有端点,只依次尝试可达的端点
+	FailoverProbe
+)
+
+// String returns the name of failover strategy
+func (sf FailoverStrategy) String() string {
+	switch sf {
+	case FailoverSticky:
+		return "Sticky"
+	case FailoverRoundRobin:
+		return "RoundRobin"
+	case FailoverProbe:
+		return "Probe"
+	}
+	return "Unknown"
+}
+
+// endpointOrder return the order of endpoints to try in this round,
+// last is the index of the endpoint connected or tried last time, -1 if none.
+// held report the endpoint has an alive standby connection, which is reachable
+// without probing, it can be nil.
+func (sf *ClientOption) endpointOrder(ctx context.Context, last int, held func(int) bool) []int {
+	n := len(sf.servers)
+	order := make([]int, 0, n)
+	switch sf.failover {
+	case FailoverRoundRobin:
+		for i := 1; i <= n; i++ {
+			order = append(order, (last+i+n)%n)
+		}
+	case FailoverProbe:
+		reachable := make([]bool, n)
+		var wg sync.WaitGroup
+		for i := range sf.servers {
+			if held != nil && held(i) {
+				reachable[i] = true
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				reachable[i] = sf.probe(ctx, sf.servers[i])
+			}(i)
+		}
+		wg.Wait()
+		for i := range sf.servers {
+			if reachable[i] {
+				order = append(order, i)
+			}
+		}
+	default:
+		for i := 0; i < n; i++ {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// probe check the endpoint is reachable, only tcp connect without tls handshake
+func (sf *ClientOption) probe(ctx context.Context, uri *url.URL) bool {
+	if sf.factory == nil && uri.Scheme != "unix" {
+		probeURI := *uri
+		probeURI.Scheme = "tcp"
+		uri = &probeURI
+	}
+	conn, err := sf.openConnection(ctx, uri)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// standbyConn a connection kept in STOPDT state, which only exchanges TESTFR frames
+type standbyConn struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	take    bool
+	inFrame bool // 正在读取一个帧,读完前不能交出连接
+	done    chan struct{}
+}
+
+// keepalive answer TESTFR act and send TESTFR act after idle t3,
+// close the connection when the TESTFR con not received in t1.
+// it stops between frames once the connection is taken.
+func (sf *standbyConn) keepalive(cfg *Config) {
+	defer close(sf.done)
+
+	testFrSent := false
+	for {
+		timeout := cfg.IdleTimeout3
+		if testFrSent {
+			timeout = cfg.SendUnAckTimeout1
+		}
+		sf.mu.Lock()
+		if sf.take {
+			sf.mu.Unlock()
+			return
+		}
+		_ = sf.conn.SetReadDeadline(time.Now().Add(timeout))
+		sf.mu.Unlock()
+
+		head := make([]byte, 2)
+		_, err := io.ReadFull(sf.conn, head[:1])
+		if err == nil && head[0] == startFrame {
+			// 帧已开始,读完整帧,即使连接已被取走
+			sf.mu.Lock()
+			sf.inFrame = true
+			_ = sf.conn.SetReadDeadline(time.Now().Add(cfg.SendUnAckTimeout1))
+			sf.mu.Unlock()
+			var body []byte
+			if _, err = io.ReadFull(sf.conn, head[1:]); err == nil {
+				body = make([]byte, head[1])
+				_, err = io.ReadFull(sf.conn, body)
+			}
+			sf.mu.Lock()
+			sf.inFrame = false
+			sf.mu.Unlock()
+			if err == nil && len(body) >= APCICtlFiledSize {
+				if apci, _ := ParseAPDU(append(head, body...)); apci == (UAPCI{UTestFrActive}) {
+					_, err = sf.conn.Write(NewUFrame(UTestFrConfirm))
+				} else if apci == (UAPCI{UTestFrConfirm}) {
+					testFrSent = false
+				}
+			}
+		}
+
+		sf.mu.Lock()
+		take := sf.take
+		sf.mu.Unlock()
+		if take {
+			return
+		}
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() && !testFrSent {
+				testFrSent = true
+				if _, err = sf.conn.Write(NewUFrame(UTestFrActive)); err == nil {
+					continue
+				}
+			}
+			_ = sf.conn.Close()
+			return
+		}
+	}
+}
+
+// release stop the keepalive between frames and return the connection
+func (sf *standbyConn) release() net.Conn {
+	sf.mu.Lock()
+	sf.take = true
+	if !sf.inFrame {
+		// 仅中断等待帧开始的读取,不拆分帧
+		_ = sf.conn.SetReadDeadline(time.Now())
+	}
+	sf.mu.Unlock()
+	<-sf.done
+	_ = sf.conn.SetReadDeadline(time.Time{})
+	return sf.conn
+}
+
+// alive return true if the keepalive is running
+func (sf *standbyConn) alive() bool {
+	select {
+	case <-sf.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// standbyPool keeps standby connections to the endpoints other than the active one
+type standbyPool struct {
+	option *ClientOption
+	mu     sync.Mutex
+	conns  map[int]*standbyConn
+	active int
+	clog.Clog
+}
+
+func newStandbyPool(o *ClientOption, l clog.Clog) *standbyPool {
+	return &standbyPool{
+		option: o,
+		conns:  make(map[int]*standbyConn),
+		active: -1,
+		Clog:   l,
+	}
+}
+
+// run maintain the standby connections until ctx done
+func (sf *standbyPool) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer func() {
+		ticker.Stop()
+		sf.mu.Lock()
+		for i, c := range sf.conns {
+			_ = c.release().Close()
+			delete(sf.conns, i)
+		}
+		sf.mu.Unlock()
+	}()
+
+	// 每个端点独立退避重连
+	attempts := make(map[int]int)
+	nextDial := make(map[int]time.Time)
+	for {
+		var dials []int
+		now := time.Now()
+		sf.mu.Lock()
+		for i := range sf.option.servers {
+			if c, ok := sf.conns[i]; ok && !c.alive() {
+				delete(sf.conns, i)
+				attempts[i]++
+				nextDial[i] = now.Add(sf.option.reconnectDelay(attempts[i], false))
+			}
+			if _, ok := sf.conns[i]; ok || i == sf.active || now.Before(nextDial[i]) {
+				continue
+			}
+			dials = append(dials, i)
+		}
+		sf.mu.Unlock()
+
+		for _, i := range dials {
+			conn, err := sf.option.openConnection(ctx, sf.option.servers[i])
+			if err != nil {
+				sf.Warn("standby connect %v failed, %v", sf.option.servers[i], err)
+				attempts[i]++
+				nextDial[i] = time.Now().Add(sf.option.reconnectDelay(attempts[i], true))
+				continue
+			}
+			attempts[i] = 0
+			sf.mu.Lock()
+			if i == sf.active {
+				sf.mu.Unlock()
+				_ = conn.Close()
+				continue
+			}
+			sf.Debug("standby connected %v", sf.option.servers[i])
+			c := &standbyConn{conn: conn, done: make(chan struct{})}
+			go c.keepalive(&sf.option.config)
+			sf.conns[i] = c
+			sf.mu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// take the standby connection of the endpoint i, and mark i as active endpoint.
+// return nil if there is no alive standby connection, i is not marked then.
+func (sf *standbyPool) take(i int) net.Conn {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	c, ok := sf.conns[i]
+	if !ok {
+		return nil
+	}
+	delete(sf.conns, i)
+	if !c.alive() {
+		return nil
+	}
+	sf.active = i
+	return c.release()
+}
+
+// setActive mark i as active endpoint which is connected directly,
+// its standby connection, if any, is closed.
+func (sf *standbyPool) setActive(i int) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.active = i
+	if c, ok := sf.conns[i]; ok {
+		delete(sf.conns, i)
+		_ = c.release().Close()
+	}
+}
+
+// held return true if the endpoint i has an alive standby connection
+func (sf *standbyPool) held(i int) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	c, ok := sf.conns[i]
+	return ok && c.alive()
+}
+
+// prefer move the endpoints with an alive standby connection to the front of order,
+// so a standby is taken over before dialing the others, such as the endpoint just lost.
+func (sf *standbyPool) prefer(order []int) []int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	preferred := make([]int, 0, len(order))
+	var others []int
+	for _, i := range order {
+		if c, ok := sf.conns[i]; ok && c.alive() {
+			preferred = append(preferred, i)
+		} else {
+			others = append(others, i)
+		}
+	}
+	return append(preferred, others...)
+}
+
+// clearActive the active endpoint lost, it can keep standby again.
+func (sf *standbyPool) clearActive() {
+	sf.mu.Lock()
+	sf.active = -1
+	sf.mu.Unlock()
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// FrameDirection the direction of a frame
+type FrameDirection byte
+
+// frame direction defined
+const (
+	FrameReceived FrameDirection = iota
+	FrameSent
+)
+
+func (sf FrameDirection) String() string {
+	if sf == FrameSent {
+		return "TX"
+	}
+	return "RX"
+}
+
+// FrameFormat the format of the APCI
+type FrameFormat byte
+
+// frame format defined
+const (
+	FrameI FrameFormat = iota // 信息传输格式
+	FrameS                    // 计数的监视功能格式
+	FrameU                    // 不计数的控制功能格式
+)
+
+func (sf FrameFormat) String() string {
+	switch sf {
+	case FrameI:
+		return "I"
+	case FrameS:
+		return "S"
+	default:
+		return "U"
+	}
+}
+
+// MarshalText imp encoding.TextMarshaler
+func (sf FrameFormat) MarshalText() ([]byte, error) {
+	return []byte(sf.String()), nil
+}
+
+// UnmarshalText imp encoding.TextUnmarshaler
+func (sf *FrameFormat) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "I":
+		*sf = FrameI
+	case "S":
+		*sf = FrameS
+	case "U":
+		*sf = FrameU
+	default:
+		return fmt.Errorf("invalid frame format %q", b)
+	}
+	return nil
+}
+
+// Frame an APDU received or sent, the decoded APCI fields are set according to the format
+type Frame struct {
+	Direction  FrameDirection
+	Time       time.Time // 收到或发送完成的时间
+	Raw        []byte    // the whole APDU, must not be modified or retained after the call returns
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Session    *Session // the server session, nil on the client
+
+	Format   FrameFormat
+	SendSN   uint16 // N(S) of the I-frame
+	RcvSN    uint16 // N(R) of the I-frame and the S-frame
+	Function byte   // function of the U-frame, one of the UAPCI functions such as UStartDtActive
+}
+
+// ASDU return the asdu bytes of the I-frame, nil for the others
+func (sf *Frame) ASDU() []byte {
+	if sf.Format != FrameI || len(sf.Raw) <= APCICtlFiledSize+2 {
+		return nil
+	}
+	return sf.Raw[APCICtlFiledSize+2:]
+}
+
+func (sf Frame) String() string {
+	switch sf.Format {
+	case FrameI:
+		return fmt.Sprintf("%v %v", sf.Direction, IAPCI{sf.SendSN, sf.RcvSN})
+	case FrameS:
+		return fmt.Sprintf("%v %v", sf.Direction, SAPCI{sf.RcvSN})
+	default:
+		return fmt.Sprintf("%v %v", sf.Direction, UAPCI{sf.Function})
+	}
+}
+
+// FrameObserver observe every APDU of the link, it is called in the receive or send
+// goroutine of the link, so it should not block for long.
+type FrameObserver interface {
+	ObserveFrame(f *Frame)
+}
+
+// FrameObserverFunc adapter a function as FrameObserver
+type FrameObserverFunc func(f *Frame)
+
+// ObserveFrame imp FrameObserver
+func (sf FrameObserverFunc) ObserveFrame(f *Frame) { sf(f) }
+
+// LinkCloseObserver an optional interface of the FrameObserver, notified after the link closed,
+// so that the observer can release the resources of the link.
+type LinkCloseObserver interface {
+	LinkClosed(localAddr, remoteAddr net.Addr, session *Session)
+}
+
+type multiFrameObserver []FrameObserver
+
+func (sf multiFrameObserver) ObserveFrame(f *Frame) {
+	for _, o := range sf {
+		o.ObserveFrame(f)
+	}
+}
+
+func (sf multiFrameObserver) LinkClosed(localAddr, remoteAddr net.Addr, session *Session) {
+	for _, o := range sf {
+		if c, ok := o.(LinkCloseObserver); ok {
+			c.LinkClosed(localAddr, remoteAddr, session)
+		}
+	}
+}
+
+// MultiFrameObserver return a FrameObserver call each of the observers in order
+func MultiFrameObserver(observers ...FrameObserver) FrameObserver {
+	return multiFrameObserver(observers)
+}
+
+// newFrame decode the apdu as a frame
+func newFrame(dir FrameDirection, apdu []byte, conn net.Conn, session *Session) *Frame {
+	f := &Frame{
+		Direction:  dir,
+		Time:       time.Now(),
+		Raw:        apdu,
+		LocalAddr:  conn.LocalAddr(),
+		RemoteAddr: conn.RemoteAddr(),
+		Session:    session,
+	}
+	if len(apdu) < APCICtlFiledSize+2 {
+		f.Format = FrameU
+		return f
+	}
+	switch head, _ := ParseAPDU(apdu); v := head.(type) {
+	case IAPCI:
+		f.Format, f.SendSN, f.RcvSN = FrameI, v.SendSN, v.RcvSN
+	case SAPCI:
+		f.Format, f.RcvSN = FrameS, v.RcvSN
+	case UAPCI:
+		f.Format, f.Function = FrameU, v.Function
+	}
+	return f
+}
+
+// SetFrameObserver set the observer of the frames of all the sessions, it must be called before Serve.
+func (sf *Server) SetFrameObserver(o FrameObserver) *Server {
+	sf.frameObserver = o
+	return sf
+}
+
+// SetFrameObserver set the observer of the frames of the client
+func (sf *ClientOption) SetFrameObserver(o FrameObserver) *ClientOption {
+	sf.frameObserver = o
+	return sf
+}
+
+// observeFrame notify the observer of the session
+func (sf *SrvSession) observeFrame(dir FrameDirection, apdu []byte) {
+	if sf.frameObserver != nil {
+		sf.frameObserver.ObserveFrame(newFrame(dir, apdu, sf.conn, sf.session))
+	}
+}
+
+// observeClose notify the observer of the session the link closed
+func (sf *SrvSession) observeClose() {
+	if c, ok := sf.frameObserver.(LinkCloseObserver); ok {
+		c.LinkClosed(sf.conn.LocalAddr(), sf.conn.RemoteAddr(), sf.session)
+	}
+}
+
+// observeFrame notify the observer of the client
+func (sf *Client) observeFrame(dir FrameDirection, apdu []byte) {
+	if sf.option.frameObserver != nil {
+		sf.option.frameObserver.ObserveFrame(newFrame(dir, apdu, sf.conn, nil))
+	}
+}
+
+// observeClose notify the observer of the client the link closed
+func (sf *Client) observeClose() {
+	if c, ok := sf.option.frameObserver.(LinkCloseObserver); ok {
+		c.LinkClosed(sf.conn.LocalAddr(), sf.conn.RemoteAddr(), nil)
+	}
+}
@@ -0,0 +1,89 @@
+package cs104
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type frameRecorder struct {
+	mu     sync.Mutex
+	frames []Frame
+}
+
+func (sf *frameRecorder) ObserveFrame(f *Frame) {
+	sf.mu.Lock()
+	c := *f
+	c.Raw = append([]byte(nil), f.Raw...)
+	sf.frames = append(sf.frames, c)
+	sf.mu.Unlock()
+}
+
+func (sf *frameRecorder) get() []Frame {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return append([]Frame(nil), sf.frames...)
+}
+
+func Test_newFrame(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	iframe, err := NewIFrame(3, 5, []byte{0x01, 0x02})
+	require.NoError(t, err)
+	f := newFrame(FrameSent, iframe, c1, nil)
+	assert.Equal(t, FrameI, f.Format)
+	assert.Equal(t, uint16(3), f.SendSN)
+	assert.Equal(t, uint16(5), f.RcvSN)
+	assert.Equal(t, []byte{0x01, 0x02}, f.ASDU())
+	assert.Equal(t, "TX I[sendNO: 3, recvNO: 5]", f.String())
+
+	f = newFrame(FrameReceived, NewSFrame(7), c1, nil)
+	assert.Equal(t, FrameS, f.Format)
+	assert.Equal(t, uint16(7), f.RcvSN)
+	assert.Nil(t, f.ASDU())
+
+	f = newFrame(FrameReceived, NewUFrame(UTestFrActive), c1, nil)
+	assert.Equal(t, FrameU, f.Format)
+	assert.Equal(t, byte(UTestFrActive), f.Function)
+	assert.Equal(t, "RX U[function: TestFrActive]", f.String())
+}
+
+func TestFrameObserver(t *testing.T) {
+	srvFrames, cliFrames := &frameRecorder{}, &frameRecorder{}
+	srv := NewServer(nopServerHandler{}).SetFrameObserver(srvFrames)
+	addr := startTestServer(t, srv)
+
+	o := NewOption().SetAutoReconnect(false).SetFrameObserver(MultiFrameObserver(cliFrames))
+	require.NoError(t, o.AddRemoteServer(addr))
+	c := NewClient(nopClientHandler{}, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	require.NoError(t, c.Start())
+	defer c.Close()
+	require.Eventually(t, c.GetActiveStatus, time.Second, 10*time.Millisecond)
+	require.NoError(t, c.Send(singleCmd(t, 1, 100)))
+	require.Eventually(t, func() bool { return len(srvFrames.get()) >= 3 }, time.Second, 10*time.Millisecond)
+
+	got := srvFrames.get()
+	assert.Equal(t, FrameReceived, got[0].Direction)
+	assert.Equal(t, byte(UStartDtActive), got[0].Function)
+	assert.NotNil(t, got[0].Session)
+	assert.Equal(t, FrameSent, got[1].Direction)
+	assert.Equal(t, byte(UStartDtConfirm), got[1].Function)
+	assert.Equal(t, FrameI, got[2].Format)
+	assert.NotEmpty(t, got[2].ASDU())
+
+	got = cliFrames.get()
+	require.GreaterOrEqual(t, len(got), 3)
+	assert.Equal(t, FrameSent, got[0].Direction)
+	assert.Equal(t, byte(UStartDtActive), got[0].Function)
+	assert.Nil(t, got[0].Session)
+	assert.Equal(t, addr, got[0].RemoteAddr.String())
+	assert.Equal(t, NewUFrame(UStartDtConfirm), got[1].Raw)
+	assert.Equal(t, FrameI, got[2].Format)
+}
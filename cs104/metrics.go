@@ -0,0 +1,162 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// metricFamily the samples of a metric in the prometheus text format
+type metricFamily struct {
+	name, help, typ string
+	samples         []string
+}
+
+// metricSet collect the metric families in order
+type metricSet struct {
+	families []*metricFamily
+	index    map[string]*metricFamily
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{index: make(map[string]*metricFamily)}
+}
+
+func (sf *metricSet) add(name, typ, help, labels string, value float64) {
+	f, ok := sf.index[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, typ: typ}
+		sf.index[name] = f
+		sf.families = append(sf.families, f)
+	}
+	sample := name
+	if labels != "" {
+		sample += "{" + labels + "}"
+	}
+	f.samples = append(f.samples, sample+" "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (sf *metricSet) writeTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range sf.families {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, s := range f.samples {
+			bw.WriteString(s)
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// metricLabels format the label pairs, the values are escaped
+func metricLabels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func withLabel(labels, pair string) string {
+	if labels == "" {
+		return pair
+	}
+	return labels + "," + pair
+}
+
+// addLink add the metrics of a link
+func (sf *metricSet) addLink(labels string, s LinkStats) {
+	const prefix = "iec104_"
+	for _, v := range []struct {
+		typ       string
+		sent, rcv uint64
+	}{
+		{"I", s.IFramesSent, s.IFramesReceived},
+		{"S", s.SFramesSent, s.SFramesReceived},
+		{"U", s.UFramesSent, s.UFramesReceived},
+	} {
+		l := withLabel(labels, `type="`+v.typ+`"`)
+		sf.add(prefix+"frames_sent_total", "counter", "Frames sent.", l, float64(v.sent))
+		sf.add(prefix+"frames_received_total", "counter", "Frames received.", l, float64(v.rcv))
+	}
+	sf.add(prefix+"bytes_sent_total", "counter", "Bytes sent.", labels, float64(s.BytesSent))
+	sf.add(prefix+"bytes_received_total", "counter", "Bytes received.", labels, float64(s.BytesReceived))
+	sf.add(prefix+"t1_timeouts_total", "counter", "Confirmation timeouts t1.", labels, float64(s.T1Timeouts))
+	sf.add(prefix+"t2_acks_total", "counter", "S-frames sent on timeout t2.", labels, float64(s.T2Acks))
+	sf.add(prefix+"t3_tests_total", "counter", "TESTFR sent on idle timeout t3.", labels, float64(s.T3Tests))
+	sf.add(prefix+"reconnects_total", "counter", "Reconnections of the client.", labels, float64(s.Reconnects))
+	sf.add(prefix+"unacked_iframes", "gauge", "I-frames sent and not acknowledged.", labels, float64(s.Unacked))
+	sf.add(prefix+"send_queue", "gauge", "ASDUs waiting to be sent.", labels, float64(s.SendQueue))
+	var last float64
+	if !s.LastReceived.IsZero() {
+		last = float64(s.LastReceived.UnixNano()) / 1e9
+	}
+	sf.add(prefix+"last_received_timestamp_seconds", "gauge", "Time of the last received frame.", labels, last)
+	sf.add(prefix+"rtt_seconds", "gauge", "Time from the last acknowledged I-frame sent to its acknowledgement.", labels, s.RTT.Seconds())
+}
+
+// addProtocolErrors add the metrics of the protocol errors
+func (sf *metricSet) addProtocolErrors(labels string, s ProtocolErrorStats) {
+	for _, v := range []struct {
+		typ string
+		n   uint64
+	}{
+		{"sequence", s.Sequence},
+		{"t1_timeout", s.T1Timeout},
+		{"unexpected_u_frame", s.UnexpectedUFrame},
+		{"frame_too_long", s.FrameTooLong},
+	} {
+		sf.add("iec104_protocol_errors_total", "counter", "Protocol errors detected.",
+			withLabel(labels, `error="`+v.typ+`"`), float64(v.n))
+	}
+}
+
+// metricsHandler serve the metrics collected by collect
+func metricsHandler(collect func(ms *metricSet)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		ms := newMetricSet()
+		collect(ms)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = ms.writeTo(w)
+	})
+}
+
+// MetricsHandler return a http.Handler exposing the statistics of the sessions
+// in the prometheus text format, each session is labeled with its id and remote address.
+func (sf *Server) MetricsHandler() http.Handler {
+	return metricsHandler(func(ms *metricSet) {
+		st := sf.Stats()
+		ms.add("iec104_sessions", "gauge", "Sessions established.", "", float64(len(st.Sessions)))
+		for _, s := range st.Sessions {
+			ms.addLink(metricLabels("session", strconv.FormatUint(s.ID, 10), "remote", s.RemoteAddr), s.LinkStats)
+		}
+		ms.addProtocolErrors("", st.ProtocolErrors)
+	})
+}
+
+// MetricsHandler return a http.Handler exposing the statistics of the client
+// in the prometheus text format, labeled with the endpoint connected.
+func (sf *Client) MetricsHandler() http.Handler {
+	return metricsHandler(func(ms *metricSet) {
+		var up float64
+		remote := ""
+		if ep := sf.ActiveEndpoint(); ep != nil {
+			up, remote = 1, ep.Host
+		}
+		ms.add("iec104_up", "gauge", "Whether the client is connected.", metricLabels("remote", remote), up)
+		ms.addLink("", sf.Stats())
+	})
+}
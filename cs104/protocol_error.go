@@ -0,0 +1,80 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+// ProtocolErrorStats the count of each type of the protocol errors
+type ProtocolErrorStats struct {
+	Sequence         uint64 // 发送或接收序号错误, ErrSequence
+	T1Timeout        uint64 // 确认超时, ErrT1Timeout
+	UnexpectedUFrame uint64 // 非预期或非法的U帧, ErrUnexpectedUFrame
+	FrameTooLong     uint64 // 帧长度超限, ErrFrameTooLong
+}
+
+// protocolErrors the counters of the protocol errors
+type protocolErrors struct {
+	sequence         uint64
+	t1Timeout        uint64
+	unexpectedUFrame uint64
+	frameTooLong     uint64
+}
+
+func (sf *protocolErrors) add(err error) {
+	switch {
+	case errors.Is(err, ErrSequence):
+		atomic.AddUint64(&sf.sequence, 1)
+	case errors.Is(err, ErrT1Timeout):
+		atomic.AddUint64(&sf.t1Timeout, 1)
+	case errors.Is(err, ErrUnexpectedUFrame):
+		atomic.AddUint64(&sf.unexpectedUFrame, 1)
+	case errors.Is(err, ErrFrameTooLong):
+		atomic.AddUint64(&sf.frameTooLong, 1)
+	}
+}
+
+func (sf *protocolErrors) stats() ProtocolErrorStats {
+	return ProtocolErrorStats{
+		Sequence:         atomic.LoadUint64(&sf.sequence),
+		T1Timeout:        atomic.LoadUint64(&sf.t1Timeout),
+		UnexpectedUFrame: atomic.LoadUint64(&sf.unexpectedUFrame),
+		FrameTooLong:     atomic.LoadUint64(&sf.frameTooLong),
+	}
+}
+
+// SetProtocolErrorHandler set the handler called when a session detects a protocol error,
+// it's called in the session goroutines, the session is closed after it
+// unless the error is ErrUnexpectedUFrame, which is ignored.
+func (sf *Server) SetProtocolErrorHandler(f func(c asdu.Connect, err error)) *Server {
+	sf.onProtocolError = f
+	return sf
+}
+
+// ProtocolErrorStats the protocol errors of all the sessions since the server created
+func (sf *Server) ProtocolErrorStats() ProtocolErrorStats {
+	return sf.protocolErrors.stats()
+}
+
+// ProtocolErrorStats the protocol errors of the session
+func (sf *SrvSession) ProtocolErrorStats() ProtocolErrorStats {
+	return sf.protocolErrors.stats()
+}
+
+// protocolError log, count and report the protocol error
+func (sf *SrvSession) protocolError(err error) {
+	sf.Error("protocol error, %v", err)
+	sf.protocolErrors.add(err)
+	if sf.srvProtocolErrors != nil {
+		sf.srvProtocolErrors.add(err)
+	}
+	if sf.onProtocolError != nil {
+		sf.onProtocolError(sf, err)
+	}
+}
@@ -0,0 +1,74 @@
+package cs104
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+func TestServer_ProtocolError(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SendUnAckTimeout1 = time.Second
+	srv := NewServer(nopServerHandler{}).SetConfig(cfg)
+	reported := make(chan error, 8)
+	srv.SetProtocolErrorHandler(func(c asdu.Connect, err error) {
+		assert.NotNil(t, SessionOf(c))
+		reported <- err
+	})
+	addr := startTestServer(t, srv)
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+		want   error
+		closed bool
+	}{
+		{"early ack", [][]byte{NewSFrame(5)}, ErrSequence, true},
+		{"bad send sequence", [][]byte{{startFrame, 0x04, 0x02, 0x00, 0x00, 0x00}}, ErrSequence, true},
+		{"unexpected u-frame", [][]byte{NewUFrame(UStartDtConfirm)}, ErrUnexpectedUFrame, false},
+		{"frame too long", [][]byte{{startFrame, 0xfe, 0x00, 0x00}}, ErrFrameTooLong, true},
+		{"t1 timeout", nil, ErrT1Timeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			_, err = conn.Write(NewUFrame(UStartDtActive))
+			require.NoError(t, err)
+			assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+			if tt.frames == nil {
+				require.NoError(t, srv.Send(singlePoint(t, 1)))
+				readAPDU(t, conn, r)
+			}
+			for _, v := range tt.frames {
+				_, err = conn.Write(v)
+				require.NoError(t, err)
+			}
+
+			select {
+			case err = <-reported:
+				assert.True(t, errors.Is(err, tt.want), err)
+			case <-time.After(3 * time.Second):
+				t.Fatal("wait protocol error timeout")
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = r.ReadByte()
+			if tt.closed {
+				assert.Error(t, err)
+			} else {
+				var ne net.Error
+				assert.True(t, errors.As(err, &ne) && ne.Timeout(), "session kept")
+			}
+		})
+	}
+	assert.Equal(t, ProtocolErrorStats{Sequence: 2, T1Timeout: 1, UnexpectedUFrame: 1, FrameTooLong: 1}, srv.ProtocolErrorStats())
+}
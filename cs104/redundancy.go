@@ -0,0 +1,130 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"fmt"
+	"net"
+)
+
+// DefaultEventBufferSize default event buffer size of redundancy group
+const DefaultEventBufferSize = 1024
+
+// RedundancyGroup defines a redundancy group of the controlling station.
+// several connections of a group can be open at once,
+// but only the one that received STARTDT carries data.
+// See IEC 60870-5-104 edition 2, clause 10.
+type RedundancyGroup struct {
+	Name       string
+	Clients    []string       // 控制站的IP地址
+	BufferSize int            // 事件缓存容量, 0 使用 DefaultEventBufferSize
+	Policy     OverflowPolicy // 事件缓存满时的处理策略
+	Store      EventStore     // 事件持久化存储, 可为nil
+}
+
+type redundancyGroup struct {
+	name    string
+	clients []net.IP
+	events  *eventBuffer
+}
+
+// contains return true if ip belongs to the group
+func (sf *redundancyGroup) contains(ip net.IP) bool {
+	for _, v := range sf.clients {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddRedundancyGroup add a redundancy group, the connections from the clients of the group
+// share one event queue and only one of them has data transfer active at a time,
+// when a connection of the group receives STARTDT, the others are deactivated,
+// and the unacknowledged events carry over to it.
+// connections not in any group behave as before.
+// it must be called before Serve.
+func (sf *Server) AddRedundancyGroup(g RedundancyGroup) error {
+	if g.Name == "" {
+		return fmt.Errorf("redundancy group name is empty")
+	}
+	group := &redundancyGroup{name: g.Name}
+	for _, v := range g.Clients {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return fmt.Errorf("redundancy group %s invalid client ip %s", g.Name, v)
+		}
+		group.clients = append(group.clients, ip)
+	}
+
+	size := g.BufferSize
+	if size <= 0 {
+		size = DefaultEventBufferSize
+	}
+	group.events = newEventBuffer(size, g.Policy)
+	if g.Store != nil {
+		if err := group.events.setStore(g.Store); err != nil {
+			return err
+		}
+	}
+
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	for _, v := range sf.groups {
+		if v.name == g.Name {
+			return fmt.Errorf("redundancy group %s already exist", g.Name)
+		}
+		for _, ip := range group.clients {
+			if v.contains(ip) {
+				return fmt.Errorf("client ip %s already in redundancy group %s", ip, v.name)
+			}
+		}
+	}
+	sf.groups = append(sf.groups, group)
+	return nil
+}
+
+// RedundancyGroupStats returns the snapshot of the event buffer stats of the redundancy group
+func (sf *Server) RedundancyGroupStats(name string) (EventBufferStats, bool) {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	for _, v := range sf.groups {
+		if v.name == name {
+			return v.events.stats(), true
+		}
+	}
+	return EventBufferStats{}, false
+}
+
+// matchGroup return the redundancy group of the remote address, nil if not in any group
+func (sf *Server) matchGroup(addr net.Addr) *redundancyGroup {
+	ip := remoteIP(addr)
+	if ip == nil {
+		return nil
+	}
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	for _, v := range sf.groups {
+		if v.contains(ip) {
+			return v
+		}
+	}
+	return nil
+}
+
+// activate make sess the only session with data transfer active of its redundancy group
+func (sf *Server) activate(sess *SrvSession) {
+	if sess.group == nil {
+		return
+	}
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	for k := range sf.sessions {
+		if k != sess && k.group == sess.group && k.IsActive() {
+			k.deactivate()
+			sf.Warn("redundancy group %s, deactivate connection %v", sess.group.name, k.conn.RemoteAddr())
+		}
+	}
+}
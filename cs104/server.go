@@ -7,8 +7,12 @@ package cs104
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"net"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/thinkgos/go-iecp5/asdu"
@@ -26,11 +30,31 @@ type Server struct {
 	params         asdu.Params
 	handler        ServerHandlerInterface
 	TLSConfig      *tls.Config
+	tlsConfig      atomic.Pointer[tls.Config] // 实际用于握手的配置,可热更新
+	tlsLimits      TLSLimits
+	peerVerifier   func(tls.ConnectionState) error
 	mux            sync.Mutex
+	overflow       OverflowPolicy
+	events         *eventBuffer
+	groups         []*redundancyGroup
 	sessions       map[*SrvSession]struct{}
+	nextSessionID  uint64
+	admission      *admission
+	onAdmission    func(*Session) error
+	access         *accessControl
+	auditSink      AuditSink
+	frameObserver  FrameObserver
+	shuttingDown   uint32
+	connCount      int            // 已接纳的连接数,含握手中的
+	connsPerIP     map[string]int // 每个IP已接纳的连接数
 	listen         net.Listener
+	ctx            context.Context
+	cancel         context.CancelFunc
 	onConnection   func(asdu.Connect)
 	connectionLost func(asdu.Connect)
+	// 协议错误
+	onProtocolError func(asdu.Connect, error)
+	protocolErrors  protocolErrors
 	clog.Clog
 	wg sync.WaitGroup
 }
@@ -38,11 +62,13 @@ type Server struct {
 // NewServer new a server, default config and default asdu.ParamsWide params
 func NewServer(handler ServerHandlerInterface) *Server {
 	return &Server{
-		config:   DefaultConfig(),
-		params:   *asdu.ParamsWide,
-		handler:  handler,
-		sessions: make(map[*SrvSession]struct{}),
-		Clog:     clog.NewLogger("cs104 server => "),
+		config:     DefaultConfig(),
+		params:     *asdu.ParamsWide,
+		handler:    handler,
+		sessions:   make(map[*SrvSession]struct{}),
+		connsPerIP: make(map[string]int),
+		tlsLimits:  DefaultTLSLimits(),
+		Clog:       clog.NewLogger("cs104 server => "),
 	}
 }
 
@@ -66,9 +92,53 @@ func (sf *Server) SetParams(p *asdu.Params) *Server {
 	return sf
 }
 
-// ListenAndServer run the server
+// SetOverflowPolicy set the policy applied by Send of each new session when its send buffer is full
+func (sf *Server) SetOverflowPolicy(p OverflowPolicy) *Server {
+	sf.overflow = p
+	return sf
+}
+
+// SetEventBuffer enable the outstation event buffer with size events,
+// while no session has data transfer active, Send keeps the events in the buffer,
+// and the sessions which receive STARTDT next deliver them in order, each of the
+// sessions with data transfer active receives every event pushed meanwhile.
+// only OverflowDropNewest and OverflowDropOldest are meaningful for the buffer,
+// others behave as OverflowDropNewest. size <= 0 disable the buffer.
+// it must be called before Serve.
+func (sf *Server) SetEventBuffer(size int, policy OverflowPolicy) *Server {
+	if size <= 0 {
+		sf.events = nil
+	} else {
+		sf.events = newEventBuffer(size, policy)
+	}
+	return sf
+}
+
+// SetEventStore persist the buffered events to store, the unacknowledged events
+// in store are loaded and will be sent once a master activates data transfer.
+// an event is removed from store only after all the sessions it was sent to acknowledged it,
+// and all the events passed to Send go through the buffer while the store is set.
+// it must be called after SetEventBuffer and before Serve, the store is not closed by the server.
+func (sf *Server) SetEventStore(store EventStore) error {
+	if sf.events == nil {
+		return errors.New("event buffer not enabled")
+	}
+	return sf.events.setStore(store)
+}
+
+// EventBufferStats returns the snapshot of the event buffer stats
+func (sf *Server) EventBufferStats() EventBufferStats {
+	return sf.events.stats()
+}
+
+// ListenAndServer run the server, addr is host:port for tcp,
+// or unix:///path/to/socket for unix socket, TLSConfig is not used, see ListenAndServeTLS.
 func (sf *Server) ListenAndServer(addr string) error {
-	listen, err := net.Listen("tcp", addr)
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix://") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix://")
+	}
+	listen, err := net.Listen(network, addr)
 	if err != nil {
 		return err
 	}
@@ -78,15 +148,20 @@ func (sf *Server) ListenAndServer(addr string) error {
 	return nil
 }
 
+// Serve accept connections on the listener and run a session for each,
+// any net.Listener can be used, e.g. tcp, unix socket, or a wrapped listener.
+// it blocks until the listener failed or the server closed.
 func (sf *Server) Serve(listen net.Listener) {
 	sf.mux.Lock()
 	sf.listen = listen
 	sf.mux.Unlock()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx := sf.baseContext()
 	defer func() {
-		cancel()
-		_ = sf.Close()
+		// 优雅关闭时由Shutdown等待会话结束
+		if atomic.LoadUint32(&sf.shuttingDown) == 0 {
+			_ = sf.Close()
+		}
 		sf.Debug("server stop")
 	}()
 	sf.Debug("server run")
@@ -99,33 +174,120 @@ func (sf *Server) Serve(listen net.Listener) {
 
 		sf.wg.Add(1)
 		go func() {
-			sess := &SrvSession{
-				config:   &sf.config,
-				params:   &sf.params,
-				handler:  sf.handler,
-				conn:     conn,
-				rcvASDU:  make(chan []byte, sf.config.RecvUnAckLimitW<<4),
-				sendASDU: make(chan []byte, sf.config.SendUnAckLimitK<<4),
-				rcvRaw:   make(chan []byte, sf.config.RecvUnAckLimitW<<5),
-				sendRaw:  make(chan []byte, sf.config.SendUnAckLimitK<<5), // may not block!
-
-				onConnection:   sf.onConnection,
-				connectionLost: sf.connectionLost,
-				Clog:           sf.Clog,
-			}
-			sf.mux.Lock()
-			sf.sessions[sess] = struct{}{}
-			sf.mux.Unlock()
-			sess.run(ctx)
-			sf.mux.Lock()
-			delete(sf.sessions, sess)
-			sf.mux.Unlock()
+			sf.serveConn(ctx, conn)
 			sf.wg.Done()
 		}()
 	}
 }
 
-// Close close the server
+// ServeConn run a session on the connection which is already established,
+// e.g. one end of net.Pipe, a tunnel or a connection accepted by others.
+// it blocks until the session ends or the server closed.
+func (sf *Server) ServeConn(conn net.Conn) {
+	sf.wg.Add(1)
+	sf.serveConn(sf.baseContext(), conn)
+	sf.wg.Done()
+}
+
+// baseContext return the context of the server, which is canceled by Close
+func (sf *Server) baseContext() context.Context {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	if sf.ctx == nil {
+		sf.ctx, sf.cancel = context.WithCancel(context.Background())
+	}
+	return sf.ctx
+}
+
+// serveConn run the session until it ends
+func (sf *Server) serveConn(ctx context.Context, conn net.Conn) {
+	release, err := sf.admit(conn.RemoteAddr())
+	if err != nil {
+		sf.Warn("reject connection from %v, %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	defer release()
+
+	tlsState, err := sf.handshake(ctx, conn)
+	if err != nil {
+		sf.Warn("tls handshake with %v failed, %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	if tlsState != nil && sf.tlsLimits.SessionKeyLifetime > 0 {
+		var cancel context.CancelFunc
+		// go的tls不支持服务端重新协商,会话密钥到期后断开,由主站重新握手
+		ctx, cancel = context.WithTimeout(ctx, sf.tlsLimits.SessionKeyLifetime)
+		defer cancel()
+	}
+
+	group := sf.matchGroup(conn.RemoteAddr())
+	session := &Session{
+		id:          atomic.AddUint64(&sf.nextSessionID, 1),
+		remoteAddr:  conn.RemoteAddr(),
+		localAddr:   conn.LocalAddr(),
+		connectTime: time.Now(),
+		tlsState:    tlsState,
+	}
+	events := sf.events
+	if group != nil {
+		events = group.events
+		session.group = group.name
+	}
+	sf.mux.Lock()
+	access := sf.access
+	sf.mux.Unlock()
+	if access != nil {
+		session.roles = access.sessionRoles(session)
+	}
+	if sf.onAdmission != nil {
+		if err = sf.onAdmission(session); err != nil {
+			sf.Warn("reject session %d from %v, %v", session.id, conn.RemoteAddr(), err)
+			_ = conn.Close()
+			return
+		}
+	}
+
+	sess := &SrvSession{
+		config:   &sf.config,
+		params:   &sf.params,
+		handler:  sf.handler,
+		conn:     conn,
+		rcvASDU:  make(chan []byte, sf.config.RecvUnAckLimitW<<4),
+		sendASDU: make(chan []byte, sf.config.SendUnAckLimitK<<4),
+		rcvRaw:   make(chan []byte, sf.config.RecvUnAckLimitW<<5),
+		sendRaw:  make(chan []byte, sf.config.SendUnAckLimitK<<5), // may not block!
+		overflow: sf.overflow,
+		events:   events,
+
+		group:     group,
+		activated: sf.activate,
+		session:   session,
+		access:    access,
+		audit:     newAuditor(sf.auditSink),
+
+		onConnection:   sf.onConnection,
+		connectionLost: sf.connectionLost,
+		Clog:           sf.Clog,
+
+		onProtocolError:   sf.onProtocolError,
+		srvProtocolErrors: &sf.protocolErrors,
+		frameObserver:     sf.frameObserver,
+	}
+	sf.mux.Lock()
+	if atomic.LoadUint32(&sf.shuttingDown) == 1 {
+		sess.shutdown()
+	}
+	sf.sessions[sess] = struct{}{}
+	sf.mux.Unlock()
+	sess.run(ctx)
+	sf.mux.Lock()
+	delete(sf.sessions, sess)
+	sf.mux.Unlock()
+}
+
+// Close close the server and all the sessions
 func (sf *Server) Close() error {
 	var err error
 
@@ -134,21 +296,203 @@ func (sf *Server) Close() error {
 		err = sf.listen.Close()
 		sf.listen = nil
 	}
+	if sf.cancel != nil {
+		sf.cancel()
+		sf.ctx, sf.cancel = nil, nil
+	}
 	sf.mux.Unlock()
 	sf.wg.Wait()
 	return err
 }
 
-// Send imp interface Connect
+// Shutdown gracefully shut down the server, it stops accepting connections,
+// rejects Send, SendContext and SendTo with ErrShuttingDown, and each session is closed
+// once its queued asdus and buffered events are sent and all the I-frames are acknowledged,
+// the replies of the handlers are still allowed while draining.
+// a session with data transfer stopped can send nothing, it's closed at once,
+// the asdus it queued for the next STARTDT are discarded and their count is logged.
+// a controlled station never initiates STOPDT in IEC 60870-5-104, so the session is closed
+// without it. if ctx is done before all sessions closed, they are closed by force and ctx.Err() is returned.
+func (sf *Server) Shutdown(ctx context.Context) error {
+	sf.mux.Lock()
+	atomic.StoreUint32(&sf.shuttingDown, 1)
+	if sf.listen != nil {
+		_ = sf.listen.Close()
+		sf.listen = nil
+	}
+	for k := range sf.sessions {
+		k.shutdown()
+	}
+	sf.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sf.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		sf.Warn("shutdown timeout, close by force")
+	}
+	_ = sf.Close()
+	atomic.StoreUint32(&sf.shuttingDown, 0)
+	return err
+}
+
+// Send imp interface Connect, send the asdu to all sessions
+// with the overflow policy, return the errors of all sessions joined,
+// the error of each session is a *SessionError.
+// if the event buffer enabled, the asdu is kept in the buffer while no session is active,
+// the buffer is not drained yet or the event store is set, and each session with
+// data transfer active receives it from the buffer, see SetEventBuffer and SetEventStore.
+// for a redundancy group, the asdu is kept in the event queue of the group,
+// and sent by the only active connection of the group.
 func (sf *Server) Send(a *asdu.ASDU) error {
+	if atomic.LoadUint32(&sf.shuttingDown) == 1 {
+		return ErrShuttingDown
+	}
+	buffers, sessions := sf.dispatch()
+	errs := pushEvents(buffers, a)
+	for _, k := range sessions {
+		if err := k.Send(a.Clone()); err != nil {
+			errs = append(errs, &SessionError{k.session.id, err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SendContext send the asdu to all sessions, it blocks until each session
+// has free space in the send buffer or the ctx is done.
+// the event buffer is used as Send does.
+func (sf *Server) SendContext(ctx context.Context, a *asdu.ASDU) error {
+	if atomic.LoadUint32(&sf.shuttingDown) == 1 {
+		return ErrShuttingDown
+	}
+	buffers, sessions := sf.dispatch()
+	errs := pushEvents(buffers, a)
+	for _, k := range sessions {
+		if err := k.SendContext(ctx, a.Clone()); err != nil {
+			errs = append(errs, &SessionError{k.session.id, err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sessions return the sessions connected now, ordered by id
+func (sf *Server) Sessions() []*Session {
 	sf.mux.Lock()
+	sessions := make([]*Session, 0, len(sf.sessions))
 	for k := range sf.sessions {
-		_ = k.Send(a.Clone())
+		sessions = append(sessions, k.session)
 	}
 	sf.mux.Unlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	return sessions
+}
+
+// SendTo send the asdu only to the session with the id, the event buffer is not used,
+// it returns ErrSessionNotFound if the session does not exist.
+func (sf *Server) SendTo(id uint64, a *asdu.ASDU) error {
+	if atomic.LoadUint32(&sf.shuttingDown) == 1 {
+		return ErrShuttingDown
+	}
+	sess := sf.lookup(id)
+	if sess == nil {
+		return ErrSessionNotFound
+	}
+	return sess.Send(a)
+}
+
+// SendToContext send the asdu only to the session with the id, it blocks until
+// the session has free space in the send buffer or the ctx is done.
+func (sf *Server) SendToContext(ctx context.Context, id uint64, a *asdu.ASDU) error {
+	if atomic.LoadUint32(&sf.shuttingDown) == 1 {
+		return ErrShuttingDown
+	}
+	sess := sf.lookup(id)
+	if sess == nil {
+		return ErrSessionNotFound
+	}
+	return sess.SendContext(ctx, a)
+}
+
+// DisconnectSession close the connection of the session with the id
+func (sf *Server) DisconnectSession(id uint64) error {
+	sess := sf.lookup(id)
+	if sess == nil {
+		return ErrSessionNotFound
+	}
+	sf.Debug("disconnect session %d", id)
+	return sess.conn.Close()
+}
+
+// lookup return the session with the id, nil if not found
+func (sf *Server) lookup(id uint64) *SrvSession {
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	for k := range sf.sessions {
+		if k.session.id == id {
+			return k
+		}
+	}
 	return nil
 }
 
+// dispatch return the event buffers and sessions which the asdu should be sent to
+func (sf *Server) dispatch() ([]*eventBuffer, []*SrvSession) {
+	var buffers []*eventBuffer
+	var sessions []*SrvSession
+
+	sf.mux.Lock()
+	defer sf.mux.Unlock()
+	// 不属于任何冗余组的连接
+	if sf.events != nil && (sf.events.persistent() || sf.events.len() > 0 || !sf.hasActiveSession(nil)) {
+		buffers = append(buffers, sf.events)
+	} else {
+		for k := range sf.sessions {
+			if k.group == nil {
+				sessions = append(sessions, k)
+			}
+		}
+	}
+	// 冗余组的事件都经过组内队列,由唯一启动数据传输的连接发送,确认后才移除
+	for _, g := range sf.groups {
+		buffers = append(buffers, g.events)
+	}
+	return buffers, sessions
+}
+
+// pushEvents push the asdu to the event buffers
+func pushEvents(buffers []*eventBuffer, a *asdu.ASDU) []error {
+	if len(buffers) == 0 {
+		return nil
+	}
+	data, err := a.MarshalBinary()
+	if err != nil {
+		return []error{err}
+	}
+	var errs []error
+	for _, b := range buffers {
+		if err = b.push(data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// hasActiveSession 冗余组g内是否存在已启动数据传输的会话, nil表示不属于任何冗余组的会话, must hold the lock
+func (sf *Server) hasActiveSession(g *redundancyGroup) bool {
+	for k := range sf.sessions {
+		if k.group == g && k.IsActive() {
+			return true
+		}
+	}
+	return false
+}
+
 // Params imp interface Connect
 func (sf *Server) Params() *asdu.Params { return &sf.params }
 
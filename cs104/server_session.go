@@ -5,11 +5,13 @@
 package cs104
 
 import (
-	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"io"
 	"net"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,6 +38,16 @@ type SrvSession struct {
 	rcvRaw   chan []byte // for recvLoop raw cs104 frame
 	sendRaw  chan []byte // for sendLoop raw cs104 frame
 
+	overflow OverflowPolicy // sendASDU 满时的处理策略
+	events   *eventBuffer   // 站端事件缓存,启动数据传输后优先发送,可为nil
+
+	group     *redundancyGroup  // 所属冗余组,可为nil
+	activated func(*SrvSession) // 启动数据传输后回调,可为nil
+	session   *Session          // 会话标识及元数据
+	access    *accessControl    // 命令访问控制,可为nil
+	audit     *auditor          // 命令审计,可为nil
+	draining  uint32            // 优雅关闭中,发送完毕且全部确认后断开
+
 	// see subclass 5.1 — Protection against loss and duplication of messages
 	seqNoSend uint16 // sequence number of next outbound I-frame
 	ackNoSend uint16 // outbound sequence number yet to be confirmed
@@ -45,14 +57,21 @@ type SrvSession struct {
 	pending []seqPending
 	//seqManage
 
-	status uint32
-	rwMux  sync.RWMutex
+	status   uint32
+	rwMux    sync.RWMutex
+	isActive uint32
 
 	clog.Clog
 
 	onConnection   func(asdu.Connect)
 	connectionLost func(asdu.Connect)
 
+	onProtocolError   func(asdu.Connect, error)
+	protocolErrors    protocolErrors
+	srvProtocolErrors *protocolErrors // 服务端汇总计数,可为nil
+	stats             linkCounters
+	frameObserver     FrameObserver // 帧观察者,可为nil
+
 	wg     sync.WaitGroup
 	cancel context.CancelFunc
 	ctx    context.Context
@@ -67,49 +86,24 @@ func (sf *SrvSession) recvLoop() {
 		sf.Debug("recvLoop stopped!")
 	}()
 
-	var err error
-	var peekBuf []byte
-	var pkgLen byte
-	reader := bufio.NewReaderSize(sf.conn, 4096)
+	reader := NewAPDUReader(sf.conn)
 	for {
-		if peekBuf, err = reader.Peek(2); err != nil {
-			if err == io.EOF {
-				sf.Error("peek header remote connect closed")
-			} else {
-				sf.Error("peek header receive failed, %v", err)
-			}
-
-			return
-		}
-
-		if peekBuf[0] != startFrame {
-			sf.Error("receive apdu peek, %v", peekBuf)
-			return
-		}
-
-		pkgLen = 2 + peekBuf[1]
-		if _, err = reader.Peek(int(pkgLen)); err != nil {
-			if err == io.EOF {
-				sf.Error("peek pkg remote connect closed, %v", err)
-			} else {
-				sf.Error("peek pkg receive failed, %v", err)
-			}
-
-			return
-		}
-
-		rawData := make([]byte, pkgLen)
-		if _, err = io.ReadFull(reader, rawData); err != nil {
-			if err == io.EOF {
-				sf.Error("remote connect closed")
-			} else {
+		rawData, err := reader.ReadAPDU()
+		if err != nil {
+			switch {
+			case err == io.EOF || err == io.ErrUnexpectedEOF:
+				sf.Error("remote connect closed, %v", err)
+			case errors.Is(err, ErrFrameTooLong):
+				sf.protocolError(err)
+			default:
 				sf.Error("receive failed, %v", err)
 			}
-
 			return
 		}
 
 		sf.Debug("RX Raw[% x]", rawData)
+		sf.stats.received(rawData)
+		sf.observeFrame(FrameReceived, rawData)
 		sf.rcvRaw <- rawData
 	}
 }
@@ -123,29 +117,19 @@ func (sf *SrvSession) sendLoop() {
 		sf.Debug("sendLoop stopped!")
 	}()
 
+	writer := NewAPDUWriter(sf.conn)
 	for {
 		select {
 		case <-sf.ctx.Done():
 			return
 		case apdu := <-sf.sendRaw:
 			sf.Debug("TX Raw[% x]", apdu)
-			for wrCnt := 0; len(apdu) > wrCnt; {
-				byteCount, err := sf.conn.Write(apdu[wrCnt:])
-				if err != nil {
-					// See: https://github.com/golang/go/issues/4373
-					if err != io.EOF && err != io.ErrClosedPipe ||
-						strings.Contains(err.Error(), "use of closed network connection") {
-						sf.Error("sendRaw failed, %v", err)
-						return
-					}
-					if e, ok := err.(net.Error); !ok || !e.Temporary() {
-						sf.Error("sendRaw failed, %v", err)
-						return
-					}
-					// temporary error may be recoverable
-				}
-				wrCnt += byteCount
+			if err := writer.WriteAPDU(apdu); err != nil {
+				sf.Error("sendRaw failed, %v", err)
+				return
 			}
+			sf.stats.sent(apdu)
+			sf.observeFrame(FrameSent, apdu)
 		}
 	}
 }
@@ -164,7 +148,7 @@ func (sf *SrvSession) run(ctx context.Context) {
 	go sf.handlerLoop()
 
 	// default: STOPDT, when connected establish and not enable "data transfer" yet
-	var isActive = false
+	atomic.StoreUint32(&sf.isActive, inactive)
 	var checkTicker = time.NewTicker(timeoutResolution)
 
 	// transmission timestamps for timeout calculation
@@ -173,41 +157,59 @@ func (sf *SrvSession) run(ctx context.Context) {
 	var unAckRcvSince = willNotTimeout
 	var idleTimeout3Sine = time.Now()         // 空闲间隔发起testFrAlive
 	var testFrAliveSendSince = willNotTimeout // 当发起testFrAlive时,等待确认回复的超时间隔
-	// 对于server端，无需对应的U-Frame 无需判断
-	// var startDtActiveSendSince = willNotTimeout
-	// var stopDtActiveSendSince = willNotTimeout
+	// 对于server端,不发起STARTDT/STOPDT,仅需监视TESTFR的确认
+	// 收到STOPDT激活时,待已发送的I帧全部确认后才回复确认,期间不再发送新的I帧
+	var stopDtActiveRecvSince = willNotTimeout
 
 	sendSFrame := func(rcvSN uint16) {
-		sf.Debug("TX sFrame %v", sAPCI{rcvSN})
-		sf.sendRaw <- newSFrame(rcvSN)
+		sf.Debug("TX sFrame %v", SAPCI{rcvSN})
+		sf.sendRaw <- NewSFrame(rcvSN)
 	}
 	sendUFrame := func(which byte) {
-		sf.Debug("TX uFrame %v", uAPCI{which})
-		sf.sendRaw <- newUFrame(which)
+		sf.Debug("TX uFrame %v", UAPCI{which})
+		sf.sendRaw <- NewUFrame(which)
 	}
 
-	sendIFrame := func(asdu1 []byte) {
+	sendIFrame := func(asdu1 []byte, eventID uint64) {
 		seqNo := sf.seqNoSend
 
-		iframe, err := newIFrame(seqNo, sf.seqNoRcv, asdu1)
+		iframe, err := NewIFrame(seqNo, sf.seqNoRcv, asdu1)
 		if err != nil {
 			return
 		}
 		sf.ackNoRcv = sf.seqNoRcv
 		sf.seqNoSend = (seqNo + 1) & 32767
-		sf.pending = append(sf.pending, seqPending{seqNo & 32767, time.Now()})
+		sf.pending = append(sf.pending, seqPending{seqNo & 32767, time.Now(), eventID})
+		sf.stats.setUnacked(int(seqNoCount(sf.ackNoSend, sf.seqNoSend)))
 
-		sf.Debug("TX iFrame %v", iAPCI{seqNo, sf.seqNoRcv})
+		sf.Debug("TX iFrame %v", IAPCI{seqNo, sf.seqNoRcv})
 		sf.sendRaw <- iframe
 	}
+	// confirmStopDt 未确认的I帧都已确认,先确认收到的I帧,再回复STOPDT确认
+	confirmStopDt := func() {
+		if stopDtActiveRecvSince == willNotTimeout || sf.ackNoSend != sf.seqNoSend {
+			return
+		}
+		if sf.ackNoRcv != sf.seqNoRcv {
+			sendSFrame(sf.seqNoRcv)
+			sf.ackNoRcv = sf.seqNoRcv
+		}
+		sendUFrame(UStopDtConfirm)
+		atomic.StoreUint32(&sf.isActive, inactive)
+		sf.releasePending()
+		stopDtActiveRecvSince = willNotTimeout
+	}
 	if sf.onConnection != nil {
 		sf.onConnection(sf)
 	}
 	defer func() {
+		atomic.StoreUint32(&sf.isActive, inactive)
+		sf.releasePending()
 		sf.setConnectStatus(disconnected)
 		checkTicker.Stop()
 		_ = sf.conn.Close() // 连锁引发cancel
 		sf.wg.Wait()
+		sf.observeClose()
 		if sf.connectionLost != nil {
 			sf.connectionLost(sf)
 		}
@@ -215,10 +217,17 @@ func (sf *SrvSession) run(ctx context.Context) {
 	}()
 
 	for {
-		if isActive && seqNoCount(sf.ackNoSend, sf.seqNoSend) <= sf.config.SendUnAckLimitK {
+		if atomic.LoadUint32(&sf.isActive) == active && stopDtActiveRecvSince == willNotTimeout &&
+			seqNoCount(sf.ackNoSend, sf.seqNoSend) <= sf.config.SendUnAckLimitK {
+			// 优先发送缓存的事件,保证事件顺序
+			if id, o, ok := sf.events.pop(sf); ok {
+				sendIFrame(o, id)
+				idleTimeout3Sine = time.Now()
+				continue
+			}
 			select {
 			case o := <-sf.sendASDU:
-				sendIFrame(o)
+				sendIFrame(o, 0)
 				idleTimeout3Sine = time.Now()
 				continue
 			case <-sf.ctx.Done():
@@ -232,17 +241,17 @@ func (sf *SrvSession) run(ctx context.Context) {
 		case now := <-checkTicker.C:
 			// check all timeouts
 			if now.Sub(testFrAliveSendSince) >= sf.config.SendUnAckTimeout1 {
-				// now.Sub(startDtActiveSendSince) >= t.SendUnAckTimeout1 ||
-				// now.Sub(stopDtActiveSendSince) >= t.SendUnAckTimeout1 ||
-				sf.Error("test frame alive confirm timeout t₁")
+				atomic.AddUint64(&sf.stats.t1, 1)
+				sf.protocolError(fmt.Errorf("%w, TESTFR con", ErrT1Timeout))
 				return
 			}
-			// check oldest unacknowledged outbound
+			// check oldest unacknowledged outbound, it also limits the wait of STOPDT confirmation to t₁
 			if sf.ackNoSend != sf.seqNoSend &&
 				//now.Sub(sf.peek()) >= sf.SendUnAckTimeout1 {
 				now.Sub(sf.pending[0].sendTime) >= sf.config.SendUnAckTimeout1 {
 				sf.ackNoSend++
-				sf.Error("fatal transmission timeout t₁")
+				atomic.AddUint64(&sf.stats.t1, 1)
+				sf.protocolError(fmt.Errorf("%w, I-frame %d not acknowledged", ErrT1Timeout, sf.pending[0].seq))
 				return
 			}
 
@@ -250,36 +259,56 @@ func (sf *SrvSession) run(ctx context.Context) {
 			if sf.ackNoRcv != sf.seqNoRcv &&
 				(now.Sub(unAckRcvSince) >= sf.config.RecvUnAckTimeout2 ||
 					now.Sub(idleTimeout3Sine) >= timeoutResolution) {
+				if now.Sub(unAckRcvSince) >= sf.config.RecvUnAckTimeout2 {
+					atomic.AddUint64(&sf.stats.t2, 1)
+				}
 				sendSFrame(sf.seqNoRcv)
 				sf.ackNoRcv = sf.seqNoRcv
 			}
 
+			confirmStopDt()
+
+			// 优雅关闭: 发送完毕,所有I帧均已确认,且已确认收到的I帧后断开
+			if atomic.LoadUint32(&sf.draining) == 1 && sf.drained() {
+				if n := len(sf.sendASDU); n > 0 {
+					sf.Warn("session drained with data transfer stopped, %d queued asdus discarded", n)
+				}
+				sf.Debug("session drained, disconnect")
+				return
+			}
+
 			// 空闲时间到，发送TestFrActive帧,保活
 			if now.Sub(idleTimeout3Sine) >= sf.config.IdleTimeout3 {
-				sendUFrame(uTestFrActive)
+				atomic.AddUint64(&sf.stats.t3, 1)
+				sendUFrame(UTestFrActive)
 				testFrAliveSendSince = time.Now()
 				idleTimeout3Sine = testFrAliveSendSince
 			}
 
 		case apdu := <-sf.rcvRaw:
 			idleTimeout3Sine = time.Now() // 每收到一个i帧,S帧,U帧, 重置空闲定时器, t3
-			apci, asduVal := parse(apdu)
+			apci, asduVal := ParseAPDU(apdu)
 			switch head := apci.(type) {
-			case sAPCI:
+			case SAPCI:
 				sf.Debug("RX sFrame %v", head)
-				if !sf.updateAckNoOut(head.rcvSN) {
-					sf.Error("fatal incoming acknowledge either earlier than previous or later than sendTime")
+				if !sf.updateAckNoOut(head.RcvSN) {
+					sf.protocolError(fmt.Errorf("%w, acknowledge %d not in [%d, %d]", ErrSequence, head.RcvSN, sf.ackNoSend, sf.seqNoSend))
 					return
 				}
+				confirmStopDt()
 
-			case iAPCI:
+			case IAPCI:
 				sf.Debug("RX iFrame %v", head)
-				if !isActive {
+				if atomic.LoadUint32(&sf.isActive) == inactive {
 					sf.Warn("station not active")
 					break // not active, discard apdu
 				}
-				if !sf.updateAckNoOut(head.rcvSN) || head.sendSN != sf.seqNoRcv {
-					sf.Error("fatal incoming acknowledge either earlier than previous or later than sendTime")
+				if !sf.updateAckNoOut(head.RcvSN) {
+					sf.protocolError(fmt.Errorf("%w, acknowledge %d not in [%d, %d]", ErrSequence, head.RcvSN, sf.ackNoSend, sf.seqNoSend))
+					return
+				}
+				if head.SendSN != sf.seqNoRcv {
+					sf.protocolError(fmt.Errorf("%w, send sequence %d, expected %d", ErrSequence, head.SendSN, sf.seqNoRcv))
 					return
 				}
 
@@ -294,27 +323,41 @@ func (sf *SrvSession) run(ctx context.Context) {
 					sf.ackNoRcv = sf.seqNoRcv
 				}
 
-			case uAPCI:
+			case UAPCI:
 				sf.Debug("RX uFrame %v", head)
-				switch head.function {
-				case uStartDtActive:
-					sendUFrame(uStartDtConfirm)
-					isActive = true
-				// case uStartDtConfirm:
-				// 	isActive = true
-				// 	startDtActiveSendSince = willNotTimeout
-				case uStopDtActive:
-					sendUFrame(uStopDtConfirm)
-					isActive = false
-				// case uStopDtConfirm:
-				// 	isActive = false
-				// 	stopDtActiveSendSince = willNotTimeout
-				case uTestFrActive:
-					sendUFrame(uTestFrConfirm)
-				case uTestFrConfirm:
+				switch head.Function {
+				case UStartDtActive:
+					stopDtActiveRecvSince = willNotTimeout
+					atomic.StoreUint32(&sf.isActive, active)
+					// 冗余组内原连接的未确认事件先归还,再接管缓存事件,最后回复确认,保证事件顺序
+					if sf.activated != nil {
+						sf.activated(sf)
+					}
+					sf.events.register(sf)
+					sendUFrame(UStartDtConfirm)
+				case UStopDtActive:
+					if atomic.LoadUint32(&sf.isActive) == inactive {
+						sendUFrame(UStopDtConfirm)
+						break
+					}
+					// 未发送的ASDU保留在队列中,待下次STARTDT后发送
+					if stopDtActiveRecvSince == willNotTimeout {
+						stopDtActiveRecvSince = time.Now()
+					}
+					confirmStopDt()
+				case UTestFrActive:
+					sendUFrame(UTestFrConfirm)
+				case UTestFrConfirm:
+					if testFrAliveSendSince == willNotTimeout {
+						sf.protocolError(fmt.Errorf("%w, TESTFR con without TESTFR act, ignored", ErrUnexpectedUFrame))
+						break
+					}
 					testFrAliveSendSince = willNotTimeout
+				case UStartDtConfirm, UStopDtConfirm:
+					// 被控站不发起STARTDT/STOPDT
+					sf.protocolError(fmt.Errorf("%w, functions[0x%02x] ignored", ErrUnexpectedUFrame, head.Function))
 				default:
-					sf.Error("illegal U-Frame functions[0x%02x] ignored", head.function)
+					sf.protocolError(fmt.Errorf("%w, illegal functions[0x%02x] ignored", ErrUnexpectedUFrame, head.Function))
 				}
 			}
 		}
@@ -365,6 +408,7 @@ func (sf *SrvSession) cleanUp() {
 	sf.seqNoRcv = 0
 	sf.seqNoSend = 0
 	sf.pending = nil
+	sf.stats.setUnacked(0)
 	// clear sending chan buffer
 loop:
 	for {
@@ -398,13 +442,23 @@ func (sf *SrvSession) updateAckNoOut(ackNo uint16) (ok bool) {
 
 	// confirm reception
 	for i, v := range sf.pending {
-		if v.seq == (ackNo - 1) {
+		if v.seq == (ackNo-1)&32767 {
+			for _, p := range sf.pending[:i+1] {
+				if p.eventID == 0 {
+					continue
+				}
+				if err := sf.events.ack(sf, p.eventID); err != nil {
+					sf.Warn("event store remove failed, %v", err)
+				}
+			}
+			sf.stats.acked(v.sendTime)
 			sf.pending = sf.pending[i+1:]
 			break
 		}
 	}
 
 	sf.ackNoSend = ackNo
+	sf.stats.setUnacked(int(seqNoCount(sf.ackNoSend, sf.seqNoSend)))
 	return true
 }
 
@@ -417,6 +471,10 @@ func (sf *SrvSession) serverHandler(asduPack *asdu.ASDU) error {
 
 	sf.Debug("ASDU %+v", asduPack)
 
+	if !sf.checkAccess(asduPack) {
+		return nil
+	}
+
 	switch asduPack.Identifier.Type {
 	case asdu.C_IC_NA_1: // InterrogationCmd
 		if !(asduPack.Identifier.Coa.Cause == asdu.Activation ||
@@ -475,7 +533,8 @@ func (sf *SrvSession) serverHandler(asduPack *asdu.ASDU) error {
 		if asduPack.CommonAddr == asdu.InvalidCommonAddr {
 			return asduPack.SendReplyMirror(sf, asdu.UnknownCA)
 		}
-		ioa, _ := asduPack.GetTestCommand()
+		// 解析副本,镜像回复保留信息对象
+		ioa, _ := asduPack.Clone().GetTestCommand()
 		if ioa != asdu.InfoObjAddrIrrelevant {
 			return asduPack.SendReplyMirror(sf, asdu.UnknownIOA)
 		}
@@ -519,13 +578,72 @@ func (sf *SrvSession) IsConnected() bool {
 	return sf.connectStatus() == connected
 }
 
+// IsActive get server session data transfer state, true after STARTDT
+func (sf *SrvSession) IsActive() bool {
+	return atomic.LoadUint32(&sf.isActive) == active
+}
+
+// releasePending 停止接收缓存事件,未确认的事件交由其他或下一个启动数据传输的连接重发
+func (sf *SrvSession) releasePending() {
+	sf.events.unregister(sf)
+}
+
+// drained return true if nothing left to send and all the I-frames are acknowledged,
+// an inactive session can send nothing, so it's always drained, even if asdus are
+// still queued for the next STARTDT.
+func (sf *SrvSession) drained() bool {
+	if atomic.LoadUint32(&sf.isActive) == inactive {
+		return true
+	}
+	return len(sf.sendASDU) == 0 && sf.events.remaining(sf) == 0 &&
+		sf.ackNoSend == sf.seqNoSend && sf.ackNoRcv == sf.seqNoRcv
+}
+
+// shutdown disconnect the session after drained
+func (sf *SrvSession) shutdown() {
+	atomic.StoreUint32(&sf.draining, 1)
+}
+
+// deactivate stop data transfer, another connection of the redundancy group took over,
+// the unacknowledged events are released at once for the new one.
+func (sf *SrvSession) deactivate() {
+	atomic.StoreUint32(&sf.isActive, inactive)
+	sf.releasePending()
+}
+
+// Session get the identity and metadata of the session
+func (sf *SrvSession) Session() *Session {
+	return sf.session
+}
+
+// TLSConnectionState get the connection state of the secure connection,
+// false if the session is not over tls.
+func (sf *SrvSession) TLSConnectionState() (tls.ConnectionState, bool) {
+	return sf.session.TLSConnectionState()
+}
+
+// PeerCertificate get the certificate of the master, nil if the session is not over tls.
+func (sf *SrvSession) PeerCertificate() *x509.Certificate {
+	return sf.session.PeerCertificate()
+}
+
 // Params get params
 func (sf *SrvSession) Params() *asdu.Params {
 	return sf.params
 }
 
-// Send asdu frame
+// Send asdu frame, when the send buffer is full it behaves as the overflow policy
 func (sf *SrvSession) Send(u *asdu.ASDU) error {
+	return sf.send(context.Background(), u, sf.overflow)
+}
+
+// SendContext send asdu frame, it blocks until the send buffer has free space,
+// the ctx is done or the session is disconnected.
+func (sf *SrvSession) SendContext(ctx context.Context, u *asdu.ASDU) error {
+	return sf.send(ctx, u, OverflowBlock)
+}
+
+func (sf *SrvSession) send(ctx context.Context, u *asdu.ASDU, policy OverflowPolicy) error {
 	if !sf.IsConnected() {
 		return ErrUseClosedConnection
 	}
@@ -533,12 +651,12 @@ func (sf *SrvSession) Send(u *asdu.ASDU) error {
 	if err != nil {
 		return err
 	}
-	select {
-	case sf.sendASDU <- data:
-	default:
-		return ErrBufferFulled
+	err = enqueue(ctx, sf.ctx, sf.sendASDU, data, policy, sf.cancel)
+	if err == ErrBufferFulled && policy == OverflowDisconnect {
+		sf.Error("send buffer is full, disconnect")
 	}
-	return nil
+	sf.auditRecord(AuditSent, u, "", err)
+	return err
 }
 
 // UnderlyingConn got under net.conn
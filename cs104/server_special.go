@@ -6,8 +6,8 @@ package cs104
 
 import (
 	"context"
-	"errors"
-	"math/rand"
+	"crypto/tls"
+	"net"
 	"sync/atomic"
 	"time"
 
@@ -33,8 +33,9 @@ type ServerSpecial interface {
 
 type serverSpec struct {
 	SrvSession
-	option      ClientOption
-	closeCancel context.CancelFunc
+	option        ClientOption
+	closeCancel   context.CancelFunc
+	nextSessionID uint64
 }
 
 // NewServerSpecial new special server
@@ -49,6 +50,7 @@ func NewServerSpecial(handler ServerHandlerInterface, o *ClientOption) ServerSpe
 			sendASDU: make(chan []byte, 1024),
 			rcvRaw:   make(chan []byte, 1024),
 			sendRaw:  make(chan []byte, 1024), // may not block!
+			overflow: o.overflow,
 
 			Clog: clog.NewLogger("cs104 serverSpec => "),
 		},
@@ -68,8 +70,8 @@ func (sf *serverSpec) SetConnectionLostHandler(f func(c asdu.Connect)) {
 
 // Start start the server,and return quickly,if it nil,the server will disconnected background,other failed
 func (sf *serverSpec) Start() error {
-	if sf.option.server == nil {
-		return errors.New("empty remote server")
+	if err := sf.option.ensureServers(); err != nil {
+		return err
 	}
 
 	go sf.running()
@@ -89,6 +91,7 @@ func (sf *serverSpec) running() {
 	sf.rwMux.Unlock()
 	defer sf.setConnectStatus(initial)
 
+	last, attempt := -1, 0
 	for {
 		select {
 		case <-ctx.Done():
@@ -96,30 +99,50 @@ func (sf *serverSpec) running() {
 		default:
 		}
 
-		sf.Debug("connecting server %+v", sf.option.server)
-		conn, err := openConnection(sf.option.server, sf.option.TLSConfig, sf.config.ConnectTimeout0)
-		if err != nil {
-			sf.Error("connect failed, %v", err)
-			if !sf.option.autoReconnect {
-				return
+		connected := false
+		for _, i := range sf.option.endpointOrder(ctx, last, nil) {
+			last = i
+			server := sf.option.servers[i]
+			sf.Debug("connecting server %+v", server)
+			conn, err := sf.option.openConnection(ctx, server)
+			if err != nil {
+				sf.Error("connect failed, %v", err)
+				continue
 			}
-			time.Sleep(sf.option.reconnectInterval)
-			continue
+			sf.Debug("connect success")
+			connected, attempt = true, 0
+			sf.conn = conn
+			sf.session = sf.newSession(conn)
+			sf.run(ctx)
+			sf.Debug("disconnected server %+v", server)
+			break
 		}
-		sf.Debug("connect success")
-		sf.conn = conn
-		sf.run(ctx)
-		sf.Debug("disconnected server %+v", sf.option.server)
-		select {
-		case <-ctx.Done():
+
+		if !connected && !sf.option.autoReconnect {
+			return
+		}
+		attempt++
+		if !sleepContext(ctx, sf.option.reconnectDelay(attempt, !connected)) {
 			return
-		default:
-			// 随机500ms-1s的重试，避免快速重试造成服务器许多无效连接
-			time.Sleep(time.Millisecond * time.Duration(500+rand.Intn(500)))
 		}
 	}
 }
 
+// newSession new the identity and metadata of the link established
+func (sf *serverSpec) newSession(conn net.Conn) *Session {
+	session := &Session{
+		id:          atomic.AddUint64(&sf.nextSessionID, 1),
+		remoteAddr:  conn.RemoteAddr(),
+		localAddr:   conn.LocalAddr(),
+		connectTime: time.Now(),
+	}
+	if c, ok := conn.(*tls.Conn); ok {
+		cs := c.ConnectionState()
+		session.tlsState = &cs
+	}
+	return session
+}
+
 func (sf *serverSpec) IsClosed() bool {
 	return sf.connectStatus() == initial
 }
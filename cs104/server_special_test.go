@@ -0,0 +1,43 @@
+package cs104
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+func TestServerSpecial_Session(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listen.Close()
+
+	o := NewOption()
+	require.NoError(t, o.AddRemoteServer(listen.Addr().String()))
+	srv := NewServerSpecial(nopServerHandler{}, o)
+	connected := make(chan asdu.Connect, 1)
+	srv.SetOnConnectHandler(func(c asdu.Connect) { connected <- c })
+	require.NoError(t, srv.Start())
+	defer srv.Close()
+
+	conn, err := listen.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var c asdu.Connect
+	select {
+	case c = <-connected:
+	case <-time.After(3 * time.Second):
+		t.Fatal("not connected")
+	}
+	session := SessionOf(c)
+	require.NotNil(t, session)
+	assert.Equal(t, uint64(1), session.ID())
+	assert.Equal(t, conn.LocalAddr().String(), session.RemoteAddr().String())
+	_, ok := c.(*SrvSession).TLSConnectionState()
+	assert.False(t, ok)
+	assert.Nil(t, c.(*SrvSession).PeerCertificate())
+}
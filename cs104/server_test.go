@@ -0,0 +1,413 @@
+package cs104
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+type nopServerHandler struct{}
+
+func (nopServerHandler) InterrogationHandler(asdu.Connect, *asdu.ASDU, asdu.QualifierOfInterrogation) error {
+	return nil
+}
+func (nopServerHandler) CounterInterrogationHandler(asdu.Connect, *asdu.ASDU, asdu.QualifierCountCall) error {
+	return nil
+}
+func (nopServerHandler) ReadHandler(asdu.Connect, *asdu.ASDU, asdu.InfoObjAddr) error { return nil }
+func (nopServerHandler) ClockSyncHandler(asdu.Connect, *asdu.ASDU, time.Time) error   { return nil }
+func (nopServerHandler) ResetProcessHandler(asdu.Connect, *asdu.ASDU, asdu.QualifierOfResetProcessCmd) error {
+	return nil
+}
+func (nopServerHandler) DelayAcquisitionHandler(asdu.Connect, *asdu.ASDU, uint16) error { return nil }
+func (nopServerHandler) ASDUHandler(asdu.Connect, *asdu.ASDU) error                     { return nil }
+
+// startTestServer serve srv on a random local port and return the address
+func startTestServer(t *testing.T, srv *Server) string {
+	t.Helper()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(listen)
+	t.Cleanup(func() { _ = srv.Close() })
+	return listen.Addr().String()
+}
+
+// readAPDU read one apdu from r
+func readAPDU(t *testing.T, conn net.Conn, r *bufio.Reader) []byte {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	head := make([]byte, 2)
+	_, err := io.ReadFull(r, head)
+	require.NoError(t, err)
+	apdu := make([]byte, 2+int(head[1]))
+	copy(apdu, head)
+	_, err = io.ReadFull(r, apdu[2:])
+	require.NoError(t, err)
+	return apdu
+}
+
+func singlePoint(t *testing.T, ioa asdu.InfoObjAddr) *asdu.ASDU {
+	t.Helper()
+	a := asdu.NewASDU(asdu.ParamsWide, asdu.Identifier{
+		Type:       asdu.M_SP_NA_1,
+		Variable:   asdu.VariableStruct{Number: 1},
+		Coa:        asdu.CauseOfTransmission{Cause: asdu.Spontaneous},
+		CommonAddr: 1,
+	})
+	require.NoError(t, a.AppendInfoObjAddr(ioa))
+	a.AppendBytes(0x01)
+	return a
+}
+
+func TestServer_EventBuffer(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	srv.SetEventBuffer(8, OverflowDropNewest)
+
+	// no session, events must be buffered
+	require.NoError(t, srv.Send(singlePoint(t, 1)))
+	require.NoError(t, srv.Send(singlePoint(t, 2)))
+	assert.Equal(t, 2, srv.EventBufferStats().Len)
+
+	addr := startTestServer(t, srv)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// connected but not active, still buffered
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, srv.Send(singlePoint(t, 3)))
+	assert.Equal(t, 3, srv.EventBufferStats().Len)
+
+	_, err = conn.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+
+	for i := 1; i <= 3; i++ {
+		apdu := readAPDU(t, conn, r)
+		head, raw := ParseAPDU(apdu)
+		assert.Equal(t, IAPCI{SendSN: uint16(i - 1)}, head)
+		a := asdu.NewEmptyASDU(asdu.ParamsWide)
+		require.NoError(t, a.UnmarshalBinary(raw))
+		assert.Equal(t, asdu.InfoObjAddr(i), a.GetSinglePoint()[0].Ioa)
+	}
+	assert.Equal(t, 3, srv.EventBufferStats().InFlight)
+
+	// acknowledged, events removed
+	_, err = conn.Write(NewSFrame(3))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	stats := srv.EventBufferStats()
+	assert.Equal(t, 0, stats.Len)
+	assert.Equal(t, uint64(3), stats.Delivered)
+}
+
+func TestServer_EventStoreReplay(t *testing.T) {
+	store, err := OpenFileEventStore(t.TempDir() + "/events.log")
+	require.NoError(t, err)
+	defer store.Close()
+
+	srv := NewServer(nopServerHandler{})
+	srv.SetEventBuffer(8, OverflowDropNewest)
+	require.NoError(t, srv.SetEventStore(store))
+	require.NoError(t, srv.Send(singlePoint(t, 1)))
+	require.NoError(t, srv.Send(singlePoint(t, 2)))
+	addr := startTestServer(t, srv)
+
+	receive := func() {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		_, err = conn.Write(NewUFrame(UStartDtActive))
+		require.NoError(t, err)
+		assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+		for i := 1; i <= 2; i++ {
+			head, raw := ParseAPDU(readAPDU(t, conn, r))
+			assert.Equal(t, IAPCI{SendSN: uint16(i - 1)}, head)
+			a := asdu.NewEmptyASDU(asdu.ParamsWide)
+			require.NoError(t, a.UnmarshalBinary(raw))
+			assert.Equal(t, asdu.InfoObjAddr(i), a.GetSinglePoint()[0].Ioa)
+		}
+	}
+
+	// lost without acknowledge, events are sent again on the next connection
+	receive()
+	require.Eventually(t, func() bool { return srv.EventBufferStats().InFlight == 0 }, time.Second, 10*time.Millisecond)
+	events, err := store.Load()
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+	receive()
+}
+
+func TestServer_EventStoreMultiMaster(t *testing.T) {
+	store, err := OpenFileEventStore(t.TempDir() + "/events.log")
+	require.NoError(t, err)
+	defer store.Close()
+
+	srv := NewServer(nopServerHandler{})
+	srv.SetEventBuffer(16, OverflowDropNewest)
+	require.NoError(t, srv.SetEventStore(store))
+	addr := startTestServer(t, srv)
+
+	type master struct {
+		conn net.Conn
+		r    *bufio.Reader
+	}
+	masters := make([]master, 2)
+	for i := range masters {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		masters[i] = master{conn, bufio.NewReader(conn)}
+		_, err = conn.Write(NewUFrame(UStartDtActive))
+		require.NoError(t, err)
+		assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, masters[i].r))
+	}
+
+	const count = 5
+	for i := 1; i <= count; i++ {
+		require.NoError(t, srv.Send(singlePoint(t, asdu.InfoObjAddr(i))))
+	}
+
+	// every master receives every event in order
+	for _, m := range masters {
+		for i := 1; i <= count; i++ {
+			head, raw := ParseAPDU(readAPDU(t, m.conn, m.r))
+			assert.Equal(t, IAPCI{SendSN: uint16(i - 1)}, head)
+			a := asdu.NewEmptyASDU(asdu.ParamsWide)
+			require.NoError(t, a.UnmarshalBinary(raw))
+			assert.Equal(t, asdu.InfoObjAddr(i), a.GetSinglePoint()[0].Ioa)
+		}
+	}
+
+	// kept in the store until all the masters acknowledged
+	_, err = masters[0].conn.Write(NewSFrame(count))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return srv.EventBufferStats().InFlight == count }, time.Second, 10*time.Millisecond)
+	events, err := store.Load()
+	require.NoError(t, err)
+	assert.Len(t, events, count)
+
+	_, err = masters[1].conn.Write(NewSFrame(count))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return srv.EventBufferStats().Len == 0 }, time.Second, 10*time.Millisecond)
+	events, err = store.Load()
+	require.NoError(t, err)
+	assert.Empty(t, events)
+	assert.Equal(t, uint64(count), srv.EventBufferStats().Delivered)
+}
+
+func TestServer_RedundancyGroup(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	require.NoError(t, srv.AddRedundancyGroup(RedundancyGroup{Name: "scada", Clients: []string{"127.0.0.1"}}))
+	assert.Error(t, srv.AddRedundancyGroup(RedundancyGroup{Name: "other", Clients: []string{"127.0.0.1"}}))
+	assert.Error(t, srv.AddRedundancyGroup(RedundancyGroup{Name: "invalid", Clients: []string{"localhost"}}))
+	addr := startTestServer(t, srv)
+
+	readIOA := func(conn net.Conn, r *bufio.Reader, sendSN uint16) asdu.InfoObjAddr {
+		head, raw := ParseAPDU(readAPDU(t, conn, r))
+		assert.Equal(t, IAPCI{SendSN: sendSN}, head)
+		a := asdu.NewEmptyASDU(asdu.ParamsWide)
+		require.NoError(t, a.UnmarshalBinary(raw))
+		return a.GetSinglePoint()[0].Ioa
+	}
+
+	conn1, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn1.Close()
+	r1 := bufio.NewReader(conn1)
+	conn2, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn2.Close()
+	r2 := bufio.NewReader(conn2)
+
+	_, err = conn1.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn1, r1))
+	require.NoError(t, srv.Send(singlePoint(t, 1)))
+	assert.Equal(t, asdu.InfoObjAddr(1), readIOA(conn1, r1, 0))
+
+	// the standby takes over, the unacknowledged event carries over
+	_, err = conn2.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn2, r2))
+	assert.Equal(t, asdu.InfoObjAddr(1), readIOA(conn2, r2, 0))
+
+	require.NoError(t, srv.Send(singlePoint(t, 2)))
+	assert.Equal(t, asdu.InfoObjAddr(2), readIOA(conn2, r2, 1))
+	_, err = conn2.Write(NewSFrame(2))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		stats, ok := srv.RedundancyGroupStats("scada")
+		return ok && stats.Len == 0 && stats.Delivered == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the deactivated connection receives nothing
+	_ = conn1.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = r1.ReadByte()
+	assert.Error(t, err)
+}
+
+func TestServer_RedundancySwitchoverOrder(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	require.NoError(t, srv.AddRedundancyGroup(RedundancyGroup{Name: "scada", Clients: []string{"127.0.0.1"}}))
+	addr := startTestServer(t, srv)
+
+	readIOA := func(conn net.Conn, r *bufio.Reader) asdu.InfoObjAddr {
+		_, raw := ParseAPDU(readAPDU(t, conn, r))
+		a := asdu.NewEmptyASDU(asdu.ParamsWide)
+		require.NoError(t, a.UnmarshalBinary(raw))
+		return a.GetSinglePoint()[0].Ioa
+	}
+
+	conn1, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn1.Close()
+	r1 := bufio.NewReader(conn1)
+	_, err = conn1.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn1, r1))
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, srv.Send(singlePoint(t, asdu.InfoObjAddr(i))))
+		assert.Equal(t, asdu.InfoObjAddr(i), readIOA(conn1, r1))
+	}
+
+	// the unacknowledged events are taken over before the STARTDT con,
+	// so they come before the event sent right after it
+	conn2, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn2.Close()
+	r2 := bufio.NewReader(conn2)
+	_, err = conn2.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn2, r2))
+	require.NoError(t, srv.Send(singlePoint(t, 4)))
+	for i := 1; i <= 4; i++ {
+		assert.Equal(t, asdu.InfoObjAddr(i), readIOA(conn2, r2))
+	}
+}
+
+func TestServer_SendTo(t *testing.T) {
+	srv := NewServer(interrogationServerHandler{})
+	addr := startTestServer(t, srv)
+
+	var conns []net.Conn
+	var readers []*bufio.Reader
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		_, err = conn.Write(NewUFrame(UStartDtActive))
+		require.NoError(t, err)
+		assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+		conns, readers = append(conns, conn), append(readers, r)
+	}
+	readIOA := func(i int, sendSN uint16) asdu.InfoObjAddr {
+		head, raw := ParseAPDU(readAPDU(t, conns[i], readers[i]))
+		require.IsType(t, IAPCI{}, head)
+		assert.Equal(t, sendSN, head.(IAPCI).SendSN)
+		a := asdu.NewEmptyASDU(asdu.ParamsWide)
+		require.NoError(t, a.UnmarshalBinary(raw))
+		return a.GetSinglePoint()[0].Ioa
+	}
+	assertNothing := func(i int) {
+		_ = conns[i].SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := readers[i].ReadByte()
+		assert.Error(t, err)
+	}
+
+	sessions := srv.Sessions()
+	require.Len(t, sessions, 2)
+	assert.Less(t, sessions[0].ID(), sessions[1].ID())
+	assert.Equal(t, conns[0].LocalAddr().String(), sessions[0].RemoteAddr().String())
+
+	require.NoError(t, srv.SendTo(sessions[1].ID(), singlePoint(t, 1)))
+	assert.Equal(t, asdu.InfoObjAddr(1), readIOA(1, 0))
+	assertNothing(0)
+	assert.ErrorIs(t, srv.SendTo(0, singlePoint(t, 1)), ErrSessionNotFound)
+
+	// the reply of the handler only goes to the master which sent the command
+	cmd := asdu.NewASDU(asdu.ParamsWide, asdu.Identifier{
+		Type:       asdu.C_IC_NA_1,
+		Variable:   asdu.VariableStruct{Number: 1},
+		Coa:        asdu.CauseOfTransmission{Cause: asdu.Activation},
+		CommonAddr: 1,
+	})
+	require.NoError(t, cmd.AppendInfoObjAddr(asdu.InfoObjAddrIrrelevant))
+	cmd.AppendBytes(byte(asdu.QOIStation))
+	raw, err := cmd.MarshalBinary()
+	require.NoError(t, err)
+	frame, err := NewIFrame(0, 0, raw)
+	require.NoError(t, err)
+	_, err = conns[0].Write(frame)
+	require.NoError(t, err)
+	assert.Equal(t, asdu.InfoObjAddr(100), readIOA(0, 0))
+	assertNothing(1)
+
+	require.NoError(t, srv.DisconnectSession(sessions[0].ID()))
+	assert.ErrorIs(t, srv.DisconnectSession(0), ErrSessionNotFound)
+	require.Eventually(t, func() bool { return len(srv.Sessions()) == 1 }, time.Second, 10*time.Millisecond)
+
+	// broadcast reports the errors of each session
+	srv.SetOverflowPolicy(OverflowDropNewest)
+	require.NoError(t, srv.Send(singlePoint(t, 2)))
+	assert.Equal(t, asdu.InfoObjAddr(2), readIOA(1, 1))
+	_ = conns[1].Close()
+	require.Eventually(t, func() bool { return len(srv.Sessions()) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestSessionError(t *testing.T) {
+	err := errors.Join(&SessionError{ID: 1, Err: ErrBufferFulled}, &SessionError{ID: 2, Err: ErrUseClosedConnection})
+	assert.ErrorIs(t, err, ErrBufferFulled)
+	assert.ErrorIs(t, err, ErrUseClosedConnection)
+	var se *SessionError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, uint64(1), se.ID)
+	assert.Equal(t, "session 1: buffer is full", se.Error())
+}
+
+func TestServer_StopDt(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	addr := startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, err = conn.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+	require.NoError(t, srv.Send(singlePoint(t, 1)))
+	head, _ := ParseAPDU(readAPDU(t, conn, r))
+	assert.Equal(t, IAPCI{SendSN: 0}, head)
+
+	// the confirmation is held until the I-frame acknowledged
+	_, err = conn.Write(NewUFrame(UStopDtActive))
+	require.NoError(t, err)
+	_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, err = r.ReadByte()
+	assert.Error(t, err)
+	require.NoError(t, srv.Send(singlePoint(t, 2)))
+	_, err = conn.Write(NewSFrame(1))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStopDtConfirm), readAPDU(t, conn, r))
+
+	// the asdu queued while stopped is sent after STARTDT
+	_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, err = r.ReadByte()
+	assert.Error(t, err)
+	_, err = conn.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+	head, _ = ParseAPDU(readAPDU(t, conn, r))
+	assert.Equal(t, IAPCI{SendSN: 1}, head)
+}
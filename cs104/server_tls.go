@@ -0,0 +1,267 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TLSLimits the limits of the secure connection according to IEC 62351-3
+type TLSLimits struct {
+	// MinVersion minimum tls version, less than tls.VersionTLS12 will use tls.VersionTLS12
+	MinVersion uint16
+	// SessionKeyLifetime the maximum time a session can use the negotiated keys,
+	// the tls of go never renegotiates on server side and refuses the renegotiation
+	// requested by the client, so the session is closed when it expired, and the
+	// master has to reconnect with a new handshake. 0 means no limit, which
+	// does not meet IEC 62351-3 for a long-lived session.
+	SessionKeyLifetime time.Duration
+	// ResumptionLifetime the maximum time a session ticket can be used to resume,
+	// the expired ticket cause a full handshake. 0 means the default of crypto/tls(7 days),
+	// negative disable session resumption.
+	ResumptionLifetime time.Duration
+}
+
+// DefaultTLSLimits default tls limits, IEC 62351-3 requires the session keys
+// to be renewed periodically, so a session lasts at most 24 hours by default.
+func DefaultTLSLimits() TLSLimits {
+	return TLSLimits{
+		MinVersion:         tls.VersionTLS12,
+		SessionKeyLifetime: 24 * time.Hour,
+		ResumptionLifetime: 24 * time.Hour,
+	}
+}
+
+// sessionTicketTag tag of the ticket creation time in tls.SessionState.Extra
+const sessionTicketTag = "cs104-created:"
+
+// SetTLSLimits set the tls limits applied by ListenAndServeTLS and ReloadTLSConfig
+func (sf *Server) SetTLSLimits(l TLSLimits) *Server {
+	sf.tlsLimits = l
+	return sf
+}
+
+// SetPeerVerifier set the callback to verify the peer of the secure connection,
+// it's called after the client certificate chain verified, on every full or resumed handshake,
+// return error reject the connection. it must be called before ListenAndServeTLS.
+func (sf *Server) SetPeerVerifier(f func(cs tls.ConnectionState) error) *Server {
+	sf.peerVerifier = f
+	return sf
+}
+
+// ListenAndServeTLS run the secure server with TLSConfig, addr is host:port,
+// empty addr will listen on PortSecure. the client must present a certificate verified
+// by TLSConfig.ClientCAs, TLSConfig.ClientAuth is always tls.RequireAndVerifyClientCert,
+// a config setting any other value except the zero value is rejected.
+// the config returned by TLSConfig.GetConfigForClient is used for the handshake
+// with the same enforcement, nil falls back to TLSConfig.
+func (sf *Server) ListenAndServeTLS(addr string) error {
+	if err := sf.ReloadTLSConfig(sf.TLSConfig); err != nil {
+		return err
+	}
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", PortSecure)
+	}
+	listen, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go sf.Serve(tls.NewListener(listen, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			c := sf.tlsConfig.Load()
+			// crypto/tls 不调用嵌套的 GetConfigForClient, 由此处调用
+			if c.GetConfigForClient != nil {
+				if cc, err := c.GetConfigForClient(hello); err != nil || cc != nil {
+					return cc, err
+				}
+			}
+			return c, nil
+		},
+	}))
+	return nil
+}
+
+// ReloadTLSConfig replace the tls config used by the new handshakes,
+// such as new certificates or client CAs, the established sessions are not affected.
+func (sf *Server) ReloadTLSConfig(cfg *tls.Config) error {
+	c, err := sf.buildTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	sf.mux.Lock()
+	sf.TLSConfig = cfg
+	sf.mux.Unlock()
+	sf.tlsConfig.Store(c)
+	return nil
+}
+
+// buildTLSConfig clone the cfg and apply mutual authentication and tls limits,
+// the config returned by cfg.GetConfigForClient, if any, gets them as well.
+func (sf *Server) buildTLSConfig(cfg *tls.Config) (*tls.Config, error) {
+	if cfg == nil {
+		return nil, errors.New("tls config required")
+	}
+	if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+		return nil, errors.New("tls config has no certificate")
+	}
+	c, err := sf.hardenTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if get := cfg.GetConfigForClient; get != nil {
+		c.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			cc, err := get(hello)
+			if err != nil || cc == nil {
+				return nil, err
+			}
+			return sf.hardenTLSConfig(cc)
+		}
+	}
+	return c, nil
+}
+
+// hardenTLSConfig clone the cfg, force mutual authentication and apply the tls limits
+func (sf *Server) hardenTLSConfig(cfg *tls.Config) (*tls.Config, error) {
+	// IEC 62351-3 要求双向认证,不接受弱于 RequireAndVerifyClientCert 的配置, 未设置则使用它
+	if cfg.ClientAuth != tls.NoClientCert && cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("tls config client auth %v is weaker than RequireAndVerifyClientCert", cfg.ClientAuth)
+	}
+
+	limits := sf.tlsLimits
+	c := cfg.Clone()
+	c.GetConfigForClient = nil
+	if c.MinVersion < tls.VersionTLS12 {
+		c.MinVersion = tls.VersionTLS12
+	}
+	if c.MinVersion < limits.MinVersion {
+		c.MinVersion = limits.MinVersion
+	}
+	c.ClientAuth = tls.RequireAndVerifyClientCert
+
+	verify, peerVerifier := c.VerifyConnection, sf.peerVerifier
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("client certificate required")
+		}
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+		if peerVerifier != nil {
+			return peerVerifier(cs)
+		}
+		return nil
+	}
+
+	switch {
+	case limits.ResumptionLifetime < 0:
+		c.SessionTicketsDisabled = true
+	case limits.ResumptionLifetime > 0 && c.WrapSession == nil && c.UnwrapSession == nil:
+		c.WrapSession = func(cs tls.ConnectionState, ss *tls.SessionState) ([]byte, error) {
+			ss.Extra = append(ss.Extra, binary.BigEndian.AppendUint64([]byte(sessionTicketTag), uint64(time.Now().Unix())))
+			return c.EncryptTicket(cs, ss)
+		}
+		c.UnwrapSession = func(identity []byte, cs tls.ConnectionState) (*tls.SessionState, error) {
+			ss, err := c.DecryptTicket(identity, cs)
+			if err != nil || ss == nil {
+				return nil, err
+			}
+			created, ok := ticketCreated(ss.Extra)
+			if !ok || time.Since(created) > limits.ResumptionLifetime {
+				return nil, nil // 票据过期,完整握手
+			}
+			return ss, nil
+		}
+	}
+	return c, nil
+}
+
+// ticketCreated return the creation time of the ticket recorded by WrapSession
+func ticketCreated(extra [][]byte) (time.Time, bool) {
+	for _, v := range extra {
+		if len(v) == len(sessionTicketTag)+8 && string(v[:len(sessionTicketTag)]) == sessionTicketTag {
+			return time.Unix(int64(binary.BigEndian.Uint64(v[len(sessionTicketTag):])), 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// handshake complete the handshake of the secure connection before the session start,
+// return the connection state, nil if conn is not a tls connection.
+func (sf *Server) handshake(ctx context.Context, conn net.Conn) (*tls.ConnectionState, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, sf.config.ConnectTimeout0)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	cs := tlsConn.ConnectionState()
+	return &cs, nil
+}
+
+// CertReloader a certificate loaded from files which can be reloaded at runtime,
+// use GetCertificate as tls.Config.GetCertificate, or GetClientCertificate
+// as tls.Config.GetClientCertificate, the reloaded certificate only affects the new handshakes.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.Mutex
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+// NewCertReloader load the certificate from the PEM encoded files
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	sf := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := sf.Reload(); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// Reload reload the certificate from the files, the current one is kept if failed
+func (sf *CertReloader) Reload() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	cert, err := tls.LoadX509KeyPair(sf.certFile, sf.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	sf.cert.Store(&cert)
+	return nil
+}
+
+// Certificate return the current certificate
+func (sf *CertReloader) Certificate() *tls.Certificate {
+	return sf.cert.Load()
+}
+
+// GetCertificate imp tls.Config.GetCertificate
+func (sf *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return sf.cert.Load(), nil
+}
+
+// GetClientCertificate imp tls.Config.GetClientCertificate
+func (sf *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return sf.cert.Load(), nil
+}
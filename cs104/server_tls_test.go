@@ -0,0 +1,230 @@
+package cs104
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert, key, pool}
+}
+
+func (sf *testCA) issue(t *testing.T, cn string, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, sf.cert, &key.PublicKey, sf.key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writeCertFiles(t *testing.T, dir string, cert tls.Certificate) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	keyDer, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func startTLSClient(t *testing.T, addr string, cfg *tls.Config) *Client {
+	o := NewOption().SetTLSConfig(cfg)
+	require.NoError(t, o.AddRemoteServer("tls://"+addr))
+	c := NewClient(nopClientHandler{}, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	require.NoError(t, c.Start())
+	return c
+}
+
+func TestServer_ListenAndServeTLS(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	reloader, err := NewCertReloader(writeCertFiles(t, dir, ca.issue(t, "outstation", 2)))
+	require.NoError(t, err)
+
+	peers := make(chan string, 4)
+	srv := NewServer(nopServerHandler{})
+	srv.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate, ClientCAs: ca.pool}
+	srv.SetPeerVerifier(func(cs tls.ConnectionState) error {
+		if cs.PeerCertificates[0].Subject.CommonName == "intruder" {
+			return errors.New("unknown master")
+		}
+		return nil
+	})
+	srv.SetOnConnectionHandler(func(c asdu.Connect) {
+		peers <- SessionOf(c).PeerCertificate().Subject.CommonName
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	require.NoError(t, srv.ListenAndServeTLS(addr))
+	defer srv.Close()
+
+	master := ca.issue(t, "master", 3)
+	c := startTLSClient(t, addr, &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{master}})
+	defer c.Close()
+	require.Eventually(t, c.GetActiveStatus, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "master", <-peers)
+
+	// 证书热更新后,已建立的会话不受影响,新连接使用新证书
+	writeCertFiles(t, dir, ca.issue(t, "outstation", 4))
+	require.NoError(t, reloader.Reload())
+	assert.Equal(t, int64(4), reloader.Certificate().Leaf.SerialNumber.Int64())
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{master}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+	_ = conn.Close()
+	assert.True(t, c.GetActiveStatus())
+	<-peers
+
+	// 无客户端证书或未通过校验的连接被拒绝
+	for _, cfg := range []*tls.Config{
+		{RootCAs: ca.pool},
+		{RootCAs: ca.pool, Certificates: []tls.Certificate{ca.issue(t, "intruder", 5)}},
+		{RootCAs: ca.pool, Certificates: []tls.Certificate{newTestCA(t).issue(t, "master", 6)}},
+	} {
+		conn, err := tls.Dial("tcp", addr, cfg)
+		if err == nil {
+			// tls1.3 客户端证书在首次读时才返回错误
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1))
+			_ = conn.Close()
+		}
+		assert.Error(t, err)
+	}
+	select {
+	case p := <-peers:
+		t.Fatalf("unexpected session of %s", p)
+	default:
+	}
+}
+
+func TestServer_ListenAndServeTLS_GetConfigForClient(t *testing.T) {
+	ca := newTestCA(t)
+	outstation := ca.issue(t, "outstation", 2)
+	srv := NewServer(nopServerHandler{})
+	srv.TLSConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{Certificates: []tls.Certificate{outstation}, ClientCAs: ca.pool}, nil
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	require.NoError(t, srv.ListenAndServeTLS(addr))
+	defer srv.Close()
+
+	c := startTLSClient(t, addr, &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{ca.issue(t, "master", 3)}})
+	defer c.Close()
+	require.Eventually(t, c.GetActiveStatus, time.Second, 10*time.Millisecond)
+
+	// the returned config requires the client certificate as well
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: ca.pool})
+	if err == nil {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		_ = conn.Close()
+	}
+	assert.Error(t, err)
+
+	// a returned config asking for less is rejected
+	srv.TLSConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return &tls.Config{Certificates: []tls.Certificate{outstation}, ClientAuth: tls.RequestClientCert}, nil
+	}
+	c2, err := srv.buildTLSConfig(srv.TLSConfig)
+	require.NoError(t, err)
+	_, err = c2.GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.Error(t, err)
+}
+
+func TestServer_buildTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	srv := NewServer(nopServerHandler{})
+
+	_, err := srv.buildTLSConfig(nil)
+	assert.Error(t, err)
+	_, err = srv.buildTLSConfig(&tls.Config{})
+	assert.Error(t, err)
+
+	cert := ca.issue(t, "outstation", 2)
+	for _, auth := range []tls.ClientAuthType{tls.RequestClientCert, tls.RequireAnyClientCert, tls.VerifyClientCertIfGiven} {
+		_, err = srv.buildTLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: auth})
+		assert.Error(t, err, auth)
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	c, err := srv.buildTLSConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, c.ClientAuth)
+	assert.Equal(t, uint16(tls.VersionTLS12), c.MinVersion)
+	assert.NotNil(t, c.WrapSession)
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	srv.SetTLSLimits(TLSLimits{MinVersion: tls.VersionTLS13, ResumptionLifetime: -1})
+	c, err = srv.buildTLSConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), c.MinVersion)
+	assert.True(t, c.SessionTicketsDisabled)
+}
+
+func Test_ticketCreated(t *testing.T) {
+	_, ok := ticketCreated(nil)
+	assert.False(t, ok)
+	now := time.Unix(time.Now().Unix(), 0)
+	v, ok := ticketCreated([][]byte{[]byte("other"), binary.BigEndian.AppendUint64([]byte(sessionTicketTag), uint64(now.Unix()))})
+	assert.True(t, ok)
+	assert.Equal(t, now, v)
+}
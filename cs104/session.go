@@ -0,0 +1,88 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+// Session the identity and metadata of a server session,
+// handlers get it from the asdu.Connect with SessionOf.
+type Session struct {
+	id          uint64
+	remoteAddr  net.Addr
+	localAddr   net.Addr
+	connectTime time.Time
+	tlsState    *tls.ConnectionState
+	group       string
+	roles       []string
+
+	mu       sync.Mutex
+	userData interface{}
+}
+
+// ID the unique id of the session in the server, it never changes during the session
+func (sf *Session) ID() uint64 { return sf.id }
+
+// RemoteAddr the address of the master
+func (sf *Session) RemoteAddr() net.Addr { return sf.remoteAddr }
+
+// LocalAddr the local address of the connection
+func (sf *Session) LocalAddr() net.Addr { return sf.localAddr }
+
+// ConnectTime the time the session is established
+func (sf *Session) ConnectTime() time.Time { return sf.connectTime }
+
+// RedundancyGroup the name of the redundancy group the session belongs to, empty if none
+func (sf *Session) RedundancyGroup() string { return sf.group }
+
+// Roles the roles of the session granted by the access policy, see Server.SetAccessPolicy
+func (sf *Session) Roles() []string { return sf.roles }
+
+// TLSConnectionState the connection state of the secure connection,
+// false if the session is not over tls.
+func (sf *Session) TLSConnectionState() (tls.ConnectionState, bool) {
+	if sf.tlsState == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *sf.tlsState, true
+}
+
+// PeerCertificate the certificate of the master, nil if the session is not over tls.
+func (sf *Session) PeerCertificate() *x509.Certificate {
+	if sf.tlsState == nil || len(sf.tlsState.PeerCertificates) == 0 {
+		return nil
+	}
+	return sf.tlsState.PeerCertificates[0]
+}
+
+// UserData get the user data attached to the session
+func (sf *Session) UserData() interface{} {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return sf.userData
+}
+
+// SetUserData attach user data to the session, it's kept until the session ends
+func (sf *Session) SetUserData(v interface{}) {
+	sf.mu.Lock()
+	sf.userData = v
+	sf.mu.Unlock()
+}
+
+// SessionOf return the session of the connection passed to the handlers,
+// nil if c is not a server session.
+func SessionOf(c asdu.Connect) *Session {
+	if s, ok := c.(interface{ Session() *Session }); ok {
+		return s.Session()
+	}
+	return nil
+}
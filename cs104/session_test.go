@@ -0,0 +1,83 @@
+package cs104
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thinkgos/go-iecp5/asdu"
+)
+
+type sessionServerHandler struct {
+	nopServerHandler
+	calls chan interface{}
+}
+
+func (sf sessionServerHandler) InterrogationHandler(c asdu.Connect, _ *asdu.ASDU, _ asdu.QualifierOfInterrogation) error {
+	sf.calls <- SessionOf(c).UserData()
+	return nil
+}
+
+func TestSessionOf(t *testing.T) {
+	assert.Nil(t, SessionOf(&Server{}))
+
+	handler := sessionServerHandler{calls: make(chan interface{}, 4)}
+	sessions := make(chan *Session, 4)
+	srv := NewServer(handler)
+	srv.SetOnConnectionHandler(func(c asdu.Connect) {
+		s := SessionOf(c)
+		s.SetUserData(s.ID())
+		sessions <- s
+	})
+	addr := startTestServer(t, srv)
+
+	var got []*Session
+	for i := 0; i < 2; i++ {
+		o := NewOption()
+		require.NoError(t, o.AddRemoteServer(addr))
+		c := NewClient(nopClientHandler{}, o)
+		c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+		require.NoError(t, c.Start())
+		defer c.Close()
+		require.Eventually(t, c.GetActiveStatus, time.Second, 10*time.Millisecond)
+
+		s := <-sessions
+		assert.Equal(t, c.UnderlyingConn().LocalAddr().String(), s.RemoteAddr().String())
+		assert.Equal(t, addr, s.LocalAddr().String())
+		assert.WithinDuration(t, time.Now(), s.ConnectTime(), time.Second)
+		assert.Empty(t, s.RedundancyGroup())
+		assert.Nil(t, s.PeerCertificate())
+		_, ok := s.TLSConnectionState()
+		assert.False(t, ok)
+
+		require.NoError(t, c.InterrogationCmd(asdu.CauseOfTransmission{Cause: asdu.Activation}, 1, asdu.QOIStation))
+		select {
+		case v := <-handler.calls:
+			assert.Equal(t, s.ID(), v)
+		case <-time.After(time.Second):
+			t.Fatal("wait interrogation timeout")
+		}
+		got = append(got, s)
+	}
+	assert.NotEqual(t, got[0].ID(), got[1].ID())
+}
+
+func TestSessionOf_RedundancyGroup(t *testing.T) {
+	sessions := make(chan *Session, 1)
+	srv := NewServer(nopServerHandler{})
+	require.NoError(t, srv.AddRedundancyGroup(RedundancyGroup{Name: "scada", Clients: []string{"127.0.0.1"}}))
+	srv.SetOnConnectionHandler(func(c asdu.Connect) { sessions <- SessionOf(c) })
+	addr := startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	select {
+	case s := <-sessions:
+		assert.Equal(t, "scada", s.RedundancyGroup())
+	case <-time.After(time.Second):
+		t.Fatal("wait session timeout")
+	}
+}
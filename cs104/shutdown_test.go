@@ -0,0 +1,188 @@
+package cs104
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acceptOutstation start a client connected to a raw outstation, data transfer activated
+func acceptOutstation(t *testing.T) (*Client, net.Conn, *bufio.Reader) {
+	t.Helper()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listen.Close()
+
+	o := NewOption().SetAutoReconnect(false)
+	require.NoError(t, o.AddRemoteServer(listen.Addr().String()))
+	c := NewClient(nopClientHandler{}, o)
+	c.SetOnConnectHandler(func(c *Client) { c.SendStartDt() })
+	require.NoError(t, c.Start())
+	t.Cleanup(func() { _ = c.Close() })
+
+	conn, err := listen.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	r := bufio.NewReader(conn)
+	require.Equal(t, NewUFrame(UStartDtActive), readAPDU(t, conn, r))
+	_, err = conn.Write(NewUFrame(UStartDtConfirm))
+	require.NoError(t, err)
+	require.Eventually(t, c.GetActiveStatus, time.Second, 10*time.Millisecond)
+	return c, conn, r
+}
+
+func TestClient_Shutdown(t *testing.T) {
+	c, conn, r := acceptOutstation(t)
+
+	require.NoError(t, c.Send(singleCmd(t, 1, 100)))
+	head, _ := ParseAPDU(readAPDU(t, conn, r))
+	assert.Equal(t, IAPCI{SendSN: 0}, head)
+
+	result := make(chan error, 1)
+	go func() { result <- c.Shutdown(context.Background()) }()
+	require.Eventually(t, func() bool { return c.Send(singleCmd(t, 1, 100)) == ErrShuttingDown }, time.Second, 10*time.Millisecond)
+
+	// STOPDT only after the I-frame acknowledged
+	_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, err := r.ReadByte()
+	assert.Error(t, err)
+	_, err = conn.Write(NewSFrame(1))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStopDtActive), readAPDU(t, conn, r))
+	_, err = conn.Write(NewUFrame(UStopDtConfirm))
+	require.NoError(t, err)
+
+	select {
+	case err = <-result:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("wait shutdown timeout")
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = r.ReadByte()
+	assert.Error(t, err, "connection closed")
+	assert.Equal(t, StateClosed, c.State())
+}
+
+func TestClient_ShutdownTimeout(t *testing.T) {
+	c, conn, r := acceptOutstation(t)
+	require.NoError(t, c.Send(singleCmd(t, 1, 100)))
+	readAPDU(t, conn, r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Equal(t, StateClosed, c.State())
+
+	// not running
+	assert.NoError(t, NewClient(nopClientHandler{}, NewOption()).Shutdown(context.Background()))
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	addr := startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, err = conn.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+	require.NoError(t, srv.Send(singlePoint(t, 1)))
+	head, _ := ParseAPDU(readAPDU(t, conn, r))
+	assert.Equal(t, IAPCI{SendSN: 0}, head)
+
+	result := make(chan error, 1)
+	go func() { result <- srv.Shutdown(context.Background()) }()
+	require.Eventually(t, func() bool { return srv.Send(singlePoint(t, 2)) == ErrShuttingDown }, time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, srv.SendTo(1, singlePoint(t, 2)), ErrShuttingDown)
+	_, err = net.DialTimeout("tcp", addr, 100*time.Millisecond)
+	assert.Error(t, err, "listener closed")
+
+	// the session is kept until the I-frame acknowledged
+	_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	_, err = r.ReadByte()
+	assert.Error(t, err)
+	assert.Len(t, srv.Sessions(), 1)
+	_, err = conn.Write(NewSFrame(1))
+	require.NoError(t, err)
+
+	select {
+	case err = <-result:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("wait shutdown timeout")
+	}
+	assert.Empty(t, srv.Sessions())
+	assert.NoError(t, srv.Send(singlePoint(t, 3)), "accept sends again after shutdown finished")
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	addr := startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, err = conn.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+	require.NoError(t, srv.Send(singlePoint(t, 1)))
+	readAPDU(t, conn, r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Empty(t, srv.Sessions())
+}
+
+// memLogger keep the warnings logged
+type memLogger struct {
+	mu    sync.Mutex
+	warns []string
+}
+
+func (sf *memLogger) Critical(string, ...interface{}) {}
+func (sf *memLogger) Error(string, ...interface{})    {}
+func (sf *memLogger) Debug(string, ...interface{})    {}
+func (sf *memLogger) Warn(format string, v ...interface{}) {
+	sf.mu.Lock()
+	sf.warns = append(sf.warns, fmt.Sprintf(format, v...))
+	sf.mu.Unlock()
+}
+
+func (sf *memLogger) get() []string {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return append([]string(nil), sf.warns...)
+}
+
+func TestServer_ShutdownInactive(t *testing.T) {
+	logger := &memLogger{}
+	srv := NewServer(nopServerHandler{})
+	srv.SetLogProvider(logger)
+	srv.LogMode(true)
+	addr := startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return len(srv.Sessions()) == 1 }, time.Second, 10*time.Millisecond)
+	// queued for the next STARTDT
+	require.NoError(t, srv.Send(singlePoint(t, 1)))
+	require.NoError(t, srv.Send(singlePoint(t, 2)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx))
+	assert.Contains(t, logger.get(), "session drained with data transfer stopped, 2 queued asdus discarded")
+}
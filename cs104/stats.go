@@ -0,0 +1,150 @@
+// Copyright 2020 thinkgos (thinkgo@aliyun.com).  All rights reserved.
+// Use of this source code is governed by a version 3 of the GNU General
+// Public License, license that can be found in the LICENSE file.
+
+package cs104
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// LinkStats the statistics snapshot of a link, the counters are cumulative,
+// the client keeps them across the reconnections.
+type LinkStats struct {
+	IFramesSent     uint64
+	IFramesReceived uint64
+	SFramesSent     uint64
+	SFramesReceived uint64
+	UFramesSent     uint64
+	UFramesReceived uint64
+	BytesSent       uint64
+	BytesReceived   uint64
+
+	T1Timeouts uint64 // 确认超时t₁次数
+	T2Acks     uint64 // t₂超时发送的S帧数
+	T3Tests    uint64 // t₃空闲发送的TESTFR数
+	Reconnects uint64 // 重连次数,仅客户端
+
+	Unacked      int           // 已发送未确认的I帧数, k窗口占用
+	SendQueue    int           // 待发送的ASDU数
+	LastReceived time.Time     // 最近一次收到数据的时间,未收到时为零值
+	RTT          time.Duration // 最近一次I帧发送到确认的时间
+}
+
+// SessionStats the statistics of a server session
+type SessionStats struct {
+	ID         uint64
+	RemoteAddr string
+	LinkStats
+}
+
+// ServerStats the statistics snapshot of a server
+type ServerStats struct {
+	Sessions       []SessionStats // 按会话id排序
+	ProtocolErrors ProtocolErrorStats
+}
+
+// linkCounters the counters of a link, safe for concurrent use
+type linkCounters struct {
+	iSent, iRcv uint64
+	sSent, sRcv uint64
+	uSent, uRcv uint64
+	bytesSent   uint64
+	bytesRcv    uint64
+	t1, t2, t3  uint64
+	reconnects  uint64
+	unacked     int64
+	lastRcv     int64 // unix nano
+	rtt         int64 // nanosecond
+}
+
+// frameCounter return the counter of the frame type of the apdu
+func frameCounter(apdu []byte, i, s, u *uint64) *uint64 {
+	if len(apdu) < APCICtlFiledSize+2 {
+		return nil
+	}
+	switch {
+	case apdu[2]&0x01 == 0:
+		return i
+	case apdu[2]&0x03 == 0x01:
+		return s
+	default:
+		return u
+	}
+}
+
+func (sf *linkCounters) sent(apdu []byte) {
+	atomic.AddUint64(&sf.bytesSent, uint64(len(apdu)))
+	if c := frameCounter(apdu, &sf.iSent, &sf.sSent, &sf.uSent); c != nil {
+		atomic.AddUint64(c, 1)
+	}
+}
+
+func (sf *linkCounters) received(apdu []byte) {
+	atomic.AddUint64(&sf.bytesRcv, uint64(len(apdu)))
+	atomic.StoreInt64(&sf.lastRcv, time.Now().UnixNano())
+	if c := frameCounter(apdu, &sf.iRcv, &sf.sRcv, &sf.uRcv); c != nil {
+		atomic.AddUint64(c, 1)
+	}
+}
+
+// acked the I-frame sent at sendTime is acknowledged
+func (sf *linkCounters) acked(sendTime time.Time) {
+	atomic.StoreInt64(&sf.rtt, int64(time.Since(sendTime)))
+}
+
+func (sf *linkCounters) setUnacked(n int) {
+	atomic.StoreInt64(&sf.unacked, int64(n))
+}
+
+func (sf *linkCounters) snapshot(sendQueue int) LinkStats {
+	s := LinkStats{
+		IFramesSent:     atomic.LoadUint64(&sf.iSent),
+		IFramesReceived: atomic.LoadUint64(&sf.iRcv),
+		SFramesSent:     atomic.LoadUint64(&sf.sSent),
+		SFramesReceived: atomic.LoadUint64(&sf.sRcv),
+		UFramesSent:     atomic.LoadUint64(&sf.uSent),
+		UFramesReceived: atomic.LoadUint64(&sf.uRcv),
+		BytesSent:       atomic.LoadUint64(&sf.bytesSent),
+		BytesReceived:   atomic.LoadUint64(&sf.bytesRcv),
+		T1Timeouts:      atomic.LoadUint64(&sf.t1),
+		T2Acks:          atomic.LoadUint64(&sf.t2),
+		T3Tests:         atomic.LoadUint64(&sf.t3),
+		Reconnects:      atomic.LoadUint64(&sf.reconnects),
+		Unacked:         int(atomic.LoadInt64(&sf.unacked)),
+		SendQueue:       sendQueue,
+		RTT:             time.Duration(atomic.LoadInt64(&sf.rtt)),
+	}
+	if t := atomic.LoadInt64(&sf.lastRcv); t != 0 {
+		s.LastReceived = time.Unix(0, t)
+	}
+	return s
+}
+
+// Stats the statistics snapshot of the client
+func (sf *Client) Stats() LinkStats {
+	return sf.stats.snapshot(len(sf.sendASDU))
+}
+
+// Stats the statistics snapshot of the session
+func (sf *SrvSession) Stats() LinkStats {
+	return sf.stats.snapshot(len(sf.sendASDU) + sf.events.remaining(sf))
+}
+
+// Stats the statistics snapshot of the server and its sessions
+func (sf *Server) Stats() ServerStats {
+	sf.mux.Lock()
+	sessions := make([]SessionStats, 0, len(sf.sessions))
+	for sess := range sf.sessions {
+		s := SessionStats{ID: sess.session.id, LinkStats: sess.Stats()}
+		if sess.session.remoteAddr != nil {
+			s.RemoteAddr = sess.session.remoteAddr.String()
+		}
+		sessions = append(sessions, s)
+	}
+	sf.mux.Unlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return ServerStats{Sessions: sessions, ProtocolErrors: sf.ProtocolErrorStats()}
+}
@@ -0,0 +1,117 @@
+package cs104
+
+import (
+	"bufio"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Stats(t *testing.T) {
+	srv := NewServer(nopServerHandler{})
+	addr := startTestServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, err = conn.Write(NewUFrame(UStartDtActive))
+	require.NoError(t, err)
+	assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+	require.NoError(t, srv.Send(singlePoint(t, 1)))
+	iframe := readAPDU(t, conn, r)
+
+	require.Eventually(t, func() bool {
+		st := srv.Stats()
+		return len(st.Sessions) == 1 && st.Sessions[0].Unacked == 1
+	}, time.Second, 10*time.Millisecond)
+	_, err = conn.Write(NewSFrame(1))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return srv.Stats().Sessions[0].SFramesReceived == 1 }, time.Second, 10*time.Millisecond)
+
+	s := srv.Stats().Sessions[0]
+	assert.Equal(t, uint64(1), s.ID)
+	assert.Equal(t, conn.LocalAddr().String(), s.RemoteAddr)
+	assert.Equal(t, uint64(1), s.IFramesSent)
+	assert.Equal(t, uint64(1), s.UFramesSent)
+	assert.Equal(t, uint64(1), s.UFramesReceived)
+	assert.Equal(t, uint64(6+len(iframe)), s.BytesSent)
+	assert.Equal(t, uint64(12), s.BytesReceived)
+	assert.Equal(t, 0, s.Unacked)
+	assert.Equal(t, 0, s.SendQueue)
+	assert.Greater(t, s.RTT, time.Duration(0))
+	assert.WithinDuration(t, time.Now(), s.LastReceived, time.Second)
+
+	rec := httptest.NewRecorder()
+	srv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, body, "# TYPE iec104_frames_sent_total counter\n")
+	assert.Contains(t, body, `iec104_frames_sent_total{session="1",remote="`+s.RemoteAddr+`",type="I"} 1`)
+	assert.Contains(t, body, "iec104_sessions 1\n")
+	assert.Contains(t, body, `iec104_protocol_errors_total{error="sequence"} 0`)
+	assert.Equal(t, 1, strings.Count(body, "# HELP iec104_bytes_sent_total"))
+}
+
+func TestServer_StatsSendQueue(t *testing.T) {
+	store, err := OpenFileEventStore(t.TempDir() + "/events.log")
+	require.NoError(t, err)
+	defer store.Close()
+	srv := NewServer(nopServerHandler{})
+	srv.SetEventBuffer(8, OverflowDropNewest)
+	require.NoError(t, srv.SetEventStore(store))
+	addr := startTestServer(t, srv)
+
+	conns := make([]net.Conn, 2)
+	readers := make([]*bufio.Reader, 2)
+	for i := range conns {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		_, err = conn.Write(NewUFrame(UStartDtActive))
+		require.NoError(t, err)
+		assert.Equal(t, NewUFrame(UStartDtConfirm), readAPDU(t, conn, r))
+		conns[i], readers[i] = conn, r
+	}
+	require.NoError(t, srv.Send(singlePoint(t, 1)))
+	require.NoError(t, srv.Send(singlePoint(t, 2)))
+
+	// only the first master acknowledged, the events are still buffered for the second
+	readAPDU(t, conns[0], readers[0])
+	readAPDU(t, conns[0], readers[0])
+	_, err = conns[0].Write(NewSFrame(2))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		st := srv.Stats()
+		return len(st.Sessions) == 2 && st.Sessions[0].SendQueue == 0 && st.Sessions[1].SendQueue == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, srv.EventBufferStats().Len)
+}
+
+func TestClient_Stats(t *testing.T) {
+	c, conn, r := acceptOutstation(t)
+	require.NoError(t, c.Send(singleCmd(t, 1, 100)))
+	readAPDU(t, conn, r)
+	require.Eventually(t, func() bool { return c.Stats().Unacked == 1 }, time.Second, 10*time.Millisecond)
+	_, err := conn.Write(NewSFrame(1))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return c.Stats().Unacked == 0 }, time.Second, 10*time.Millisecond)
+
+	s := c.Stats()
+	assert.Equal(t, uint64(1), s.IFramesSent)
+	assert.Equal(t, uint64(1), s.UFramesSent)
+	assert.Equal(t, uint64(1), s.UFramesReceived)
+	assert.Equal(t, uint64(1), s.SFramesReceived)
+	assert.Equal(t, uint64(0), s.Reconnects)
+
+	rec := httptest.NewRecorder()
+	c.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `iec104_up{remote="`+conn.LocalAddr().String()+`"} 1`)
+	assert.Contains(t, rec.Body.String(), `iec104_frames_sent_total{type="I"} 1`)
+}
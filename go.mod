@@ -6,9 +6,7 @@ require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/spf13/cast v1.9.2
 	github.com/stretchr/testify v1.10.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
-)
+require github.com/pmezard/go-difflib v1.0.0 // indirect
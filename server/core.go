@@ -1,6 +1,9 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"net/http"
 	"strconv"
 
 	"github.com/thinkgos/go-iecp5/asdu"
@@ -14,6 +17,7 @@ type Settings struct {
 	Port   int
 	Cfg104 *cs104.Config //104协议规范配置
 	Params *asdu.Params  //ASDU相关特定参数
+	TLS    *tls.Config   //tls配置,设置后以IEC 62351-3双向认证方式监听
 	LogCfg *LogCfg
 }
 
@@ -41,6 +45,7 @@ func New(settings *Settings, handler cs104.ServerHandlerInterface) *Server {
 	cs104Server := cs104.NewServer(handler)
 	cs104Server.SetConfig(*settings.Cfg104)
 	cs104Server.SetParams(settings.Params)
+	cs104Server.TLSConfig = settings.TLS
 
 	logCfg := settings.LogCfg
 	if logCfg != nil {
@@ -56,13 +61,26 @@ func New(settings *Settings, handler cs104.ServerHandlerInterface) *Server {
 
 func (s *Server) Start() error {
 	addr := s.settings.Host + ":" + strconv.Itoa(s.settings.Port)
+	if s.settings.TLS != nil {
+		return s.cs104Server.ListenAndServeTLS(addr)
+	}
 	return s.cs104Server.ListenAndServer(addr)
 }
 
+// ReloadTLSConfig 热更新tls配置,只影响之后建立的连接
+func (s *Server) ReloadTLSConfig(cfg *tls.Config) error {
+	return s.cs104Server.ReloadTLSConfig(cfg)
+}
+
 func (s *Server) Stop() error {
 	return s.cs104Server.Close()
 }
 
+// Shutdown stop accepting and close the sessions after the in-flight I-frames acknowledged
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.cs104Server.Shutdown(ctx)
+}
+
 // SetOnConnectionHandler set on connect handler
 func (s *Server) SetOnConnectionHandler(f func(asdu.Connect)) {
 	s.cs104Server.SetOnConnectionHandler(f)
@@ -73,6 +91,96 @@ func (s *Server) SetConnectionLostHandler(f func(asdu.Connect)) {
 	s.cs104Server.SetConnectionLostHandler(f)
 }
 
-func (s *Server) Send(pack *asdu.ASDU) {
-	s.cs104Server.Send(pack)
+func (s *Server) Send(pack *asdu.ASDU) error {
+	return s.cs104Server.Send(pack)
+}
+
+// SendContext send asdu to all sessions, block until sent or ctx done
+func (s *Server) SendContext(ctx context.Context, pack *asdu.ASDU) error {
+	return s.cs104Server.SendContext(ctx, pack)
+}
+
+// SetEventBuffer enable the event buffer while no master has data transfer active
+func (s *Server) SetEventBuffer(size int, policy cs104.OverflowPolicy) {
+	s.cs104Server.SetEventBuffer(size, policy)
+}
+
+// SetEventStore persist the buffered events, call after SetEventBuffer
+func (s *Server) SetEventStore(store cs104.EventStore) error {
+	return s.cs104Server.SetEventStore(store)
+}
+
+// AddRedundancyGroup add a redundancy group of the controlling station
+func (s *Server) AddRedundancyGroup(g cs104.RedundancyGroup) error {
+	return s.cs104Server.AddRedundancyGroup(g)
+}
+
+// EventBufferStats returns the event buffer stats
+func (s *Server) EventBufferStats() cs104.EventBufferStats {
+	return s.cs104Server.EventBufferStats()
+}
+
+// SetOverflowPolicy set the policy when session send buffer is full
+func (s *Server) SetOverflowPolicy(p cs104.OverflowPolicy) {
+	s.cs104Server.SetOverflowPolicy(p)
+}
+
+// Sessions returns the connected sessions
+func (s *Server) Sessions() []*cs104.Session {
+	return s.cs104Server.Sessions()
+}
+
+// SendTo send asdu only to the session with the id
+func (s *Server) SendTo(id uint64, pack *asdu.ASDU) error {
+	return s.cs104Server.SendTo(id, pack)
+}
+
+// DisconnectSession close the session with the id
+func (s *Server) DisconnectSession(id uint64) error {
+	return s.cs104Server.DisconnectSession(id)
+}
+
+// SetAdmissionPolicy set the admission control of the new connections
+func (s *Server) SetAdmissionPolicy(p cs104.AdmissionPolicy) error {
+	return s.cs104Server.SetAdmissionPolicy(p)
+}
+
+// SetAdmissionHandler set the callback to reject a connection before the session starts
+func (s *Server) SetAdmissionHandler(f func(*cs104.Session) error) {
+	s.cs104Server.SetAdmissionHandler(f)
+}
+
+// SetAccessPolicy enable the role based access control of the commands
+func (s *Server) SetAccessPolicy(p cs104.AccessPolicy) error {
+	return s.cs104Server.SetAccessPolicy(p)
+}
+
+// SetAuditSink set the sink of the audit records of the commands
+func (s *Server) SetAuditSink(sink cs104.AuditSink) {
+	s.cs104Server.SetAuditSink(sink)
+}
+
+// SetProtocolErrorHandler set the handler called when a session detects a protocol error
+func (s *Server) SetProtocolErrorHandler(f func(c asdu.Connect, err error)) {
+	s.cs104Server.SetProtocolErrorHandler(f)
+}
+
+// ProtocolErrorStats the protocol errors of all the sessions
+func (s *Server) ProtocolErrorStats() cs104.ProtocolErrorStats {
+	return s.cs104Server.ProtocolErrorStats()
+}
+
+// Stats the statistics snapshot of the server and its sessions
+func (s *Server) Stats() cs104.ServerStats {
+	return s.cs104Server.Stats()
+}
+
+// MetricsHandler return a http.Handler exposing the statistics in the prometheus text format
+func (s *Server) MetricsHandler() http.Handler {
+	return s.cs104Server.MetricsHandler()
+}
+
+// SetFrameObserver set the observer of the frames of all the sessions
+func (s *Server) SetFrameObserver(o cs104.FrameObserver) {
+	s.cs104Server.SetFrameObserver(o)
 }